@@ -11,6 +11,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
 )
 
 var (
@@ -606,9 +609,58 @@ func parseSearchResult(r io.Reader) (searchResults, error) {
 	return res, nil
 }
 
+// maxPatchSize is the maximum size in bytes of the body of a PATCH request.
+const maxPatchSize = 1 << 20
+
+// db stores the triples of each resource in a named graph, to which PATCH
+// requests are applied.
+var db *malle.Store
+
+// resourceGraph returns the named graph holding the triples of the
+// resource at the given path.
+func resourceGraph(path string) rdf.IRI {
+	return rdf.IRI("http://data.deichman.no" + path)
+}
+
+// loadResources stores the triples of each resource in its graph, unless
+// allready stored, so that patched resources are kept across restarts.
+func loadResources() error {
+	graphs, err := db.ListGraphs()
+	if err != nil {
+		return err
+	}
+	stored := make(map[rdf.IRI]bool, len(graphs))
+	for _, g := range graphs {
+		stored[g] = true
+	}
+	for path, res := range resources {
+		g := resourceGraph(path)
+		if stored[g] {
+			continue
+		}
+		if err := db.ImportGraph(rdf.NewNTDecoder(strings.NewReader(res)).DecodeAll(), g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type patchResult struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
 func main() {
 	log.SetFlags(0)
 
+	var err error
+	if db, err = malle.Init("kobleren.db"); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadResources(); err != nil {
+		log.Fatal(err)
+	}
+
 	dummyDB := make(map[string]searchResults)
 	for _, filename := range []string{"bach", "oslo", "åsen", "hamsun"} {
 		f, err := os.Open(filename + ".json")
@@ -706,20 +758,45 @@ func main() {
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 
-		if r.Method == "GET" {
-			path := r.URL.Path
-			res, ok := resources[path]
-			if !ok {
-				http.Error(w, "not found", http.StatusNotFound)
+		path := r.URL.Path
+		if _, ok := resources[path]; !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "application/n-triples")
+			if _, err := db.Export(w, malle.ExportOptions{Graphs: []rdf.IRI{resourceGraph(path)}}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			w.Header().Set("Content-Type", "application/n-triples")
-			if _, err := w.Write([]byte(res)); err != nil {
+		case "PATCH":
+			p, err := malle.ParsePatch(http.MaxBytesReader(w, r.Body, maxPatchSize))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// The triples are added to and removed from the graph of
+			// the resource.
+			g := resourceGraph(path)
+			for i, c := range p.Changes {
+				if c.Graph == malle.DefaultGraph {
+					p.Changes[i].Graph = g
+				} else if c.Graph != g {
+					http.Error(w, fmt.Sprintf("cannot patch graph %v of resource %s", c.Graph, path), http.StatusBadRequest)
+					return
+				}
+			}
+			added, removed, err := db.ApplyPatch(p)
+			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-		}
-		if r.Method != "PATCH" {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(patchResult{Added: added, Removed: removed}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		default:
 			http.Error(w, "not allowed", http.StatusMethodNotAllowed)
 		}
 	})
@@ -0,0 +1,97 @@
+package malle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrInvalidBackup is the error of a backup which is not a valid malle
+// database. Restore returns it wrapped in a *BackupError.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// BackupError is returned when restoring from a file which is not a valid
+// malle database. Cause describes what is wrong with it.
+type BackupError struct {
+	Cause error
+}
+
+func (e *BackupError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrInvalidBackup, e.Cause)
+}
+
+// Unwrap returns ErrInvalidBackup.
+func (e *BackupError) Unwrap() error {
+	return ErrInvalidBackup
+}
+
+// Backup writes a consistent snapshot of the database to the given writer,
+// returning the number of bytes written. The snapshot is taken within a read
+// transaction, so readers and writers can keep using the store meanwhile.
+func (db *Store) Backup(w io.Writer) (n int64, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Restore replaces the database file with a backup read from r. The backup
+// is written to a temporary file next to the database file, and validated
+// before it is moved into place, so the database file is left untouched if
+// the backup is invalid.
+//
+// The database file must not be open while restoring.
+func Restore(r io.Reader, file string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".restore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op when moved into place
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = validateBackup(tmp.Name()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// validateBackup checks that the file is a consistent bolt database holding
+// all the buckets of a malle store.
+func validateBackup(file string) error {
+	kv, err := bolt.Open(file, 0600, nil)
+	if err != nil {
+		return &BackupError{Cause: err}
+	}
+	defer kv.Close()
+
+	return kv.View(func(tx *bolt.Tx) error {
+		// The named graph indices are not required, since they are
+		// created by Init if missing.
+		for _, b := range [][]byte{bTerms, bIdxTerms, bDT, bIdxDT, bSPO, bOSP, bPOS, bNS, bIdxNS} {
+			if tx.Bucket(b) == nil {
+				return &BackupError{Cause: fmt.Errorf("missing bucket %q", b)}
+			}
+		}
+		// The check must run to completion, even if it finds errors.
+		var first error
+		for err := range tx.Check() {
+			if first == nil {
+				first = &BackupError{Cause: err}
+			}
+		}
+		return first
+	})
+}
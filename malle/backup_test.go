@@ -0,0 +1,96 @@
+package malle
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	tr := rdf.NewTriple("bu1", "bup", mustNewLiteral("backed up"))
+	if err := testDB.AddTriple(tr); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	n, err := testDB.Backup(&b)
+	if err != nil || n != int64(b.Len()) {
+		t.Fatalf("Store.Backup() == %d, %v; want %d, <nil>", n, err, b.Len())
+	}
+	stats := testDB.Stats()
+
+	const file = "_restored.db"
+	defer os.Remove(file)
+	if err := Restore(bytes.NewReader(b.Bytes()), file); err != nil {
+		t.Fatalf("Restore() == %v; want no error", err)
+	}
+
+	restored, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if ok, err := restored.HasTriple(tr); err != nil || !ok {
+		t.Errorf("restored Store.HasTriple(%v) == %v, %v; want true, <nil>", tr, ok, err)
+	}
+	if got := restored.Stats(); got.NumTriples != stats.NumTriples || got.NumTerms != stats.NumTerms {
+		t.Errorf("restored Store.Stats() == %+v; want %+v", got, stats)
+	}
+}
+
+func TestRestoreInvalidBackup(t *testing.T) {
+	const file = "_notrestored.db"
+	if err := ioutil.WriteFile(file, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	for _, backup := range []string{"", "not a database"} {
+		err := Restore(strings.NewReader(backup), file)
+		if berr, ok := err.(*BackupError); !ok || berr.Cause == nil || berr.Unwrap() != ErrInvalidBackup {
+			t.Errorf("Restore(%q) == %v; want *BackupError wrapping %v", backup, err, ErrInvalidBackup)
+		}
+	}
+
+	// A bolt database without the buckets of a store.
+	const empty = "_empty.db"
+	kv, err := bolt.Open(empty, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Close()
+	defer os.Remove(empty)
+	b, err := ioutil.ReadFile(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Restore(bytes.NewReader(b), file)
+	if berr, ok := err.(*BackupError); !ok || !strings.Contains(berr.Cause.Error(), "missing bucket") {
+		t.Errorf("Restore(empty database) == %v; want *BackupError with missing bucket", err)
+	}
+
+	// Errors reading the backup are not invalid backups.
+	readErr := errors.New("read failed")
+	if err := Restore(errReader{readErr}, file); err != readErr {
+		t.Errorf("Restore(failing reader) == %v; want %v", err, readErr)
+	}
+
+	if b, _ := ioutil.ReadFile(file); string(b) != "original" {
+		t.Errorf("Restore() of invalid backup modified the database file")
+	}
+}
+
+// errReader is a reader which fails with the given error.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
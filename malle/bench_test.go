@@ -1,6 +1,7 @@
 package malle
 
 import (
+	"fmt"
 	"math/rand"
 	"testing"
 
@@ -117,6 +118,20 @@ func genRandGraph(n int) rdf.Graph {
 	return g
 }
 
+// genCatalogueGraph generates a graph of n records, shaped like a library
+// catalogue, where many triples share predicate and object.
+func genCatalogueGraph(n int) rdf.Graph {
+	g := rdf.NewGraph()
+	for i := 0; i < n; i++ {
+		s := mustNewIRI("http://example.org/title/" + genRandSCIIString(10))
+		g.Add(rdf.NewTriple(s, mustNewIRI("http://www.w3.org/1999/02/22-rdf-syntax-ns#type"), mustNewIRI("http://example.org/Work")))
+		g.Add(rdf.NewTriple(s, mustNewIRI("http://purl.org/dc/terms/language"), mustNewIRI(fmt.Sprintf("http://lexvo.org/id/iso639-3/l%d", rnd.Intn(5)))))
+		g.Add(rdf.NewTriple(s, mustNewIRI("http://purl.org/dc/terms/format"), mustNewIRI(fmt.Sprintf("http://example.org/format/f%d", rnd.Intn(5)))))
+		g.Add(rdf.NewTriple(s, mustNewIRI("http://www.w3.org/2000/01/rdf-schema#label"), genRandLiteral()))
+	}
+	return g
+}
+
 func BenchmarkAddTriple(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		err := testDB.AddTriple(genRandTriple())
@@ -152,3 +167,30 @@ func BenchmarkImportGraphOf1000(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkBulkImportGraphOf1000(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		err := testDB.BulkImportGraph(genRandGraph(1000))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkImportCatalogueOf1000(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		err := testDB.ImportGraph(genCatalogueGraph(1000))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBulkImportCatalogueOf1000(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		err := testDB.BulkImportGraph(genCatalogueGraph(1000))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package malle
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// Variable is a named variable in a triple pattern. It satisfies the rdf.Term
+// interface, so it can be used in any position of a pattern.
+type Variable string
+
+// Bytes returns nil, a Variable has no encoded byte representation.
+func (v Variable) Bytes() []byte { return nil }
+
+// String returns the Variable in SPARQL notation, ex: ?name.
+func (v Variable) String() string { return "?" + string(v) }
+
+// Value returns the name of the Variable.
+func (v Variable) Value() interface{} { return string(v) }
+
+// Eq tests if the Variable is equal to another Term.
+func (v Variable) Eq(other rdf.Term) bool {
+	o, ok := other.(Variable)
+	return ok && o == v
+}
+
+// Pattern is a triple pattern, where any of the positions can be a Variable.
+type Pattern struct {
+	Subject   rdf.Term
+	Predicate rdf.Term
+	Object    rdf.Term
+}
+
+// Binding maps variables to the terms they are bound to in a solution.
+type Binding map[Variable]rdf.Term
+
+// BGP represents a basic graph pattern query; a conjunction of triple patterns
+// which may share variables.
+type BGP struct {
+	patterns []Pattern
+	graphs   []rdf.IRI // graphs to query; all if empty
+	ranges   map[Variable][2]rdf.Term
+}
+
+// NewBGP returns a new, empty basic graph pattern query.
+func NewBGP() *BGP {
+	return &BGP{}
+}
+
+// Where adds a triple pattern to the query.
+func (q *BGP) Where(s, p, o rdf.Term) *BGP {
+	q.patterns = append(q.patterns, Pattern{Subject: s, Predicate: p, Object: o})
+	return q
+}
+
+// From restricts the query to the triples in the given graphs. Use
+// DefaultGraph to include the default graph.
+func (q *BGP) From(graphs ...rdf.IRI) *BGP {
+	q.graphs = graphs
+	return q
+}
+
+// Range restricts the variable to literals with values between min and max,
+// inclusive. Either min or max can be nil, for an open-ended range. Numeric
+// literals can be compared with each other, as can dates, dateTimes and
+// years. The range is looked up in the value index, so a pattern like
+//
+//	NewBGP().Where(Variable("s"), p, Variable("year")).Range("year", min, max)
+//
+// only visits the triples with objects in the range.
+func (q *BGP) Range(v Variable, min, max rdf.Term) *BGP {
+	if q.ranges == nil {
+		q.ranges = make(map[Variable][2]rdf.Term)
+	}
+	q.ranges[v] = [2]rdf.Term{min, max}
+	return q
+}
+
+// Patterns returns the triple patterns of the query.
+func (q *BGP) Patterns() []Pattern {
+	return q.patterns
+}
+
+// Select executes the basic graph pattern query against the triple store,
+// returning the variable bindings of each solution.
+func (db *Store) Select(q *BGP) (res []Binding, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		e, err := db.newBGPEval(tx, q.patterns)
+		if err != nil {
+			return err
+		}
+		if e.scope, err = db.scope(tx, q.graphs); err != nil {
+			return err
+		}
+		if err = e.setRanges(q.ranges); err != nil {
+			return err
+		}
+		cache := make(map[uint32]rdf.Term)
+		return e.eval(nil, func(ids []uint32) error {
+			b := make(Binding, len(ids))
+			for i, id := range ids {
+				t, ok := cache[id]
+				if !ok {
+					t, err = db.getTerm(tx, id)
+					if err != nil {
+						return err
+					}
+					cache[id] = t
+				}
+				b[e.vars[i]] = t
+			}
+			res = append(res, b)
+			return nil
+		})
+	})
+	return res, err
+}
+
+// bgpPattern is a triple pattern where the constant terms are resolved
+// to IDs. vars holds the variable index of each position, or -1 if the
+// position is a constant.
+type bgpPattern struct {
+	ids  [3]uint32
+	vars [3]int
+}
+
+// bgpEval evaluates a basic graph pattern against the indices.
+type bgpEval struct {
+	db       *Store
+	tx       *bolt.Tx
+	patterns []bgpPattern
+	vars     []Variable
+	varIdx   map[Variable]int
+	scope    graphScope    // graphs to match triples in; all if nil
+	ranges   map[int]idSet // allowed terms of variables restricted to a range
+	empty    bool          // true if a constant term is not stored; no solutions are possible
+}
+
+// newBGPEval resolves the constant terms of the patterns and assigns an
+// index to every variable.
+func (db *Store) newBGPEval(tx *bolt.Tx, patterns []Pattern) (*bgpEval, error) {
+	e := &bgpEval{
+		db:       db,
+		tx:       tx,
+		patterns: make([]bgpPattern, len(patterns)),
+		varIdx:   make(map[Variable]int),
+	}
+	for i, pat := range patterns {
+		for j, t := range []rdf.Term{pat.Subject, pat.Predicate, pat.Object} {
+			if v, ok := t.(Variable); ok {
+				idx, ok := e.varIdx[v]
+				if !ok {
+					idx = len(e.vars)
+					e.vars = append(e.vars, v)
+					e.varIdx[v] = idx
+				}
+				e.patterns[i].vars[j] = idx
+				continue
+			}
+			e.patterns[i].vars[j] = -1
+			id, err := db.getID(tx, t)
+			if err == ErrNotFound {
+				e.empty = true
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			e.patterns[i].ids[j] = id
+		}
+	}
+	return e, nil
+}
+
+// setRanges looks up the terms in the ranges of the variables. Variables not
+// in any pattern are ignored.
+func (e *bgpEval) setRanges(ranges map[Variable][2]rdf.Term) error {
+	for v, r := range ranges {
+		idx, ok := e.varIdx[v]
+		if !ok {
+			continue
+		}
+		b, err := e.db.valueRange(e.tx, r[0], r[1])
+		if err != nil {
+			return err
+		}
+		if e.ranges == nil {
+			e.ranges = make(map[int]idSet)
+		}
+		if e.ranges[idx], err = newIDSet(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inRange checks if the variable, bound to the given term ID, is not
+// restricted to a range, or is in its range.
+func (e *bgpEval) inRange(v int, id uint32) bool {
+	r, ok := e.ranges[v]
+	return !ok || r.contains(id)
+}
+
+// eval finds all solutions of the patterns, starting from the given initial
+// bindings (indexed by variable index, 0 meaning unbound), and calls emit
+// for each of them. The slice given to emit is reused between calls.
+func (e *bgpEval) eval(initial []uint32, emit func([]uint32) error) error {
+	if e.empty || (e.scope != nil && len(e.scope) == 0) {
+		return nil
+	}
+	b := make([]uint32, len(e.vars))
+	copy(b, initial)
+	bound := make([]bool, len(e.vars))
+	for v, id := range b {
+		if id != 0 && !e.inRange(v, id) {
+			return nil
+		}
+		bound[v] = id != 0
+	}
+	steps, err := e.plan(bound)
+	if err != nil {
+		return err
+	}
+	return e.solve(steps, b, emit)
+}
+
+// resolve returns the term IDs of the pattern given the current bindings,
+// with 0 for unbound positions.
+func (e *bgpEval) resolve(pat bgpPattern, b []uint32) (ids [3]uint32) {
+	for i := range ids {
+		if pat.vars[i] == -1 {
+			ids[i] = pat.ids[i]
+		} else {
+			ids[i] = b[pat.vars[i]]
+		}
+	}
+	return ids
+}
+
+// solve recursively extends the bindings b by evaluating the steps of the
+// plan in order, and emits the bindings when all steps are satisfied.
+func (e *bgpEval) solve(steps []bgpStep, b []uint32, emit func([]uint32) error) error {
+	if len(steps) == 0 {
+		return emit(b)
+	}
+	switch step := steps[0]; step.op {
+	case PlanCheck:
+		ok, err := e.has(e.resolve(e.patterns[step.patterns[0]], b))
+		if err != nil || !ok {
+			return err
+		}
+		return e.solve(steps[1:], b, emit)
+	case PlanJoin:
+		return e.join(step, steps[1:], b, emit)
+	default:
+		return e.scan(step, steps[1:], b, emit)
+	}
+}
+
+// join binds the variable of the step to each term in the intersection of
+// the index bitmaps of the patterns of the step, and its range, if any.
+func (e *bgpEval) join(step bgpStep, next []bgpStep, b []uint32, emit func([]uint32) error) error {
+	acc := roaring.NewRoaringBitmap()
+	first := true
+	if r, ok := e.ranges[step.v]; ok {
+		if _, err := acc.ReadFrom(bytes.NewReader(r.bitmap)); err != nil {
+			return err
+		}
+		first = false
+	}
+	for _, i := range step.patterns {
+		ids := e.resolve(e.patterns[i], b)
+		bo := e.db.lookupIDs(e.tx, ids[0], ids[1], ids[2])
+		if bo == nil {
+			return nil
+		}
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+			return err
+		}
+		if first {
+			acc, first = bitmap, false
+		} else {
+			acc.And(bitmap)
+		}
+	}
+	it := acc.Iterator()
+candidates:
+	for it.HasNext() {
+		b[step.v] = it.Next()
+		if e.scope != nil {
+			// The bitmaps span all graphs, so the joined patterns
+			// must be checked against the graphs in scope.
+			for _, i := range step.patterns {
+				ok, err := e.has(e.resolve(e.patterns[i], b))
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue candidates
+				}
+			}
+		}
+		if err := e.solve(next, b, emit); err != nil {
+			return err
+		}
+	}
+	b[step.v] = 0
+	return nil
+}
+
+// scan binds the unbound variables of the pattern of the step to each
+// matching triple.
+func (e *bgpEval) scan(step bgpStep, next []bgpStep, b []uint32, emit func([]uint32) error) error {
+	pat := e.patterns[step.patterns[0]]
+	ids := e.resolve(pat, b)
+	it := e.db.matchIDs(e.tx, ids[0], ids[1], ids[2])
+	it.scope = e.scope
+	for s, p, o, err := it.nextIDs(); err != io.EOF; s, p, o, err = it.nextIDs() {
+		if err != nil {
+			return err
+		}
+		if !e.bind(pat, ids, [3]uint32{s, p, o}, b) {
+			continue
+		}
+		ok := true
+		for i, id := range ids {
+			if id == 0 && !e.inRange(pat.vars[i], b[pat.vars[i]]) {
+				ok = false
+			}
+		}
+		if ok {
+			if err := e.solve(next, b, emit); err != nil {
+				return err
+			}
+		}
+		for i, id := range ids {
+			if id == 0 {
+				b[pat.vars[i]] = 0
+			}
+		}
+	}
+	return nil
+}
+
+// bind binds the unbound variables of the pattern to the matched triple.
+// It returns false if a variable occuring more than once in the pattern
+// would be bound to different terms.
+func (e *bgpEval) bind(pat bgpPattern, ids, tr [3]uint32, b []uint32) bool {
+	for i, id := range ids {
+		if id != 0 {
+			continue
+		}
+		v := pat.vars[i]
+		if b[v] != 0 && b[v] != tr[i] {
+			for j := 0; j < i; j++ {
+				if ids[j] == 0 {
+					b[pat.vars[j]] = 0
+				}
+			}
+			return false
+		}
+		b[v] = tr[i]
+	}
+	return true
+}
+
+// has checks if the triple of the given term IDs is stored in any of the
+// graphs in scope.
+func (e *bgpEval) has(ids [3]uint32) (bool, error) {
+	ok, err := e.db.hasIDs(e.tx, ids[0], ids[1], ids[2])
+	if err != nil || !ok {
+		return false, err
+	}
+	return e.db.inScope(e.tx, e.scope, ids[0], ids[1], ids[2])
+}
+
+// lookupIDs returns the serialized bitmap of the index holding the single
+// unbound (0) position of the given term IDs, or nil if there is none.
+func (db *Store) lookupIDs(tx *bolt.Tx, s, p, o uint32) []byte {
+	switch {
+	case o == 0:
+		return tx.Bucket(bSPO).Get(compositeKey(s, p))
+	case p == 0:
+		return tx.Bucket(bOSP).Get(compositeKey(o, s))
+	case s == 0:
+		return tx.Bucket(bPOS).Get(compositeKey(p, o))
+	}
+	panic("db.lookupIDs: no unbound position")
+}
+
+// hasIDs checks if the triple of the given term IDs is stored.
+func (db *Store) hasIDs(tx *bolt.Tx, s, p, o uint32) (bool, error) {
+	bo := tx.Bucket(bSPO).Get(compositeKey(s, p))
+	if bo == nil {
+		return false, nil
+	}
+	bitmap := roaring.NewRoaringBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+		return false, err
+	}
+	return bitmap.Contains(o), nil
+}
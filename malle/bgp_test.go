@@ -0,0 +1,106 @@
+package malle
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	input := `<w1> <creator> <hamsun> .
+<w2> <creator> <hamsun> .
+<w3> <creator> <undset> .
+<w1> <type> <Work> .
+<w3> <type> <Work> .
+<hamsun> <name> "Hamsun, Knut" .
+<undset> <name> "Undset, Sigrid" .
+<hamsun> <knows> <undset> .
+<undset> <knows> <hamsun> .
+<narcissus> <knows> <narcissus> .
+`
+	_, err := testDB.Import(bytes.NewBufferString(input), 100, false)
+	if err != nil {
+		t.Fatalf("Store.Import(%s) == %v; want no error", input, err)
+	}
+
+	tests := []struct {
+		q    *BGP
+		want []string
+	}{
+		{
+			NewBGP().
+				Where(Variable("work"), mustNewIRI("creator"), Variable("p")).
+				Where(Variable("p"), mustNewIRI("name"), mustNewLiteral("Hamsun, Knut")),
+			[]string{"?p=<hamsun> ?work=<w1>", "?p=<hamsun> ?work=<w2>"},
+		},
+		{
+			NewBGP().
+				Where(Variable("work"), mustNewIRI("creator"), Variable("p")).
+				Where(Variable("work"), mustNewIRI("type"), mustNewIRI("Work")).
+				Where(Variable("p"), mustNewIRI("name"), Variable("name")),
+			[]string{
+				`?name="Hamsun, Knut" ?p=<hamsun> ?work=<w1>`,
+				`?name="Undset, Sigrid" ?p=<undset> ?work=<w3>`,
+			},
+		},
+		{
+			NewBGP().
+				Where(Variable("a"), mustNewIRI("knows"), Variable("b")).
+				Where(Variable("b"), mustNewIRI("knows"), Variable("a")),
+			[]string{
+				"?a=<hamsun> ?b=<undset>",
+				"?a=<narcissus> ?b=<narcissus>",
+				"?a=<undset> ?b=<hamsun>",
+			},
+		},
+		{
+			NewBGP().Where(Variable("x"), mustNewIRI("knows"), Variable("x")),
+			[]string{"?x=<narcissus>"},
+		},
+		{
+			NewBGP().
+				Where(mustNewIRI("w1"), Variable("p"), mustNewIRI("hamsun")),
+			[]string{"?p=<creator>"},
+		},
+		{
+			NewBGP().
+				Where(Variable("work"), mustNewIRI("creator"), mustNewIRI("hamsun")).
+				Where(Variable("work"), mustNewIRI("creator"), mustNewIRI("undset")),
+			nil,
+		},
+		{
+			NewBGP().
+				Where(Variable("work"), mustNewIRI("creator"), mustNewIRI("nobody")),
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		res, err := testDB.Select(test.q)
+		if err != nil {
+			t.Fatalf("Store.Select(%v) == %v; want no error", test.q.Patterns(), err)
+		}
+		got := make([]string, 0, len(res))
+		for _, b := range res {
+			got = append(got, bindingString(b))
+		}
+		sort.Strings(got)
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("Store.Select(%v) == %v; want %v", test.q.Patterns(), got, test.want)
+		}
+	}
+}
+
+func bindingString(b Binding) string {
+	vars := make([]string, 0, len(b))
+	for v := range b {
+		vars = append(vars, string(v))
+	}
+	sort.Strings(vars)
+	s := make([]string, len(vars))
+	for i, v := range vars {
+		s[i] = Variable(v).String() + "=" + b[Variable(v)].String()
+	}
+	return strings.Join(s, " ")
+}
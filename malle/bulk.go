@@ -0,0 +1,278 @@
+package malle
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync/atomic"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// BulkImportGraph works like ImportGraph, but is much faster for large graphs.
+// Instead of updating the index bitmaps once per triple, the triples are
+// sorted and grouped by composite key, so that every bitmap is read, updated
+// and written only once.
+func (db *Store) BulkImportGraph(g rdf.Graph, graphs ...rdf.IRI) error {
+	validate := db.validator()
+	return db.update(func(tx *bolt.Tx) error {
+		gIDs, err := db.graphIDs(tx, graphs, true)
+		if err != nil {
+			return err
+		}
+
+		// Terms repeat a lot, especially predicates and object IRIs,
+		// so they are only looked up once per graph.
+		ids := make(map[string]uint32)
+		termID := func(t rdf.Term) (uint32, error) {
+			k := t.String()
+			if id, ok := ids[k]; ok {
+				return id, nil
+			}
+			id, err := db.addTerm(tx, t)
+			if err != nil {
+				return 0, err
+			}
+			ids[k] = id
+			return id, nil
+		}
+
+		triples := make([][3]uint32, 0, g.Size())
+		for subj, props := range g {
+			sID, err := termID(subj)
+			if err != nil {
+				return err
+			}
+			for pred, terms := range props {
+				pID, err := termID(pred)
+				if err != nil {
+					return err
+				}
+				for _, obj := range terms {
+					oID, err := termID(obj)
+					if err != nil {
+						return err
+					}
+					triples = append(triples, [3]uint32{sID, pID, oID})
+				}
+			}
+		}
+
+		if err := db.bulkStore(tx, triples, gIDs); err != nil {
+			return err
+		}
+		if validate != nil {
+			return validate(&Tx{db: db, tx: tx}, g)
+		}
+		return nil
+	})
+}
+
+// BulkImport works like Import, but loads each batch with BulkImportGraph.
+// Larger batches give faster loading, at the cost of memory.
+func (db *Store) BulkImport(r io.Reader, batchSize int, logErr bool) (int, error) {
+	return db.importNTriples(r, batchSize, logErr, func(g rdf.Graph) error {
+		return db.BulkImportGraph(g)
+	})
+}
+
+// bulkStore stores the triples of the given term IDs in the indices, and in
+// each of the graphs with the given IDs.
+func (db *Store) bulkStore(tx *bolt.Tx, triples [][3]uint32, gIDs []uint32) error {
+	// The SPO index is written first, to find out which triples are new.
+	sortTriples(triples)
+	triples = dedupTriples(triples)
+	existed := make(map[[3]uint32]bool)
+	var added [][3]uint32
+	stats := make(map[uint32][3]int) // triples, subjects and objects added per predicate
+	err := bulkAdd(tx.Bucket(bSPO), triples, func(tr [3]uint32, isNew bool) {
+		if isNew {
+			added = append(added, tr)
+			c := stats[tr[1]]
+			c[0]++
+			stats[tr[1]] = c
+		} else {
+			existed[tr] = true
+		}
+	}, func(s, p uint32) {
+		c := stats[p]
+		c[1]++
+		stats[p] = c
+	})
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&db.numTr, int64(len(added)))
+	if err := db.updateClassStats(tx, added, 1); err != nil {
+		return err
+	}
+	if err := db.indexText(tx, added); err != nil {
+		return err
+	}
+	if db.sameAs {
+		if err := db.linkSameAs(tx, added); err != nil {
+			return err
+		}
+	}
+
+	// The other indices are written from copies of the triples, with the
+	// positions rotated to match the order of the index keys.
+	rotated := make([][3]uint32, len(triples))
+	for _, idx := range []struct {
+		bk     []byte
+		order  [3]int // position in triple of each key part
+		newKey func(k1, k2 uint32)
+	}{
+		{bOSP, [3]int{2, 0, 1}, nil},
+		{bPOS, [3]int{1, 2, 0}, func(p, o uint32) {
+			c := stats[p]
+			c[2]++
+			stats[p] = c
+		}},
+	} {
+		for i, tr := range triples {
+			rotated[i] = [3]uint32{tr[idx.order[0]], tr[idx.order[1]], tr[idx.order[2]]}
+		}
+		sortTriples(rotated)
+		if err := bulkAdd(tx.Bucket(idx.bk), rotated, nil, idx.newKey); err != nil {
+			return err
+		}
+	}
+	for p, c := range stats {
+		if err := db.updateStats(tx, p, c[0], c[1], c[2]); err != nil {
+			return err
+		}
+	}
+
+	for _, g := range gIDs {
+		if err := db.bulkStoreInGraph(tx, triples, g, existed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkStoreInGraph records the graph membership of the triples, which must
+// allready be in the triple indices. The triples must be sorted, and existed
+// holds the triples which were stored before the bulk load.
+func (db *Store) bulkStoreInGraph(tx *bolt.Tx, triples [][3]uint32, g uint32, existed map[[3]uint32]bool) error {
+	bkt := tx.Bucket(bSPOG)
+	for _, tr := range triples {
+		if g == 0 && !existed[tr] {
+			// New triple.
+			if err := db.logChange(tx, ChangeAdd, tr[0], tr[1], tr[2], 0); err != nil {
+				return err
+			}
+			continue
+		}
+		key := tripleKey(tr[0], tr[1], tr[2])
+		bo := bkt.Get(key)
+		if g == 0 && bo == nil {
+			// Allready in the default graph only.
+			continue
+		}
+		graphs := roaring.NewRoaringBitmap()
+		if bo != nil {
+			if _, err := graphs.ReadFrom(bytes.NewReader(bo)); err != nil {
+				return err
+			}
+		} else if existed[tr] {
+			// The triple was stored in the default graph only.
+			graphs.Add(0)
+		}
+		if !graphs.CheckedAdd(g) {
+			continue
+		}
+		var b bytes.Buffer
+		if _, err := graphs.WriteTo(&b); err != nil {
+			return err
+		}
+		if err := bkt.Put(key, b.Bytes()); err != nil {
+			return err
+		}
+		if err := db.logChange(tx, ChangeAdd, tr[0], tr[1], tr[2], g); err != nil {
+			return err
+		}
+	}
+	if g == 0 {
+		return nil
+	}
+
+	return bulkAdd(tx.Bucket(bGSPO), triples, nil, nil, g)
+}
+
+// bulkAdd adds the sorted triples to the index bucket, where the first two
+// positions make up the composite key and the last one is the bitmap value.
+// If a prefix is given, it is prepended to every key. Each bitmap is read and
+// written once. If added is not nil, it is called for every triple, telling
+// if it was new to the index, and if newKey is not nil, it is called for
+// every composite key new to the index.
+func bulkAdd(bkt *bolt.Bucket, triples [][3]uint32, added func(tr [3]uint32, isNew bool), newKey func(k1, k2 uint32), prefix ...uint32) error {
+	for i := 0; i < len(triples); {
+		k1, k2 := triples[i][0], triples[i][1]
+		j := i
+		for j < len(triples) && triples[j][0] == k1 && triples[j][1] == k2 {
+			j++
+		}
+
+		var key []byte
+		for _, id := range prefix {
+			key = append(key, u32tob(id)...)
+		}
+		key = append(key, compositeKey(k1, k2)...)
+
+		bitmap := roaring.NewRoaringBitmap()
+		if bo := bkt.Get(key); bo != nil {
+			if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+				return err
+			}
+		} else if newKey != nil {
+			newKey(k1, k2)
+		}
+		for _, tr := range triples[i:j] {
+			isNew := bitmap.CheckedAdd(tr[2])
+			if added != nil {
+				added(tr, isNew)
+			}
+		}
+		var b bytes.Buffer
+		if _, err := bitmap.WriteTo(&b); err != nil {
+			return err
+		}
+		if err := bkt.Put(key, b.Bytes()); err != nil {
+			return err
+		}
+		i = j
+	}
+	return nil
+}
+
+// sortTriples sorts the triples by their first, second and third position.
+func sortTriples(triples [][3]uint32) {
+	sort.Slice(triples, func(i, j int) bool {
+		a, b := triples[i], triples[j]
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+}
+
+// dedupTriples removes duplicates from the sorted triples.
+func dedupTriples(triples [][3]uint32) [][3]uint32 {
+	if len(triples) == 0 {
+		return triples
+	}
+	res := triples[:1]
+	for _, tr := range triples[1:] {
+		if tr != res[len(res)-1] {
+			res = append(res, tr)
+		}
+	}
+	return res
+}
@@ -0,0 +1,98 @@
+package malle
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestBulkImportGraph(t *testing.T) {
+	g := genRandGraph(200)
+	before := testDB.Stats()
+
+	// Some triples are allready stored, in the default or a named graph.
+	trs := g.Triples()
+	if err := testDB.AddTriple(trs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.AddTriple(trs[1], "bulkg"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testDB.BulkImportGraph(g); err != nil {
+		t.Fatalf("Store.BulkImportGraph() failed with: %v", err)
+	}
+	if n := testDB.Stats().NumTriples - before.NumTriples; n != g.Size() {
+		t.Errorf("Store.BulkImportGraph() stored %d triples; want %d", n, g.Size())
+	}
+	for _, tr := range trs {
+		if ok, err := testDB.HasTriple(tr, DefaultGraph); err != nil || !ok {
+			t.Fatalf("Store.HasTriple(%v, DefaultGraph) => %v, %v; want true, nil", tr, ok, err)
+		}
+	}
+	if ok, _ := testDB.HasTriple(trs[1], "bulkg"); !ok {
+		t.Errorf("Store.BulkImportGraph() removed %v from named graph", trs[1])
+	}
+
+	// Bulk loaded triples are indexed like any other.
+	subj := trs[2].Subject()
+	want := rdf.NewGraph()
+	for pred, terms := range g[subj] {
+		for _, obj := range terms {
+			want.Add(rdf.NewTriple(subj, pred, obj))
+		}
+	}
+	res, _, err := testDB.Query(NewQuery().Resource(subj))
+	if err != nil || !res.Eq(want) {
+		t.Errorf("Store.Query(NewQuery().Resource(%v)) == %v, %v; want %v, <nil>", subj, res, err, want)
+	}
+	for _, tr := range trs[:10] {
+		it, err := testDB.Match("", "", tr.Object())
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for got, err := it.Next(); err == nil; got, err = it.Next() {
+			found = found || got.Eq(tr)
+		}
+		it.Close()
+		if !found {
+			t.Errorf("Store.Match(\"\", \"\", %v) did not find %v", tr.Object(), tr)
+		}
+	}
+
+	if err := testDB.DeleteGraph(g); err != nil {
+		t.Fatalf("Store.DeleteGraph() failed with: %v", err)
+	}
+	if err := testDB.RemoveTriple(trs[1], "bulkg"); err != nil {
+		t.Fatal(err)
+	}
+	if after := testDB.Stats(); after.NumTriples != before.NumTriples || after.NumTerms != before.NumTerms {
+		t.Errorf("Store.Stats() == %+v after deleting bulk loaded graph; want %+v", after, before)
+	}
+}
+
+func TestBulkImportGraphInNamedGraph(t *testing.T) {
+	g := rdf.Load(bytes.NewBufferString(`<bk1> <bkp> <bk2> .
+<bk1> <bkp> <bk3> .
+<bk2> <bkp> "x" .
+`))
+	if err := testDB.AddTriple(rdf.NewTriple("bk1", "bkp", mustNewIRI("bk2"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.BulkImportGraph(g, "bkg"); err != nil {
+		t.Fatalf("Store.BulkImportGraph(%v, bkg) failed with: %v", g, err)
+	}
+	res, _, err := testDB.Query(NewQuery().Resource("bk1").From("bkg"))
+	want := rdf.Load(bytes.NewBufferString(`<bk1> <bkp> <bk2> .
+<bk1> <bkp> <bk3> .`))
+	if err != nil || !res.Eq(want) {
+		t.Errorf("Store.Query(NewQuery().Resource(bk1).From(bkg)) == %v, %v; want %v", res, err, want)
+	}
+	res, _, err = testDB.Query(NewQuery().Resource("bk1").From(DefaultGraph))
+	want = rdf.Load(bytes.NewBufferString(`<bk1> <bkp> <bk2> .`))
+	if err != nil || !res.Eq(want) {
+		t.Errorf("Store.Query(NewQuery().Resource(bk1).From(DefaultGraph)) == %v, %v; want %v", res, err, want)
+	}
+}
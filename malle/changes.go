@@ -0,0 +1,208 @@
+package malle
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// ChangeOp is the operation of a Change.
+type ChangeOp byte
+
+// Change operations
+const (
+	ChangeAdd    ChangeOp = 'A'
+	ChangeRemove ChangeOp = 'D'
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeAdd:
+		return "add"
+	case ChangeRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is an addition or removal of a triple in a graph, as recorded in
+// the change log.
+type Change struct {
+	Seq    uint64 // sequence number; increasing with every change
+	Op     ChangeOp
+	Triple rdf.Triple
+	Graph  rdf.IRI // DefaultGraph or a named graph
+}
+
+// errCorruptChange is returned when a change log entry cannot be decoded.
+var errCorruptChange = errors.New("corrupt change log entry")
+
+// Changes returns the changes with a sequence number higher than since, in
+// order. No more than MaxResults changes are returned at a time, so to follow
+// the log, call it again with the sequence number of the last change until
+// no more changes are returned.
+func (db *Store) Changes(since uint64) (changes []Change, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(bLog).Cursor()
+		for k, v := cur.Seek(u64tob(since + 1)); k != nil && len(changes) < MaxResults; k, v = cur.Next() {
+			c, err := db.decodeChange(v)
+			if err != nil {
+				return err
+			}
+			c.Seq = btou64(k)
+			changes = append(changes, c)
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// LastChange returns the sequence number of the last change, or 0 if none.
+func (db *Store) LastChange() (seq uint64, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(bLog).Cursor().Last(); k != nil {
+			seq = btou64(k)
+		}
+		return nil
+	})
+	return seq, err
+}
+
+// Subscribe returns a channel receiving every change committed after the
+// call, in order. If the subscriber cannot keep up, so that the channel
+// buffer of the given size is full, the channel is closed; the subscriber
+// can then catch up using Changes, from the last sequence number received.
+// The returned function cancels the subscription, closing the channel.
+func (db *Store) Subscribe(buffer int) (<-chan Change, func()) {
+	ch := make(chan Change, buffer)
+	db.subMu.Lock()
+	if db.subs == nil {
+		db.subs = make(map[chan Change]bool)
+	}
+	db.subs[ch] = true
+	db.subMu.Unlock()
+
+	return ch, func() {
+		db.subMu.Lock()
+		if db.subs[ch] {
+			delete(db.subs, ch)
+			close(ch)
+		}
+		db.subMu.Unlock()
+	}
+}
+
+// publish sends the changes to all subscribers.
+func (db *Store) publish(changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+	db.subMu.Lock()
+	defer db.subMu.Unlock()
+	for ch := range db.subs {
+	send:
+		for _, c := range changes {
+			select {
+			case ch <- c:
+			default:
+				delete(db.subs, ch)
+				close(ch)
+				break send
+			}
+		}
+	}
+}
+
+// closeSubscriptions closes the channels of all subscribers.
+func (db *Store) closeSubscriptions() {
+	db.subMu.Lock()
+	for ch := range db.subs {
+		delete(db.subs, ch)
+		close(ch)
+	}
+	db.subMu.Unlock()
+}
+
+// logChange records a change of the triple of the given term IDs in the
+// graph with the given ID, where 0 is the default graph. The terms must
+// still be stored. The change is published to subscribers once the
+// transaction is committed.
+//
+// The terms are logged in the same encoding as in the terms bucket, each
+// one prefixed by its length. A graph length of 0 means the default graph.
+func (db *Store) logChange(tx *bolt.Tx, op ChangeOp, s, p, o, g uint32) error {
+	ids := []uint32{s, p, o}
+	if g != 0 {
+		ids = append(ids, g)
+	}
+	v := []byte{byte(op)}
+	terms := tx.Bucket(bTerms)
+	lb := make([]byte, binary.MaxVarintLen64)
+	for _, id := range ids {
+		b := terms.Get(u32tob(id))
+		if b == nil {
+			return ErrNotFound
+		}
+		n := binary.PutUvarint(lb, uint64(len(b)))
+		v = append(v, lb[:n]...)
+		v = append(v, b...)
+	}
+	if g == 0 {
+		v = append(v, 0)
+	}
+
+	bkt := tx.Bucket(bLog)
+	seq, err := bkt.NextSequence()
+	if err != nil {
+		return err
+	}
+	if err := bkt.Put(u64tob(seq), v); err != nil {
+		return err
+	}
+
+	c, err := db.decodeChange(v)
+	if err != nil {
+		return err
+	}
+	c.Seq = seq
+	db.pending = append(db.pending, c)
+	return nil
+}
+
+// decodeChange decodes a change log entry. The sequence number is not set.
+func (db *Store) decodeChange(v []byte) (c Change, err error) {
+	if len(v) == 0 {
+		return c, errCorruptChange
+	}
+	c.Op = ChangeOp(v[0])
+	v = v[1:]
+	var terms [4]rdf.Term
+	for i := range terms {
+		l, n := binary.Uvarint(v)
+		if n <= 0 || uint64(len(v)-n) < l {
+			return c, errCorruptChange
+		}
+		if l > 0 {
+			terms[i] = db.decode(v[n : n+int(l)])
+		}
+		v = v[n+int(l):]
+	}
+	s, ok := terms[0].(rdf.IRI)
+	if !ok {
+		return c, errCorruptChange
+	}
+	p, ok := terms[1].(rdf.IRI)
+	if !ok || terms[2] == nil {
+		return c, errCorruptChange
+	}
+	c.Triple = rdf.NewTriple(s, p, terms[2])
+	if terms[3] != nil {
+		if c.Graph, ok = terms[3].(rdf.IRI); !ok {
+			return c, errCorruptChange
+		}
+	}
+	return c, nil
+}
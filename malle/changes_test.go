@@ -0,0 +1,83 @@
+package malle
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func changeString(c Change) string {
+	return fmt.Sprintf("%v %v %v", c.Op, strings.TrimSpace(c.Triple.String()), c.Graph)
+}
+
+func TestChanges(t *testing.T) {
+	last, err := testDB.LastChange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, cancel := testDB.Subscribe(10)
+	slow, _ := testDB.Subscribe(0)
+
+	tr1 := rdf.NewTriple("ch1", "chp", mustNewLiteral("one"))
+	tr2 := rdf.NewTriple("ch1", "chp", mustNewIRI("ch2"))
+	if err := testDB.AddTriple(tr1); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.AddTriple(tr1); err != nil { // no change
+		t.Fatal(err)
+	}
+	if err := testDB.AddTriple(tr2, "chg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.RemoveTriple(tr1); err != nil {
+		t.Fatal(err)
+	}
+	errAbort := errors.New("abort")
+	testDB.Update(func(tx *Tx) error {
+		tx.Add(tr1)
+		return errAbort
+	})
+
+	want := []string{
+		`add <ch1> <chp> "one" . <>`,
+		`add <ch1> <chp> <ch2> . <chg>`,
+		`remove <ch1> <chp> "one" . <>`,
+	}
+
+	changes, err := testDB.Changes(last)
+	if err != nil || len(changes) != len(want) {
+		t.Fatalf("Store.Changes(%d) == %v, %v; want %d changes", last, changes, err, len(want))
+	}
+	for i, c := range changes {
+		if got := changeString(c); got != want[i] || c.Seq != last+uint64(i)+1 {
+			t.Errorf("Store.Changes(%d)[%d] == %d %q; want %d %q", last, i, c.Seq, got, last+uint64(i)+1, want[i])
+		}
+	}
+	if changes, _ = testDB.Changes(last + 2); len(changes) != 1 {
+		t.Errorf("Store.Changes(%d) == %v; want 1 change", last+2, changes)
+	}
+	if seq, _ := testDB.LastChange(); seq != last+3 {
+		t.Errorf("Store.LastChange() == %d; want %d", seq, last+3)
+	}
+
+	for i := range want {
+		c := <-sub
+		if got := changeString(c); got != want[i] || c.Seq != changes[0].Seq-2+uint64(i) {
+			t.Errorf("subscription got %d %q; want %q", c.Seq, got, want[i])
+		}
+	}
+	if _, ok := <-slow; ok {
+		t.Errorf("subscription with full buffer not closed")
+	}
+	cancel()
+	if _, ok := <-sub; ok {
+		t.Errorf("cancelled subscription not closed")
+	}
+
+	if err := testDB.RemoveTriple(tr2, "chg"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,669 @@
+package malle
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// Problem is an inconsistency in the store, as found by Check or Repair.
+type Problem struct {
+	Bucket   string // the bucket where the problem was found
+	Desc     string
+	Repaired bool // set by Repair if the problem was fixed
+}
+
+func (p Problem) String() string {
+	if p.Repaired {
+		return fmt.Sprintf("%s: %s (repaired)", p.Bucket, p.Desc)
+	}
+	return fmt.Sprintf("%s: %s", p.Bucket, p.Desc)
+}
+
+// Check verifies the integrity of the store, returning the problems found.
+// It checks that:
+//
+//   - terms and iterms, and ns and ins, are exact inverses
+//   - every term can be decoded, and its namespace resolves
+//   - the value index holds exactly the literals of indexed datatypes
+//   - the SPO, OSP and POS indices hold the same triples
+//   - every term ID in the triple and graph indices exists
+//   - the predicate statistics match the triple indices
+//   - the owl:sameAs clusters are those linked by owl:sameAs triples
+//   - the two named graph indices agree, and only hold stored triples
+//
+// The check runs in a read transaction, so the store can be used meanwhile.
+func (db *Store) Check() (problems []Problem, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		c := &checker{db: db, tx: tx}
+		if err := c.run(); err != nil {
+			return err
+		}
+		problems = c.problems
+		return nil
+	})
+	return problems, err
+}
+
+// Repair works like Check, but also fixes the problems where possible, in a
+// single write transaction. The triples of the SPO, OSP and POS indices are
+// restored to all of them if their terms exist, or else removed from all.
+// Graph memberships are likewise restored if the triple and graph exist.
+// Problems which cannot be fixed, such as undecodable terms, are returned
+// with Repaired set to false.
+//
+// Repairs are not recorded in the change log.
+func (db *Store) Repair() (problems []Problem, err error) {
+	err = db.update(func(tx *bolt.Tx) error {
+		c := &checker{db: db, tx: tx}
+		if err := c.run(); err != nil {
+			return err
+		}
+		for i, fix := range c.fixes {
+			if fix == nil {
+				continue
+			}
+			if err := fix(); err != nil {
+				return err
+			}
+			c.problems[i].Repaired = true
+		}
+		// The statistics are recounted after the triple indices are
+		// repaired.
+		n, err := countTriples(tx)
+		if err != nil {
+			return err
+		}
+		atomic.StoreInt64(&db.numTr, n)
+		if err := db.countAllStats(tx); err != nil {
+			return err
+		}
+		problems = c.problems
+		return nil
+	})
+	return problems, err
+}
+
+// checker collects the problems found in a transaction, along with the fix
+// for each of them, or nil if it cannot be fixed.
+type checker struct {
+	db       *Store
+	tx       *bolt.Tx
+	problems []Problem
+	fixes    []func() error
+
+	// triples found in only some of the indices: true if they are
+	// to be restored to all, false if they are to be removed.
+	broken map[[3]uint32]bool
+}
+
+func (c *checker) report(bucket []byte, fix func() error, format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{Bucket: string(bucket), Desc: fmt.Sprintf(format, args...)})
+	c.fixes = append(c.fixes, fix)
+}
+
+func (c *checker) run() error {
+	c.checkNamespaces()
+	c.checkTerms()
+	c.checkValues()
+	if err := c.checkTriples(); err != nil {
+		return err
+	}
+	if err := c.checkStats(); err != nil {
+		return err
+	}
+	if err := c.checkSameAs(); err != nil {
+		return err
+	}
+	return c.checkGraphs()
+}
+
+// checkNamespaces checks the namespace buckets against each other, and
+// against the in-memory namespace map, and that prefixes are of stored
+// namespaces.
+func (c *checker) checkNamespaces() {
+	ns, ins := c.tx.Bucket(bNS), c.tx.Bucket(bIdxNS)
+	reload := false
+	ns.ForEach(func(k, v []byte) error {
+		if len(k) != 2 {
+			c.report(bNS, func() error { return ns.Delete(k) }, "invalid key %x", k)
+			return nil
+		}
+		if !bytes.Equal(ins.Get(v), k) {
+			c.report(bIdxNS, func() error { return ins.Put(v, k) }, "namespace %d (%s) not indexed", btou16(k), v)
+		}
+		c.db.mu.RLock()
+		prefix, ok := c.db.ns.FindByInt(btou16(k))
+		c.db.mu.RUnlock()
+		if !ok || prefix != string(v) {
+			var fix func() error
+			if !reload {
+				fix = func() error {
+					c.db.loadNamespaces(c.tx)
+					return nil
+				}
+				reload = true
+			}
+			c.report(bNS, fix, "namespace %d (%s) not loaded", btou16(k), v)
+		}
+		return nil
+	})
+	ins.ForEach(func(k, v []byte) error {
+		if len(v) != 2 || !bytes.Equal(ns.Get(v), k) {
+			c.report(bIdxNS, func() error { return ins.Delete(k) }, "namespace %s indexed as %x, which does not exist", k, v)
+		}
+		return nil
+	})
+	prefixes := c.tx.Bucket(bPrefix)
+	prefixes.ForEach(func(k, v []byte) error {
+		if ins.Get(v) == nil {
+			fix := func() error {
+				if err := prefixes.Delete(k); err != nil {
+					return err
+				}
+				c.db.loadNamespaces(c.tx)
+				return nil
+			}
+			c.report(bPrefix, fix, "prefix %s of namespace %s, which does not exist", k, v)
+		}
+		return nil
+	})
+}
+
+// checkTerms checks that every term can be decoded, that the terms
+// buckets are inverses of each other, and that no free ID is in use.
+func (c *checker) checkTerms() {
+	terms, iterms, ns := c.tx.Bucket(bTerms), c.tx.Bucket(bIdxTerms), c.tx.Bucket(bNS)
+	terms.ForEach(func(k, v []byte) error {
+		if len(k) != 4 {
+			c.report(bTerms, func() error { return terms.Delete(k) }, "invalid key %x", k)
+			return nil
+		}
+		id := btou32(k)
+		if err := validTerm(v); err != nil {
+			c.report(bTerms, nil, "term %d: %v", id, err)
+			return nil
+		}
+		if v[0] == 0x00 {
+			if n := btou16(v[1:3]); n != 0 && ns.Get(v[1:3]) == nil {
+				c.report(bTerms, nil, "term %d: unknown namespace %d", id, n)
+			}
+		}
+		switch idb := iterms.Get(v); {
+		case idb == nil:
+			c.report(bIdxTerms, func() error { return iterms.Put(v, k) }, "term %d not indexed", id)
+		case !bytes.Equal(idb, k) && bytes.Equal(terms.Get(idb), v):
+			c.report(bTerms, nil, "term %d is a duplicate of term %d", id, btou32(idb))
+		case !bytes.Equal(idb, k):
+			c.report(bIdxTerms, func() error { return iterms.Put(v, k) }, "term %d indexed as %d", id, btou32(idb))
+		}
+		return nil
+	})
+	iterms.ForEach(func(k, v []byte) error {
+		if len(v) != 4 || terms.Get(v) == nil {
+			c.report(bIdxTerms, func() error { return iterms.Delete(k) }, "term indexed as %x, which does not exist", v)
+		}
+		return nil
+	})
+	free := c.tx.Bucket(bFree)
+	free.ForEach(func(k, v []byte) error {
+		if len(k) != 4 || terms.Get(k) != nil {
+			c.report(bFree, func() error { return free.Delete(k) }, "free ID %x is in use", k)
+		}
+		return nil
+	})
+}
+
+// checkValues checks that the value index holds exactly the stored literals
+// of indexed datatypes.
+func (c *checker) checkValues() {
+	terms, vals := c.tx.Bucket(bTerms), c.tx.Bucket(bIdxValues)
+	terms.ForEach(func(k, v []byte) error {
+		if len(k) != 4 || validTerm(v) != nil || v[0] == 0x00 {
+			// Invalid terms are reported by checkTerms.
+			return nil
+		}
+		l, ok := c.db.decode(v).(rdf.Literal)
+		if !ok {
+			return nil
+		}
+		vk, ok := encodeValue(l)
+		if !ok {
+			return nil
+		}
+		key := append(vk, k...)
+		if vals.Get(key) == nil {
+			c.report(bIdxValues, func() error { return vals.Put(key, []byte{}) }, "term %d not indexed by value", btou32(k))
+		}
+		return nil
+	})
+	vals.ForEach(func(k, v []byte) error {
+		var vk []byte
+		if len(k) > 4 {
+			if b := terms.Get(k[len(k)-4:]); b != nil && validTerm(b) == nil && b[0] != 0x00 {
+				if l, ok := c.db.decode(b).(rdf.Literal); ok {
+					vk, _ = encodeValue(l)
+				}
+			}
+		}
+		if vk == nil || !bytes.Equal(vk, k[:len(k)-4]) {
+			c.report(bIdxValues, func() error { return vals.Delete(k) }, "key %x does not match a stored literal", k)
+		}
+		return nil
+	})
+}
+
+// checkTriples checks that the triple indices agree, and that the terms of
+// all triples exist.
+func (c *checker) checkTriples() error {
+	terms := c.tx.Bucket(bTerms)
+	c.broken = make(map[[3]uint32]bool)
+	for _, idx := range []struct {
+		bk    []byte
+		order [3]int // position in key and bitmap of subject, predicate and object
+	}{
+		{bSPO, [3]int{0, 1, 2}},
+		{bOSP, [3]int{1, 2, 0}},
+		{bPOS, [3]int{2, 0, 1}},
+	} {
+		bkt := c.tx.Bucket(idx.bk)
+		err := bkt.ForEach(func(k, v []byte) error {
+			ids, err := decodeEntry(k, v, 8)
+			if err != nil {
+				c.report(idx.bk, func() error { return bkt.Delete(k) }, "key %x: %v", k, err)
+				return nil
+			}
+			for _, id := range ids {
+				pos := [3]uint32{btou32(k), btou32(k[4:]), id}
+				tr := [3]uint32{pos[idx.order[0]], pos[idx.order[1]], pos[idx.order[2]]}
+				if _, seen := c.broken[tr]; seen {
+					continue
+				}
+				var missing []string
+				for _, other := range []struct {
+					bk  []byte
+					key []byte
+					v   uint32
+				}{
+					{bSPO, compositeKey(tr[0], tr[1]), tr[2]},
+					{bOSP, compositeKey(tr[2], tr[0]), tr[1]},
+					{bPOS, compositeKey(tr[1], tr[2]), tr[0]},
+				} {
+					if !bitmapHas(c.tx.Bucket(other.bk), other.key, other.v) {
+						missing = append(missing, string(other.bk))
+					}
+				}
+				var unknown []uint32
+				for _, id := range tr {
+					if terms.Get(u32tob(id)) == nil {
+						unknown = append(unknown, id)
+					}
+				}
+				if len(missing) == 0 && len(unknown) == 0 {
+					continue
+				}
+				restore := len(unknown) == 0
+				c.broken[tr] = restore
+				fix := func() error { return c.setTriple(tr, restore) }
+				if len(unknown) > 0 {
+					c.report(idx.bk, fix, "triple %v references unknown terms %v", tr, unknown)
+				} else {
+					c.report(idx.bk, fix, "triple %v missing from %v", tr, missing)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStats checks that the stored predicate and class statistics match
+// the counts from the triple indices.
+func (c *checker) checkStats() error {
+	counts, classes, typeID, err := c.db.countStats(c.tx)
+	if err != nil {
+		return err
+	}
+	recount := func() error { return nil } // done by Repair in any case
+	bkt := c.tx.Bucket(bStats)
+	for p, want := range counts {
+		if got := decodeStats(bkt.Get(u32tob(p))); got != want {
+			c.report(bStats, recount, "predicate %d has counts %v; want %v", p, got, want)
+		}
+	}
+	for class, want := range classes {
+		if v := bkt.Get(classKey(typeID, class)); len(v) != 8 || btou64(v) != want {
+			c.report(bStats, recount, "class %d has count %x; want %d", class, v, want)
+		}
+	}
+	return bkt.ForEach(func(k, v []byte) error {
+		switch len(k) {
+		case 4:
+			if _, ok := counts[btou32(k)]; ok {
+				return nil
+			}
+		case 8:
+			if _, ok := classes[btou32(k[4:])]; ok && btou32(k) == typeID {
+				return nil
+			}
+		}
+		c.report(bStats, recount, "key %x has counts, but no triples", k)
+		return nil
+	})
+}
+
+// checkSameAs checks that the owl:sameAs clusters are those of the IRIs
+// linked by owl:sameAs triples, if sameAs equivalence is on, and that there
+// are none otherwise. The canonical members are not checked, except that
+// they belong to their clusters.
+func (c *checker) checkSameAs() error {
+	rebuilt := false
+	rebuild := func() error {
+		if rebuilt {
+			return nil
+		}
+		rebuilt = true
+		for _, b := range [][]byte{bSame, bCluster} {
+			if err := c.tx.DeleteBucket(b); err != nil {
+				return err
+			}
+			if _, err := c.tx.CreateBucket(b); err != nil {
+				return err
+			}
+		}
+		if c.db.sameAs {
+			return c.db.linkAllSameAs(c.tx)
+		}
+		return nil
+	}
+
+	// The expected clusters, as a union-find in memory.
+	parent := make(map[uint32]uint32)
+	linked := make(map[uint32]bool)
+	var find func(uint32) uint32
+	find = func(id uint32) uint32 {
+		p, ok := parent[id]
+		if !ok || p == id {
+			return id
+		}
+		parent[id] = find(p)
+		return parent[id]
+	}
+	var links [][2]uint32
+	if p, err := c.db.getID(c.tx, owlSameAs); err == nil && c.db.sameAs {
+		prefix := u32tob(p)
+		cur := c.tx.Bucket(bPOS).Cursor()
+		for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			o := btou32(k[4:])
+			if !c.db.isIRI(c.tx, o) {
+				continue
+			}
+			bitmap := roaring.NewRoaringBitmap()
+			if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+				return err
+			}
+			for it := bitmap.Iterator(); it.HasNext(); {
+				if s := it.Next(); s != o {
+					links = append(links, [2]uint32{s, o})
+					linked[s], linked[o] = true, true
+					parent[find(s)] = find(o)
+				}
+			}
+		}
+	}
+
+	same, clusters := c.tx.Bucket(bSame), c.tx.Bucket(bCluster)
+	for _, l := range links {
+		cs, co := same.Get(u32tob(l[0])), same.Get(u32tob(l[1]))
+		if cs == nil || co == nil || !bytes.Equal(cs, co) {
+			c.report(bSame, rebuild, "IRIs %d and %d are linked by owl:sameAs, but not in the same cluster", l[0], l[1])
+		}
+	}
+	err := same.ForEach(func(k, v []byte) error {
+		if len(k) != 4 || len(v) != 4 {
+			c.report(bSame, rebuild, "invalid entry %x -> %x", k, v)
+			return nil
+		}
+		m, cID := btou32(k), btou32(v)
+		if !linked[m] || find(m) != find(cID) {
+			c.report(bSame, rebuild, "IRI %d is in the cluster of %d, but not linked to it by owl:sameAs", m, cID)
+		}
+		if !bitmapHas(clusters, u32tob(cID), m) {
+			c.report(bCluster, rebuild, "IRI %d missing from the members of its cluster %d", m, cID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return clusters.ForEach(func(k, v []byte) error {
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil || len(k) != 4 {
+			c.report(bCluster, rebuild, "invalid entry %x", k)
+			return nil
+		}
+		for it := bitmap.Iterator(); it.HasNext(); {
+			m := it.Next()
+			if cID := same.Get(u32tob(m)); cID == nil || !bytes.Equal(cID, k) {
+				c.report(bCluster, rebuild, "IRI %d is a member of cluster %d, but does not belong to it", m, btou32(k))
+			}
+		}
+		return nil
+	})
+}
+
+// checkGraphs checks that the two named graph indices agree, and that they
+// only hold triples in the triple indices and graphs that exist.
+func (c *checker) checkGraphs() error {
+	spog, gspo, terms := c.tx.Bucket(bSPOG), c.tx.Bucket(bGSPO), c.tx.Bucket(bTerms)
+	checked := make(map[[4]uint32]bool)
+	check := func(bk []byte, tr [3]uint32, g uint32) {
+		m := [4]uint32{tr[0], tr[1], tr[2], g}
+		if checked[m] {
+			return
+		}
+		checked[m] = true
+
+		stored, ok := c.broken[tr]
+		if !ok {
+			stored = bitmapHas(c.tx.Bucket(bSPO), compositeKey(tr[0], tr[1]), tr[2])
+		}
+		valid := stored && terms.Get(u32tob(g)) != nil
+		inSPOG := bitmapHas(spog, tripleKey(tr[0], tr[1], tr[2]), g)
+		inGSPO := bitmapHas(gspo, tripleKey(g, tr[0], tr[1]), tr[2])
+		if valid && inSPOG && inGSPO {
+			return
+		}
+		fix := func() error { return c.setMembership(tr, g, valid) }
+		switch {
+		case !valid:
+			c.report(bk, fix, "triple %v in graph %d, which is not stored", tr, g)
+		case !inSPOG:
+			c.report(bSPOG, fix, "triple %v in graph %d not indexed", tr, g)
+		default:
+			c.report(bGSPO, fix, "triple %v in graph %d not indexed", tr, g)
+		}
+	}
+
+	err := spog.ForEach(func(k, v []byte) error {
+		ids, err := decodeEntry(k, v, 12)
+		if err != nil {
+			c.report(bSPOG, func() error { return spog.Delete(k) }, "key %x: %v", k, err)
+			return nil
+		}
+		tr := [3]uint32{btou32(k), btou32(k[4:]), btou32(k[8:])}
+		if len(ids) == 0 || (len(ids) == 1 && ids[0] == 0) {
+			c.report(bSPOG, func() error { return spog.Delete(k) }, "triple %v in no named graph", tr)
+			return nil
+		}
+		for _, g := range ids {
+			if g != 0 {
+				check(bSPOG, tr, g)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return gspo.ForEach(func(k, v []byte) error {
+		ids, err := decodeEntry(k, v, 12)
+		if err != nil {
+			c.report(bGSPO, func() error { return gspo.Delete(k) }, "key %x: %v", k, err)
+			return nil
+		}
+		for _, o := range ids {
+			check(bGSPO, [3]uint32{btou32(k[4:]), btou32(k[8:]), o}, btou32(k))
+		}
+		return nil
+	})
+}
+
+// setTriple adds the triple to, or removes it from, all the triple indices.
+func (c *checker) setTriple(tr [3]uint32, present bool) error {
+	s, p, o := tr[0], tr[1], tr[2]
+	for _, i := range []struct {
+		bk  []byte
+		key []byte
+		v   uint32
+	}{
+		{bSPO, compositeKey(s, p), o},
+		{bOSP, compositeKey(o, s), p},
+		{bPOS, compositeKey(p, o), s},
+	} {
+		if err := setInIndex(c.tx.Bucket(i.bk), i.key, i.v, present); err != nil {
+			return err
+		}
+	}
+	if present {
+		return c.db.indexText(c.tx, [][3]uint32{tr})
+	}
+	if err := c.db.unindexText(c.tx, s, o); err != ErrNotFound {
+		// The object may be the unknown term.
+		return err
+	}
+	return nil
+}
+
+// setMembership adds the triple to, or removes it from, the named graph in
+// both graph indices.
+func (c *checker) setMembership(tr [3]uint32, g uint32, present bool) error {
+	key := tripleKey(tr[0], tr[1], tr[2])
+	bkt := c.tx.Bucket(bSPOG)
+	if present && bkt.Get(key) == nil {
+		// The triple was in the default graph only.
+		if err := setInIndex(bkt, key, 0, true); err != nil {
+			return err
+		}
+	}
+	if err := setInIndex(bkt, key, g, present); err != nil {
+		return err
+	}
+	if v := bkt.Get(key); v != nil {
+		if ids, _ := decodeEntry(key, v, 12); len(ids) == 1 && ids[0] == 0 {
+			if err := bkt.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return setInIndex(c.tx.Bucket(bGSPO), tripleKey(g, tr[0], tr[1]), tr[2], present)
+}
+
+// validTerm checks that the stored term encoding can be decoded.
+func validTerm(b []byte) error {
+	if len(b) == 0 {
+		return fmt.Errorf("empty encoding")
+	}
+	switch b[0] {
+	case 0x00: // IRI, with namespace
+		if len(b) < 4 {
+			return fmt.Errorf("IRI too short: %x", b)
+		}
+		return nil
+	case 0x01, 0xFF: // Language tagged and other typed literals
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return fmt.Errorf("literal too short: %x", b)
+		}
+	case 0x02, 0x03, 0x04:
+	default:
+		return fmt.Errorf("unknown term type %x", b[0])
+	}
+	_, err := rdf.DecodeTerm(b)
+	return err
+}
+
+// decodeEntry decodes the bitmap of an index entry with a key of the given
+// length.
+func decodeEntry(k, v []byte, keyLen int) ([]uint32, error) {
+	if len(k) != keyLen {
+		return nil, fmt.Errorf("invalid key length %d", len(k))
+	}
+	bitmap := roaring.NewRoaringBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+		return nil, fmt.Errorf("invalid bitmap: %v", err)
+	}
+	var ids []uint32
+	for i := bitmap.Iterator(); i.HasNext(); {
+		ids = append(ids, i.Next())
+	}
+	return ids, nil
+}
+
+// bitmapHas checks if the bitmap stored at key holds the ID.
+func bitmapHas(bkt *bolt.Bucket, key []byte, id uint32) bool {
+	bo := bkt.Get(key)
+	if bo == nil {
+		return false
+	}
+	bitmap := roaring.NewRoaringBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+		return false
+	}
+	return bitmap.Contains(id)
+}
+
+// setInIndex adds the ID to, or removes it from, the bitmap stored at key.
+// Entries left with an empty bitmap are deleted.
+func setInIndex(bkt *bolt.Bucket, key []byte, id uint32, present bool) error {
+	bitmap := roaring.NewRoaringBitmap()
+	if bo := bkt.Get(key); bo != nil {
+		if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+			return err
+		}
+	}
+	if present {
+		bitmap.Add(id)
+	} else {
+		bitmap.CheckedRemove(id)
+	}
+	if bitmap.GetCardinality() == 0 {
+		return bkt.Delete(key)
+	}
+	var b bytes.Buffer
+	if _, err := bitmap.WriteTo(&b); err != nil {
+		return err
+	}
+	return bkt.Put(key, b.Bytes())
+}
+
+// countTriples counts the triples in the SPO index.
+func countTriples(tx *bolt.Tx) (int64, error) {
+	var n int64
+	err := tx.Bucket(bSPO).ForEach(func(k, v []byte) error {
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+			return err
+		}
+		n += int64(bitmap.GetCardinality())
+		return nil
+	})
+	return n, err
+}
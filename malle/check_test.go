@@ -0,0 +1,103 @@
+package malle
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestCheckAndRepair(t *testing.T) {
+	const file = "_check.db"
+	db, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer db.Close()
+
+	tr1 := rdf.NewTriple("http://ex.org/c1", "http://ex.org/cp", mustNewLiteral("one"))
+	tr2 := rdf.NewTriple("http://ex.org/c1", "http://ex.org/cp", mustNewIRI("http://ex.org/c2"))
+	tr3 := rdf.NewTriple("http://ex.org/c2", "http://ex.org/cp", mustNewLiteral(3))
+	for _, tr := range []rdf.Triple{tr1, tr2, tr3} {
+		if err := db.AddTriple(tr, "http://ex.org/cg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if problems, err := db.Check(); err != nil || len(problems) != 0 {
+		t.Fatalf("Store.Check() on consistent store == %v, %v; want no problems", problems, err)
+	}
+
+	// Corrupt the store behind its back.
+	err = db.kv.Update(func(tx *bolt.Tx) error {
+		// Triple missing from an index.
+		s, _ := db.getID(tx, tr1.Subject())
+		p, _ := db.getID(tx, tr1.Predicate())
+		o, _ := db.getID(tx, tr1.Object())
+		if err := setInIndex(tx.Bucket(bOSP), compositeKey(o, s), p, false); err != nil {
+			return err
+		}
+		// Triple with a term that doesn't exist, which also skews the statistics.
+		if err := setInIndex(tx.Bucket(bPOS), compositeKey(p, 9999), s, true); err != nil {
+			return err
+		}
+		// Term not indexed.
+		b, _ := db.encode(tx, tr3.Object())
+		if err := tx.Bucket(bIdxTerms).Delete(b); err != nil {
+			return err
+		}
+		// Graph membership missing from one of the graph indices.
+		g, _ := db.getID(tx, rdf.IRI("http://ex.org/cg"))
+		o2, _ := db.getID(tx, tr2.Object())
+		return setInIndex(tx.Bucket(bGSPO), tripleKey(g, s, p), o2, false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := db.Check()
+	if err != nil || len(problems) != 5 {
+		t.Fatalf("Store.Check() == %v, %v; want 5 problems", problems, err)
+	}
+	for _, p := range problems {
+		if p.Repaired {
+			t.Errorf("Store.Check() problem %v marked as repaired", p)
+		}
+	}
+
+	problems, err = db.Repair()
+	if err != nil || len(problems) != 5 {
+		t.Fatalf("Store.Repair() == %v, %v; want 5 problems", problems, err)
+	}
+	for _, p := range problems {
+		if !p.Repaired {
+			t.Errorf("Store.Repair() did not repair %v", p)
+		}
+	}
+
+	if problems, err := db.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() after Store.Repair() == %v, %v; want no problems", problems, err)
+	}
+	for _, tr := range []rdf.Triple{tr1, tr2, tr3} {
+		if ok, _ := db.HasTriple(tr, "http://ex.org/cg"); !ok {
+			t.Errorf("Store.HasTriple(%v) after Store.Repair() == false; want true", tr)
+		}
+	}
+	if n := db.Stats().NumTriples; n != 3 {
+		t.Errorf("Store.Stats().NumTriples after Store.Repair() == %d; want 3", n)
+	}
+
+	// A term with a language tag longer than the encoding is reported,
+	// but cannot be repaired.
+	err = db.kv.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bTerms).Put(u32tob(9998), []byte{0x01, 0x05, 'a', 'b', 'c', 'd'})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := db.Check(); err != nil || len(problems) != 1 || problems[0].Bucket != "terms" {
+		t.Errorf("Store.Check() with corrupt term == %v, %v; want 1 problem in terms", problems, err)
+	}
+}
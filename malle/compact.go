@@ -0,0 +1,207 @@
+package malle
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/tgruben/roaring"
+)
+
+// Compact renumbers the terms of the database file densely, from 1 and in
+// the order of their current IDs, reclaiming the IDs of removed terms. The
+// triple, named graph, value, text, statistics and owl:sameAs indices are
+// rewritten with the new IDs, while all other buckets are copied as is. The
+// compacted database is written to a temporary file next to the database
+// file, which it replaces when done, so the database file is left untouched
+// if compaction fails.
+//
+// The database file must not be open while compacting. The indices must be
+// consistent; run Repair first if in doubt.
+func Compact(file string) error {
+	src, err := bolt.Open(file, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".compact")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name()) // no-op when moved into place
+
+	dst, err := bolt.Open(tmp.Name(), 0600, nil)
+	if err != nil {
+		return err
+	}
+	err = src.View(func(stx *bolt.Tx) error {
+		c, err := newCompactor(stx)
+		if err != nil {
+			return err
+		}
+		return stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return dst.Update(func(dtx *bolt.Tx) error {
+				return c.copyBucket(dtx, name, b)
+			})
+		})
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// compactor rewrites the buckets of a database with densely numbered terms.
+type compactor struct {
+	ids []uint32 // current term IDs, in order; the new ID of ids[i] is i+1
+}
+
+func newCompactor(tx *bolt.Tx) (*compactor, error) {
+	bkt := tx.Bucket(bTerms)
+	if bkt == nil {
+		return nil, fmt.Errorf("compact: missing bucket %q", bTerms)
+	}
+	c := &compactor{ids: make([]uint32, 0, bkt.Stats().KeyN)}
+	err := bkt.ForEach(func(k, v []byte) error {
+		c.ids = append(c.ids, btou32(k))
+		return nil
+	})
+	return c, err
+}
+
+// id returns the new ID of the term with the given current ID. The default
+// graph, 0, keeps its ID.
+func (c *compactor) id(id uint32) (uint32, error) {
+	if id == 0 {
+		return 0, nil
+	}
+	i := sort.Search(len(c.ids), func(i int) bool { return c.ids[i] >= id })
+	if i == len(c.ids) || c.ids[i] != id {
+		return 0, fmt.Errorf("compact: term %d does not exist", id)
+	}
+	return uint32(i + 1), nil
+}
+
+// key returns the given key with the IDs in it replaced by their new IDs.
+// The IDs are the 4-byte words of the key from the given offset.
+func (c *compactor) key(k []byte, offset int) ([]byte, error) {
+	if offset > len(k) || (len(k)-offset)%4 != 0 {
+		return nil, fmt.Errorf("compact: invalid key %x", k)
+	}
+	nk := make([]byte, len(k))
+	copy(nk, k[:offset])
+	for i := offset; i < len(k); i += 4 {
+		id, err := c.id(btou32(k[i:]))
+		if err != nil {
+			return nil, err
+		}
+		copy(nk[i:], u32tob(id))
+	}
+	return nk, nil
+}
+
+// bitmap returns the given bitmap with its IDs replaced by their new IDs.
+func (c *compactor) bitmap(v []byte) ([]byte, error) {
+	bitmap := roaring.NewRoaringBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+		return nil, err
+	}
+	renumbered := roaring.NewRoaringBitmap()
+	for it := bitmap.Iterator(); it.HasNext(); {
+		id, err := c.id(it.Next())
+		if err != nil {
+			return nil, err
+		}
+		renumbered.Add(id)
+	}
+	var b bytes.Buffer
+	if _, err := renumbered.WriteTo(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// copyBucket copies the bucket with the given name into the transaction,
+// renumbering the term IDs of its keys and values.
+func (c *compactor) copyBucket(tx *bolt.Tx, name []byte, src *bolt.Bucket) error {
+	dst, err := tx.CreateBucket(name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case bytes.Equal(name, bFree):
+		// All IDs are in use after compaction.
+		return nil
+	case bytes.Equal(name, bTerms):
+		if err := dst.SetSequence(uint64(len(c.ids))); err != nil {
+			return err
+		}
+	default:
+		if err := dst.SetSequence(src.Sequence()); err != nil {
+			return err
+		}
+	}
+
+	// How to renumber the keys and values of the bucket; keys from the
+	// given offset, and values as single IDs or as bitmaps.
+	offset, idValue, bitmapValue := -1, false, false
+	switch {
+	case bytes.Equal(name, bTerms), bytes.Equal(name, bStats):
+		offset = 0
+	case bytes.Equal(name, bIdxTerms):
+		idValue = true
+	case bytes.Equal(name, bIdxValues):
+		// The ID is the last 4 bytes of the key.
+	case bytes.Equal(name, bIdxText):
+		bitmapValue = true
+	case bytes.Equal(name, bSame):
+		offset, idValue = 0, true
+	case bytes.Equal(name, bCluster), bytes.Equal(name, bSPO), bytes.Equal(name, bOSP),
+		bytes.Equal(name, bPOS), bytes.Equal(name, bGSPO), bytes.Equal(name, bSPOG):
+		offset, bitmapValue = 0, true
+	}
+	values := bytes.Equal(name, bIdxValues)
+
+	return src.ForEach(func(k, v []byte) error {
+		var err error
+		switch {
+		case values:
+			if len(k) < 4 {
+				return fmt.Errorf("compact: invalid key %x in bucket %q", k, name)
+			}
+			k, err = c.key(k, len(k)-4)
+		case offset >= 0:
+			k, err = c.key(k, offset)
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case v == nil:
+			return fmt.Errorf("compact: unexpected nested bucket %q in bucket %q", k, name)
+		case idValue:
+			if len(v) != 4 {
+				return fmt.Errorf("compact: invalid value %x in bucket %q", v, name)
+			}
+			id, err := c.id(btou32(v))
+			if err != nil {
+				return err
+			}
+			v = u32tob(id)
+		case bitmapValue:
+			if v, err = c.bitmap(v); err != nil {
+				return err
+			}
+		}
+		return dst.Put(k, v)
+	})
+}
@@ -0,0 +1,168 @@
+package malle
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestTermIDReuse(t *testing.T) {
+	const file = "_reuse.db"
+	db, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer db.Close()
+
+	gone := rdf.NewTriple("r1", "rp", mustNewLiteral("gone"))
+	if err := db.AddTriple(gone); err != nil {
+		t.Fatal(err)
+	}
+	var goneID uint32
+	db.kv.View(func(tx *bolt.Tx) error {
+		goneID, err = db.getID(tx, mustNewLiteral("gone"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RemoveTriple(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lowest free ID is reused first.
+	back := rdf.NewTriple("r2", "rp", mustNewLiteral("back"))
+	if err := db.AddTriple(back); err != nil {
+		t.Fatal(err)
+	}
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		ids := make(map[uint32]bool)
+		for _, term := range []rdf.Term{rdf.IRI("r2"), rdf.IRI("rp"), mustNewLiteral("back")} {
+			id, err := db.getID(tx, term)
+			if err != nil {
+				return err
+			}
+			ids[id] = true
+		}
+		if !ids[goneID] {
+			t.Errorf("removed term ID %d not reused; got IDs %v", goneID, ids)
+		}
+		if seq := tx.Bucket(bTerms).Sequence(); seq != 3 {
+			t.Errorf("term sequence == %d; want 3", seq)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := db.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() == %v, %v; want no problems", problems, err)
+	}
+
+	// When all IDs are taken, and none are free, terms cannot be added.
+	err = db.kv.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bTerms).SetSequence(MaxTerms)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTriple(rdf.NewTriple("r3", "rp", mustNewLiteral("full"))); err != ErrTermsExhausted {
+		t.Errorf("Store.AddTriple() when full == %v; want %v", err, ErrTermsExhausted)
+	}
+	if err := db.RemoveTriple(back); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTriple(rdf.NewTriple("r2", "rp", mustNewLiteral("full"))); err != nil {
+		t.Errorf("Store.AddTriple() when full, with free IDs == %v; want <nil>", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	const file = "_compact.db"
+	db, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer func() { db.Close() }()
+
+	if err := db.SetSameAs(true); err != nil {
+		t.Fatal(err)
+	}
+	keep := rdf.NewGraph().
+		Add(rdf.NewTriple("book", "title", mustNewLiteral("Sult"))).
+		Add(rdf.NewTriple("book", "year", mustNewTypedLiteral("1890", rdf.XSDInteger))).
+		Add(rdf.NewTriple("book", "http://www.w3.org/2002/07/owl#sameAs", mustNewIRI("hunger"))).
+		Add(rdf.NewTriple("hunger", "creator", mustNewIRI("hamsun")))
+	drop := rdf.NewGraph().
+		Add(rdf.NewTriple("draft", "title", mustNewLiteral("Markens grøde"))).
+		Add(rdf.NewTriple("draft", "year", mustNewTypedLiteral("1917", rdf.XSDInteger)))
+	if err := db.ImportGraph(drop); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ImportGraph(keep); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ImportGraph(keep, "g1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteGraph(drop); err != nil {
+		t.Fatal(err)
+	}
+	stats := db.Stats()
+	last, err := db.LastChange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if err := Compact(file); err != nil {
+		t.Fatalf("Compact() == %v; want no error", err)
+	}
+	if db, err = Init(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// The terms are numbered densely, and the indices consistent.
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(bTerms).Cursor().Last()
+		if k == nil || int(btou32(k)) != stats.NumTerms || tx.Bucket(bTerms).Sequence() != uint64(stats.NumTerms) {
+			t.Errorf("compacted term IDs end at %x; want %d", k, stats.NumTerms)
+		}
+		if k, _ := tx.Bucket(bFree).Cursor().First(); k != nil {
+			t.Errorf("compacted store has free ID %x", k)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := db.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() after Compact() == %v, %v; want no problems", problems, err)
+	}
+	if got := db.Stats(); got.NumTriples != stats.NumTriples || got.NumTerms != stats.NumTerms {
+		t.Errorf("Store.Stats() after Compact() == %+v; want %+v", got, stats)
+	}
+	if seq, err := db.LastChange(); err != nil || seq != last {
+		t.Errorf("Store.LastChange() after Compact() == %d, %v; want %d, <nil>", seq, err, last)
+	}
+	for _, tr := range keep.Triples() {
+		for _, g := range []rdf.IRI{DefaultGraph, "g1"} {
+			if ok, err := db.HasTriple(tr, g); err != nil || !ok {
+				t.Errorf("Store.HasTriple(%v, %v) after Compact() == %v, %v; want true, <nil>", tr, g, ok, err)
+			}
+		}
+	}
+	if res, err := db.Search("sult", SearchOptions{}); err != nil || len(res) != 1 || res[0] != "book" {
+		t.Errorf("Store.Search(\"sult\") after Compact() == %v, %v; want [<book>]", res, err)
+	}
+	if c, err := db.Canonical("hunger"); err != nil || c != "book" && c != "hunger" {
+		t.Errorf("Store.Canonical(<hunger>) after Compact() == %v, %v", c, err)
+	}
+	if cluster, err := db.SameAsCluster("hunger"); err != nil || len(cluster) != 2 {
+		t.Errorf("Store.SameAsCluster(<hunger>) after Compact() == %v, %v; want 2 members", cluster, err)
+	}
+}
@@ -2,8 +2,11 @@ package malle
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"os"
@@ -20,7 +23,10 @@ import (
 const (
 	// MaxTerms is the maximum number of RDF terms that can be stored.
 	MaxTerms = 4294967295
-	// MaxResults is the maximum number of triples to return from a query. TODO make use of!
+	// MaxNamespaces is the maximum number of IRI namespaces that can be stored.
+	MaxNamespaces = 65535
+	// MaxResults is the maximum number of triples to return from a query,
+	// unless another limit is given.
 	MaxResults = 1000
 )
 
@@ -33,11 +39,37 @@ var (
 	bIdxDT    = []byte("idt")    // iri -> uint32
 	bNS       = []byte("ns")     // uint16 -> iri namespace
 	bIdxNS    = []byte("ins")    // iri namespace -> uint16
+	bPrefix   = []byte("prefix") // prefix -> iri namespace
+	bFree     = []byte("free")   // uint32 -> nil (IDs of removed terms, to be reused)
+
+	// Literal values, in order:
+	bIdxValues = []byte("vals") // kind + value + uint32 -> nil
+
+	// Full-text index:
+	bIdxText = []byte("text") // token -> bitmap of subjects
+
+	// Statistics, of predicates and of classes:
+	bStats = []byte("stats") // predicate uint32 -> triples, subjects, objects uint64
+	//                          rdf:type uint32 + class uint32 -> instances uint64
+
+	// owl:sameAs clusters:
+	bSame    = []byte("same")    // uint32 -> canonical uint32 (only IRIs linked by owl:sameAs)
+	bCluster = []byte("cluster") // canonical uint32 -> bitmap of members
 
 	// Triple indices       composite key         bitmap
 	bSPO = []byte("spo") // Subect + Predicate -> Object
 	bOSP = []byte("osp") // Object + Subject   -> Predicate
 	bPOS = []byte("pos") // Predicate + Object -> Subject
+
+	// Named graph indices                     composite key                      bitmap
+	bGSPO = []byte("gspo") // Graph + Subject + Predicate -> Object
+	bSPOG = []byte("spog") // Subject + Predicate + Object -> Graph (only triples in named graphs)
+
+	// Change log:
+	bLog = []byte("log") // uint64 sequence -> change
+
+	// Other:
+	bMeta = []byte("meta") // name -> value
 )
 
 // datatypes are the built in datatypes (IDs 0 through 41).
@@ -92,6 +124,19 @@ var datatypes = []string{
 var (
 	ErrDBFailure = errors.New("database error")
 	ErrNotFound  = errors.New("not found")
+
+	// ErrTermsExhausted is returned when a term cannot be stored since
+	// all IDs up to MaxTerms are taken. Compacting the database reclaims
+	// the IDs of removed terms, unless they are reused allready.
+	ErrTermsExhausted = errors.New("term IDs exhausted")
+
+	// ErrNamespacesExhausted is returned when a namespace cannot be
+	// stored since there are allready MaxNamespaces of them.
+	ErrNamespacesExhausted = errors.New("namespace IDs exhausted")
+
+	// ErrInvalidToken is returned when a query is continued with a token
+	// which was not returned by a query.
+	ErrInvalidToken = errors.New("invalid continuation token")
 )
 
 // Store is a RDF triple store backed by a key-value store (boltdb).
@@ -100,8 +145,18 @@ type Store struct {
 
 	numTr int64 // number of triples stored
 
-	mu sync.RWMutex // protects ns
-	ns *bimap.Map
+	mu       sync.RWMutex // protects ns, prefixes and validate
+	ns       *bimap.Map
+	prefixes rdf.Prefixes
+	validate Validator // checks graphs before they are imported; may be nil
+
+	pending []Change // changes of the current write transaction
+
+	entail bool // true if RDFS entailments are maintained
+	sameAs bool // true if owl:sameAs clusters are maintained
+
+	subMu sync.Mutex // protects subs
+	subs  map[chan Change]bool
 
 	// TODO: use and expose:
 	// Log *log.Logger
@@ -131,6 +186,7 @@ func Init(file string) (*Store, error) {
 
 // Close closes the datastore, relasing the lock on the database file.
 func (db *Store) Close() error {
+	db.closeSubscriptions()
 	return db.kv.Close()
 }
 
@@ -154,138 +210,89 @@ func (db *Store) Stats() Stats {
 	return st
 }
 
-// AddTriple stores the given Triple.
-func (db *Store) AddTriple(tr rdf.Triple) error {
-	err := db.kv.Update(func(tx *bolt.Tx) error {
-		sID, err := db.addTerm(tx, tr.Subject())
-		if err != nil {
-			return err
-		}
-
-		pID, err := db.addTerm(tx, tr.Predicate())
-		if err != nil {
-			return err
-		}
+// AddTriple stores the given Triple in each of the given named graphs, or in
+// the default graph if none are given.
+func (db *Store) AddTriple(tr rdf.Triple, graphs ...rdf.IRI) error {
+	return db.update(func(tx *bolt.Tx) error {
+		return db.addTriple(tx, tr, graphs)
+	})
+}
 
-		oID, err := db.addTerm(tx, tr.Object())
-		if err != nil {
-			return err
-		}
+// RemoveTriple removes the given Triple from each of the given named graphs,
+// or from the default graph if none are given. It also removes any Term
+// unique to that Triple from the store.
+// It return ErrNotFound if the Triple does not exist in the graphs.
+func (db *Store) RemoveTriple(tr rdf.Triple, graphs ...rdf.IRI) error {
+	return db.update(func(tx *bolt.Tx) error {
+		return db.removeTripleFrom(tx, tr, graphs)
+	})
+}
 
-		return db.storeTriple(tx, sID, pID, oID)
+// HasTriple checks if the given Triple is stored in any of the given graphs,
+// or in any graph at all if none are given.
+func (db *Store) HasTriple(tr rdf.Triple, graphs ...rdf.IRI) (exists bool, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		exists, err = db.hasTriple(tx, tr, graphs)
+		return err
 	})
-	return err
+	return exists, err
 }
 
-// RemoveTriple removes the given Triple from the indices. It also removes
-// any Term unique to that Triple from the store.
-// It return ErrNotFound if the Triple does not exist
-func (db *Store) RemoveTriple(tr rdf.Triple) error {
-	err := db.kv.Update(func(tx *bolt.Tx) error {
-		sID, err := db.getID(tx, tr.Subject())
+// ImportGraph imports the graph into the triple store, in each of the given
+// named graphs, or in the default graph if none are given. The graph is
+// rejected if the validator set with SetValidator returns an error for it.
+func (db *Store) ImportGraph(g rdf.Graph, graphs ...rdf.IRI) (err error) {
+	validate := db.validator()
+	err = db.update(func(tx *bolt.Tx) error {
+		gIDs, err := db.graphIDs(tx, graphs, true)
 		if err != nil {
 			return err
 		}
-
-		pID, err := db.getID(tx, tr.Predicate())
-		if err != nil {
+		if err := db.importGraph(tx, g, gIDs); err != nil {
 			return err
 		}
-
-		oID, err := db.getID(tx, tr.Object())
-		if err != nil {
-			return err
+		if validate != nil {
+			return validate(&Tx{db: db, tx: tx}, g)
 		}
-
-		return db.removeTriple(tx, sID, pID, oID)
+		return nil
 	})
 	return err
 }
 
-// HasTriple checks if the given Triple is stored.
-func (db *Store) HasTriple(tr rdf.Triple) (exists bool, err error) {
-	err = db.kv.View(func(tx *bolt.Tx) error {
-		sID, err := db.getID(tx, tr.Subject())
-		if err == ErrNotFound {
-			return nil
-		} else if err != nil {
-			return err
-		}
-		pID, err := db.getID(tx, tr.Predicate())
-		if err == ErrNotFound {
-			return nil
-		} else if err != nil {
-			return err
-		}
-		oID, err := db.getID(tx, tr.Object())
-		if err == ErrNotFound {
-			return nil
-		} else if err != nil {
-			return err
-		}
-
-		bkt := tx.Bucket(bSPO)
-
-		sp := make([]byte, 8)
-		copy(sp, u32tob(sID))
-		copy(sp[4:], u32tob(pID))
+// Validator checks a graph imported into the store, or the triples added and
+// removed by a patch. It is called within the write transaction, after the
+// changes are stored, so the transaction sees the store as it will be if
+// they are committed. Returning an error rejects the changes, rolling back
+// the transaction.
+type Validator func(tx *Tx, g rdf.Graph) error
+
+// SetValidator sets the validator of graphs imported with ImportGraph,
+// BulkImportGraph, Import and BulkImport, and of patches applied with
+// ApplyPatch. A nil validator accepts all graphs,
+// which is the default. The validator is not persisted.
+func (db *Store) SetValidator(v Validator) {
+	db.mu.Lock()
+	db.validate = v
+	db.mu.Unlock()
+}
 
-		bitmap := roaring.NewRoaringBitmap()
-		bo := bkt.Get(sp)
-		if bo == nil {
-			return nil
-		}
+// validator returns the validator set with SetValidator.
+func (db *Store) validator() Validator {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.validate
+}
 
-		_, err = bitmap.ReadFrom(bytes.NewReader(bo))
+// DeleteGraph deletes all the given graph's triples from each of the given
+// named graphs, or from the default graph if none are given.
+func (db *Store) DeleteGraph(g rdf.Graph, graphs ...rdf.IRI) (err error) {
+	// TODO removeOrpanedTerm after each iteration of subj, pred & obj
+	err = db.update(func(tx *bolt.Tx) error {
+		gIDs, err := db.graphIDs(tx, graphs, false)
 		if err != nil {
 			return err
 		}
 
-		exists = bitmap.Contains(oID)
-		return nil
-	})
-	return exists, err
-}
-
-// ImportGraph imports the graph into the triple store.
-func (db *Store) ImportGraph(g rdf.Graph) (err error) {
-	err = db.kv.Update(func(tx *bolt.Tx) error {
-		for subj, props := range g {
-
-			sID, err := db.addTerm(tx, subj)
-			if err != nil {
-				return err
-			}
-
-			for pred, terms := range props {
-				pID, err := db.addTerm(tx, pred)
-				if err != nil {
-					return err
-				}
-
-				for _, obj := range terms {
-					// TODO batch bitmap operations for all obj in terms
-					oID, err := db.addTerm(tx, obj)
-					if err != nil {
-						return err
-					}
-
-					err = db.storeTriple(tx, sID, pID, oID)
-					if err != nil {
-						return err
-					}
-				}
-			}
-		}
-		return nil
-	})
-	return err
-}
-
-// DeleteGraph deletes all the given graph's triples from the store.
-func (db *Store) DeleteGraph(g rdf.Graph) (err error) {
-	// TODO removeOrpanedTerm after each iteration of subj, pred & obj
-	err = db.kv.Update(func(tx *bolt.Tx) error {
 		for subj, props := range g {
 			sID, err := db.getID(tx, subj)
 			if err != nil {
@@ -316,9 +323,11 @@ func (db *Store) DeleteGraph(g rdf.Graph) (err error) {
 
 					// TODO inline removeTriple
 					// + removeOrphanedTerms should be batched
-					err = db.removeTriple(tx, sID, pID, oID)
-					if err != nil {
-						return err
+					for _, gID := range gIDs {
+						err = db.removeFromGraph(tx, sID, pID, oID, gID)
+						if err != nil {
+							return err
+						}
 					}
 				}
 			}
@@ -329,48 +338,26 @@ func (db *Store) DeleteGraph(g rdf.Graph) (err error) {
 }
 
 // Import imports triples from an N-Triples stream, in batches of given size.
-// It will ignore triples with blank nodes and errors. If the logErr flag is set it will log
+// It will ignore triples with errors. If the logErr flag is set it will log
 // such incidents. It returns the total number of triples imported (regardless if they where in the
-// store before or not)
+// store before or not). Blank nodes are replaced by skolem IRIs unique to the
+// import, as described for GenIDPrefix.
 func (db *Store) Import(r io.Reader, batchSize int, logErr bool) (int, error) {
-	dec := rdf.NewNTDecoder(r)
-	g := rdf.NewGraph()
-	c := 0 // totalt count
-	i := 0 // current batch count
-	for tr, err := dec.Decode(); err != io.EOF; tr, err = dec.Decode() {
-		if err != nil {
-			if logErr {
-				log.Println(err.Error())
-			}
-			continue
-		}
-		g.Add(tr)
-		i++
-		if i == batchSize {
-			err = db.ImportGraph(g)
-			if err != nil {
-				return c, err
-			}
-			c += i
-			i = 0
-			g = rdf.NewGraph()
-		}
-	}
-	if len(g) > 0 {
-		err := db.ImportGraph(g)
-		if err != nil {
-			return c, err
-		}
-		c += i
-	}
-	return c, nil
+	return db.importNTriples(r, batchSize, logErr, func(g rdf.Graph) error {
+		return db.ImportGraph(g)
+	})
 }
 
 // Query represents a query into the triple store.
 // A query always returns a rdf.Graph.
 type Query struct {
-	subj  rdf.IRI // starting node
-	depth int
+	subj   rdf.IRI // starting node
+	depth  int
+	graphs []rdf.IRI // graphs to query; all if empty
+	sameAs bool      // merge IRIs linked by owl:sameAs
+	limit  int       // maximum number of triples; MaxResults if 0
+	offset int       // number of triples to skip
+	token  string    // continuation token, overriding offset if set
 }
 
 // NewQuery returns a new Query.
@@ -399,106 +386,474 @@ func (q *Query) CBD(s rdf.IRI, depth int) *Query {
 	return q
 }
 
+// From restricts the query to the triples in the given graphs. Use
+// DefaultGraph to include the default graph.
+func (q *Query) From(graphs ...rdf.IRI) *Query {
+	q.graphs = graphs
+	return q
+}
+
+// Limit restricts the query to return at most n triples. If n is 0, which is
+// the default, at most MaxResults triples are returned.
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// Offset makes the query skip the first n triples.
+func (q *Query) Offset(n int) *Query {
+	q.offset = n
+	return q
+}
+
+// Continue makes the query return the triples following those of a previous
+// page, given the continuation token returned with it. It overrides Offset.
+func (q *Query) Continue(token string) *Query {
+	q.token = token
+	return q
+}
+
 // Query executes the query against the triple store, returning a graph
 // of the matching triples.
-func (db *Store) Query(q *Query) (g rdf.Graph, err error) {
+//
+// The triples are returned in pages of at most the limit of the query, in a
+// fixed order: the description of the starting node first, and then of its
+// neighbours, for CBD queries with depth > 0. If there are more triples, a
+// continuation token for the next page is returned, and is otherwise empty.
+// The token holds the position of the last triple of the page, so triples
+// added or removed before it do not shift the following pages. It can only
+// be used to continue the same query, or ErrInvalidToken is returned.
+func (db *Store) Query(q *Query) (g rdf.Graph, next string, err error) {
+	w := &window{offset: q.offset, limit: q.limit}
+	if q.token != "" {
+		if w.from, err = decodeToken(q, q.token); err != nil {
+			return nil, "", err
+		}
+		w.offset = 0
+	}
+	if w.limit <= 0 {
+		w.limit = MaxResults
+	}
 	g = rdf.NewGraph()
 	err = db.kv.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(bIdxTerms)
-		bt, err := db.encode(tx, q.subj)
+		sid, err := db.getID(tx, q.subj)
 		if err != nil {
-			return ErrNotFound
+			return err
 		}
-		bs := bkt.Get(bt)
-		if bs == nil {
-			return ErrNotFound
+
+		scope, err := db.scope(tx, q.graphs)
+		if err != nil {
+			return err
+		}
+
+		// With sameAs, every node stands for all members of its cluster.
+		members := func(id uint32) ([]uint32, error) { return []uint32{id}, nil }
+		if q.sameAs {
+			members = func(id uint32) ([]uint32, error) { return db.clusterMembers(tx, id) }
+		}
+		start, err := members(sid)
+		if err != nil {
+			return err
 		}
-		// seek in SPO index
-		sid := btou32(bs)
-		cur := tx.Bucket(bSPO).Cursor()
-	outerSPO:
-		for k, v := cur.Seek(u32tob(sid - 1)); k != nil; k, v = cur.Next() {
-			switch bytes.Compare(k[:4], bs) {
-			case 0:
-				bkt = tx.Bucket(bTerms)
-				b := bkt.Get(k[4:])
-				if b == nil {
-					panic("term should be there!")
+
+		if q.depth < 0 {
+			// The members are in order of ID.
+			for _, id := range start {
+				if w.from != nil && id < w.from.node {
+					continue
 				}
-				pred := db.decode(b)
-				bitmap := roaring.NewRoaringBitmap()
-				_, err = bitmap.ReadFrom(bytes.NewReader(v))
-				if err != nil {
+				if _, err = db.describe(tx, g, w, scope, 0, id, false, nil, false); err != nil {
 					return err
 				}
-				it := bitmap.Iterator()
-				for it.HasNext() {
-					o := it.Next()
-					b = bkt.Get(u32tob(o))
-					if b == nil {
-						panic("term should be there!")
-					}
-					g.Add(rdf.NewTriple(q.subj, pred.(rdf.IRI), db.decode(b)))
-				}
-			case 1:
-				break outerSPO
 			}
+		} else if err := db.describeBounded(tx, g, w, scope, start, q.depth, members); err != nil {
+			return err
 		}
 
-		// If doing a CBD query:
-		if q.depth == 0 {
-			// seek in OSP index
+		if q.sameAs {
+			g, err = db.canonicalGraph(tx, g)
+		}
+		return err
+	})
+	if err == ErrNotFound {
+		return g, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if w.more {
+		next = encodeToken(q, w.last)
+	}
+	return g, next, nil
+}
+
+// window is the page of triples to add to the graph of a query.
+type window struct {
+	offset int     // number of triples to skip
+	limit  int     // maximum number of triples to add
+	from   *cursor // position to continue after, if any
+	n      int     // number of triples seen, including those skipped
+	last   cursor  // position of the last triple added
+	more   bool    // true if there are triples after the window
+}
 
-			cur := tx.Bucket(bOSP).Cursor()
-		outerOSP:
-			for k, v := cur.Seek(u32tob(sid - 1)); k != nil; k, v = cur.Next() {
-				switch bytes.Compare(k[:4], bs) {
-				case 0:
-					bkt = tx.Bucket(bTerms)
-					b := bkt.Get(k[4:])
-					if b == nil {
-						panic("term should be there!")
-					}
-					subj := db.decode(b)
-					bitmap := roaring.NewRoaringBitmap()
-					_, err = bitmap.ReadFrom(bytes.NewReader(v))
-					if err != nil {
+// next counts another triple, and returns true if it is in the window. It
+// sets more if the triple is after the window, after which no more triples
+// are to be counted.
+func (w *window) next() bool {
+	w.n++
+	if w.n > w.offset+w.limit {
+		w.more = true
+	}
+	return w.n > w.offset && !w.more
+}
+
+// cursor is the position of a triple in the traversal of a query.
+type cursor struct {
+	level   int    // depth of the described node; 0 for Resource queries
+	node    uint32 // described node
+	pattern int    // 0 if the node is subject of the triple, 1 if object
+	k1, k2  uint32 // composite key of the triple in the index of the pattern
+	v       uint32 // ID of the triple in the bitmap of the key
+}
+
+// queryHash returns a hash of the query, binding continuation tokens to it.
+func queryHash(q *Query) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%d\x00%t", q.subj, q.depth, q.sameAs)
+	for _, g := range q.graphs {
+		fmt.Fprintf(h, "\x00%s", g)
+	}
+	return h.Sum64()
+}
+
+// encodeToken returns a continuation token for the page after the given
+// position in the query.
+func encodeToken(q *Query, c cursor) string {
+	b := make([]byte, 8+binary.MaxVarintLen64)
+	binary.BigEndian.PutUint64(b, queryHash(q))
+	b = b[:8+binary.PutUvarint(b[8:], uint64(c.level))]
+	b = append(b, byte(c.pattern))
+	for _, id := range []uint32{c.node, c.k1, c.k2, c.v} {
+		b = append(b, u32tob(id)...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeToken returns the position of a continuation token, which must be
+// one returned for the given query.
+func decodeToken(q *Query, token string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) < 8 || btou64(b) != queryHash(q) {
+		return nil, ErrInvalidToken
+	}
+	level, n := binary.Uvarint(b[8:])
+	if n <= 0 || len(b[8+n:]) != 17 || level > uint64(max(q.depth, 0)) {
+		return nil, ErrInvalidToken
+	}
+	b = b[8+n:]
+	c := &cursor{
+		level:   int(level),
+		pattern: int(b[0]),
+		node:    btou32(b[1:]),
+		k1:      btou32(b[5:]),
+		k2:      btou32(b[9:]),
+		v:       btou32(b[13:]),
+	}
+	// The composite keys of both patterns start with the described node.
+	if c.pattern > 1 || c.pattern == 1 && q.depth < 0 || c.k1 != c.node {
+		return nil, ErrInvalidToken
+	}
+	return c, nil
+}
+
+// Unexported methods ---------------------------------------------------------
+
+// describeBounded adds the CBD of the start nodes, up to the given depth, to
+// the graph. Each node is expanded to the given members, which are described
+// along with it. Only the triples of the window are added, and the
+// traversal stops when the window is filled.
+//
+// When continuing after a position, the nodes of the levels before the last
+// are traversed again to find the nodes of the following levels, but without
+// adding their triples, while the nodes of the last level before the
+// position are skipped.
+func (db *Store) describeBounded(tx *bolt.Tx, g rdf.Graph, w *window, scope graphScope, start []uint32, depth int, members func(uint32) ([]uint32, error)) error {
+	// explored holds the nodes which are described in both directions,
+	// seen holds the explored nodes and the nodes queued for exploring.
+	explored := roaring.NewRoaringBitmap()
+	seen := roaring.NewRoaringBitmap()
+	for _, id := range start {
+		seen.Add(id)
+	}
+	frontier := start
+	for d := 0; d <= depth && len(frontier) > 0; d++ {
+		if w.from != nil && d > w.from.level {
+			// The node of the position is no longer linked to.
+			w.from = nil
+		}
+		inner := d < depth // the neighbours of inner nodes are explored next
+		var next []uint32
+		for _, id := range frontier {
+			if w.more {
+				return nil
+			}
+			var neighbours []uint32
+			var err error
+			switch {
+			case w.from != nil && (d < w.from.level || id != w.from.node):
+				// Described in earlier pages.
+				if inner {
+					neighbours, err = db.describe(tx, g, nil, scope, d, id, true, explored.Contains, true)
+				}
+			case w.from != nil:
+				// Described in part in earlier pages.
+				if inner {
+					if neighbours, err = db.describe(tx, g, nil, scope, d, id, true, explored.Contains, true); err != nil {
 						return err
 					}
-					it := bitmap.Iterator()
-					for it.HasNext() {
-						o := it.Next()
-						b = bkt.Get(u32tob(o))
-						if b == nil {
-							panic("term should be there!")
-						}
-						g.Add(rdf.NewTriple(subj.(rdf.IRI), db.decode(b).(rdf.IRI), q.subj))
+				}
+				_, err = db.describe(tx, g, w, scope, d, id, true, explored.Contains, false)
+			default:
+				neighbours, err = db.describe(tx, g, w, scope, d, id, true, explored.Contains, inner)
+			}
+			if err != nil {
+				return err
+			}
+			explored.Add(id)
+			for _, nb := range neighbours {
+				ms, err := members(nb)
+				if err != nil {
+					return err
+				}
+				for _, m := range ms {
+					if seen.CheckedAdd(m) {
+						next = append(next, m)
 					}
-				case 1:
-					break outerOSP
 				}
 			}
+		}
+		frontier = next
+	}
+	return nil
+}
 
-		} else if q.depth > 0 {
-			panic("TODO query CBD with depth > 0")
+// describe adds the triples where the given term ID is subject to the graph, and
+// also those where it is object if incoming is true. Only triples in the graphs
+// of the given scope are considered. Triples linking to an explored node are
+// skipped, since they are allready in the graph. Only the triples of the window
+// are added, and no more are considered once it is filled. If the window
+// continues from a position in the description of the node, only the triples
+// after it are considered. If the window is nil, no triples are added.
+// It returns the IDs of the IRIs linked to, including by skipped triples, if
+// neighbours is true.
+func (db *Store) describe(tx *bolt.Tx, g rdf.Graph, w *window, scope graphScope, level int, id uint32, incoming bool, explored func(uint32) bool, neighbours bool) (linked []uint32, err error) {
+	var from *cursor
+	if w != nil && w.from != nil {
+		if w.from.level == level && w.from.node == id {
+			from = w.from
+		}
+		w.from = nil
+	}
+	patterns := [][3]uint32{{id, 0, 0}}
+	if incoming {
+		patterns = append(patterns, [3]uint32{0, 0, id})
+	}
+	for i, pat := range patterns {
+		it := db.matchIDs(tx, pat[0], pat[1], pat[2])
+		it.scope = scope
+		if from != nil {
+			if i < from.pattern {
+				continue
+			}
+			if i == from.pattern {
+				it.seek(from.k1, from.k2, from.v)
+			}
+		}
+		for s, p, o, err := it.nextIDs(); err != io.EOF; s, p, o, err = it.nextIDs() {
+			if err != nil {
+				return linked, err
+			}
+			other := o
+			if i == 1 {
+				if s == id {
+					// self-reference, allready added as outgoing
+					continue
+				}
+				other = s
+			}
+			if explored != nil && explored(other) {
+				continue
+			}
+			in := false
+			if w != nil {
+				in = w.next()
+				if w.more {
+					return linked, nil
+				}
+			}
+			if neighbours && (i == 1 || db.isIRI(tx, o)) {
+				linked = append(linked, other)
+			}
+			if !in {
+				continue
+			}
+			tr, err := it.triple(s, p, o)
+			if err != nil {
+				return linked, err
+			}
+			g.Add(tr)
+			k1, k2, v := it.position()
+			w.last = cursor{level: level, node: id, pattern: i, k1: k1, k2: k2, v: v}
 		}
+	}
+	return linked, nil
+}
 
-		return nil
+// update runs the function in a read-write transaction, maintaining the RDFS
+// entailments if on. If the transaction is rolled back, the triple count and
+// namespace dictionary are restored, otherwise the logged changes are
+// published to subscribers.
+func (db *Store) update(fn func(*bolt.Tx) error) error {
+	return db.updateTx(fn, true)
+}
+
+// updateTx works like update, but the RDFS entailments are only maintained if
+// entail is true; replicated changes include those of the entailments.
+func (db *Store) updateTx(fn func(*bolt.Tx) error, entail bool) error {
+	// Write transactions are serialized, so no one else can change
+	// the count or pending changes while this one runs.
+	var changes []Change
+	n := atomic.LoadInt64(&db.numTr)
+	err := db.kv.Update(func(tx *bolt.Tx) error {
+		db.pending = nil
+		err := fn(tx)
+		if err == nil && entail && db.entail {
+			err = db.maintainEntailments(tx, db.pending)
+		}
+		changes, db.pending = db.pending, nil
+		return err
 	})
-	if err == ErrNotFound {
-		return g, nil
+	if err != nil {
+		atomic.StoreInt64(&db.numTr, n)
+		db.kv.View(func(tx *bolt.Tx) error {
+			db.loadNamespaces(tx)
+			return nil
+		})
+		return err
+	}
+	db.publish(changes)
+	return nil
+}
+
+// addTriple works like the exported AddTriple, but using the given transaction.
+func (db *Store) addTriple(tx *bolt.Tx, tr rdf.Triple, graphs []rdf.IRI) error {
+	sID, err := db.addTerm(tx, tr.Subject())
+	if err != nil {
+		return err
+	}
+
+	pID, err := db.addTerm(tx, tr.Predicate())
+	if err != nil {
+		return err
 	}
 
-	return g, err
+	oID, err := db.addTerm(tx, tr.Object())
+	if err != nil {
+		return err
+	}
+
+	gIDs, err := db.graphIDs(tx, graphs, true)
+	if err != nil {
+		return err
+	}
+
+	for _, gID := range gIDs {
+		if err := db.storeInGraph(tx, sID, pID, oID, gID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Unexported methods ---------------------------------------------------------
+// removeTripleFrom works like the exported RemoveTriple, but using the given transaction.
+func (db *Store) removeTripleFrom(tx *bolt.Tx, tr rdf.Triple, graphs []rdf.IRI) error {
+	sID, err := db.getID(tx, tr.Subject())
+	if err != nil {
+		return err
+	}
+
+	pID, err := db.getID(tx, tr.Predicate())
+	if err != nil {
+		return err
+	}
+
+	oID, err := db.getID(tx, tr.Object())
+	if err != nil {
+		return err
+	}
+
+	gIDs, err := db.graphIDs(tx, graphs, false)
+	if err != nil {
+		return err
+	}
+	if len(gIDs) < len(graphs) {
+		return ErrNotFound
+	}
+
+	for _, gID := range gIDs {
+		if err := db.removeFromGraph(tx, sID, pID, oID, gID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasTriple works like the exported HasTriple, but using the given transaction.
+func (db *Store) hasTriple(tx *bolt.Tx, tr rdf.Triple, graphs []rdf.IRI) (bool, error) {
+	sID, err := db.getID(tx, tr.Subject())
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	pID, err := db.getID(tx, tr.Predicate())
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	oID, err := db.getID(tx, tr.Object())
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	exists, err := db.hasIDs(tx, sID, pID, oID)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	scope, err := db.scope(tx, graphs)
+	if err != nil {
+		return false, err
+	}
+	return db.inScope(tx, scope, sID, pID, oID)
+}
 
 // setup makes sure the database has all the needed buckets and predefined values
 func (db *Store) setup() (*Store, error) {
 	err := db.kv.Update(func(tx *bolt.Tx) error {
+		// The value and text indices, and the statistics, are built
+		// from the stored terms and triples if missing, as in databases
+		// created before they were introduced.
+		indexValues := tx.Bucket(bIdxValues) == nil
+		indexText := tx.Bucket(bIdxText) == nil
+		countStats := tx.Bucket(bStats) == nil
+
 		// Make sure all the required buckets are created
-		for _, b := range [][]byte{bTerms, bIdxTerms, bDT, bIdxDT, bSPO, bOSP, bPOS, bNS, bIdxNS} {
+		for _, b := range [][]byte{bTerms, bIdxTerms, bDT, bIdxDT, bSPO, bOSP, bPOS, bGSPO, bSPOG, bLog, bMeta, bNS, bIdxNS, bIdxValues, bIdxText, bStats, bSame, bCluster, bFree, bPrefix} {
 			_, err := tx.CreateBucketIfNotExists(b)
 			if err != nil {
 				return err
@@ -518,14 +873,24 @@ func (db *Store) setup() (*Store, error) {
 			i++
 		}
 
-		// Read namepsace dictionary into a Bimap
-		bkt = tx.Bucket(bNS)
-		cur = bkt.Cursor()
-		stats := bkt.Stats()
-		db.ns = bimap.New(max(stats.KeyN, 1))
+		db.loadNamespaces(tx)
+		db.entail = tx.Bucket(bMeta).Get(metaEntailment) != nil
+		db.sameAs = tx.Bucket(bMeta).Get(metaSameAs) != nil
 
-		for k, v := cur.First(); k != nil; k, v = cur.Next() {
-			db.ns.Add(string(v), btou16(k))
+		if indexValues {
+			if err := db.indexAllValues(tx); err != nil {
+				return err
+			}
+		}
+		if indexText {
+			if err := db.indexAllText(tx); err != nil {
+				return err
+			}
+		}
+		if countStats {
+			if err := db.countAllStats(tx); err != nil {
+				return err
+			}
 		}
 
 		// Count number of triples
@@ -550,6 +915,30 @@ func (db *Store) setup() (*Store, error) {
 	return db, err
 }
 
+// loadNamespaces reads the namespace dictionary into a Bimap, and the
+// prefixes of the namespaces into a map.
+func (db *Store) loadNamespaces(tx *bolt.Tx) {
+	bkt := tx.Bucket(bNS)
+	cur := bkt.Cursor()
+	stats := bkt.Stats()
+	ns := bimap.New(max(stats.KeyN, 1))
+
+	for k, v := cur.First(); k != nil; k, v = cur.Next() {
+		ns.Add(string(v), btou16(k))
+	}
+
+	prefixes := make(rdf.Prefixes)
+	tx.Bucket(bPrefix).ForEach(func(k, v []byte) error {
+		prefixes[string(k)] = rdf.IRI(v)
+		return nil
+	})
+
+	db.mu.Lock()
+	db.ns = ns
+	db.prefixes = prefixes
+	db.mu.Unlock()
+}
+
 func (db *Store) getOrSetNS(tx *bolt.Tx, ns string) (uint16, error) {
 	db.mu.RLock()
 	nsID, ok := db.ns.FindByStr(ns)
@@ -559,6 +948,11 @@ func (db *Store) getOrSetNS(tx *bolt.Tx, ns string) (uint16, error) {
 	}
 	db.mu.RUnlock()
 
+	bkt := tx.Bucket(bNS)
+	if bkt.Sequence() >= MaxNamespaces {
+		return 0, ErrNamespacesExhausted
+	}
+
 	if !tx.Writable() {
 		// We are in a read transaction, so creating a new ns entry doesn't make sense
 		// TODO split encode into two functions: on with and without side-effects
@@ -566,7 +960,6 @@ func (db *Store) getOrSetNS(tx *bolt.Tx, ns string) (uint16, error) {
 	}
 
 	// new ns, write to store and bimap
-	bkt := tx.Bucket(bNS)
 	n, err := bkt.NextSequence()
 	if err != nil {
 		log.Println(err)
@@ -588,6 +981,18 @@ func (db *Store) encode(tx *bolt.Tx, term rdf.Term) ([]byte, error) {
 	switch t := term.(type) {
 	case rdf.IRI:
 		prefix, suffix := splitIRI(t.Value().(string))
+		var nsID uint16
+		if prefix != suffix {
+			var err error
+			nsID, err = db.getOrSetNS(tx, prefix)
+			if err == ErrNamespacesExhausted {
+				// The IRI is stored without namespace. Since namespaces
+				// are never removed, it is encoded the same way from now on.
+				prefix = suffix
+			} else if err != nil {
+				return nil, err
+			}
+		}
 		if prefix == suffix {
 			b := t.Bytes()
 			bn := make([]byte, len(b)+2)
@@ -597,10 +1002,6 @@ func (db *Store) encode(tx *bolt.Tx, term rdf.Term) ([]byte, error) {
 			copy(bn[3:], b[1:])
 			return bn, nil
 		}
-		nsID, err := db.getOrSetNS(tx, prefix)
-		if err != nil {
-			return nil, err
-		}
 
 		b := make([]byte, len(suffix)+3)
 		binary.BigEndian.PutUint16(b[1:], nsID)
@@ -708,19 +1109,16 @@ func (db *Store) addTerm(tx *bolt.Tx, term rdf.Term) (id uint32, err error) {
 		log.Println(err)
 		return uint32(0), err
 	}
-	bkt := tx.Bucket(bTerms)
-	n, err := bkt.NextSequence()
+	id, err = db.nextID(tx)
 	if err != nil {
-		log.Println(err)
-		return uint32(0), ErrDBFailure
+		return uint32(0), err
 	}
-	// TODO err if id > max uint32 = 4294967295
-	id = uint32(n)
-	idb := u32tob(uint32(n))
+	idb := u32tob(id)
 	bt, err := db.encode(tx, term)
 	if err != nil {
 		return uint32(0), err
 	}
+	bkt := tx.Bucket(bTerms)
 	err = bkt.Put(idb, bt)
 	if err != nil {
 		log.Println(err)
@@ -728,11 +1126,35 @@ func (db *Store) addTerm(tx *bolt.Tx, term rdf.Term) (id uint32, err error) {
 	}
 	bkt = tx.Bucket(bIdxTerms)
 	err = bkt.Put(bt, idb)
-	return id, err
+	if err != nil {
+		return uint32(0), err
+	}
+	return id, db.indexValue(tx, id, term)
+}
+
+// nextID returns an ID for a new term; the lowest ID of a removed term if
+// there are any, and otherwise the next in sequence.
+func (db *Store) nextID(tx *bolt.Tx) (uint32, error) {
+	free := tx.Bucket(bFree)
+	if k, _ := free.Cursor().First(); k != nil {
+		id := btou32(k)
+		return id, free.Delete(k)
+	}
+	bkt := tx.Bucket(bTerms)
+	if bkt.Sequence() >= MaxTerms {
+		return uint32(0), ErrTermsExhausted
+	}
+	n, err := bkt.NextSequence()
+	if err != nil {
+		log.Println(err)
+		return uint32(0), ErrDBFailure
+	}
+	return uint32(n), nil
 }
 
-// storeTriple stores a triple in the indices.
-func (db *Store) storeTriple(tx *bolt.Tx, s, p, o uint32) error {
+// storeTriple stores a triple in the indices. It returns false if the
+// triple was allready stored.
+func (db *Store) storeTriple(tx *bolt.Tx, s, p, o uint32) (bool, error) {
 	indices := []struct {
 		k1 uint32
 		k2 uint32
@@ -745,8 +1167,9 @@ func (db *Store) storeTriple(tx *bolt.Tx, s, p, o uint32) error {
 	}
 
 	key := make([]byte, 8)
+	var newKey [3]bool // true if the composite key of the index is new
 
-	for _, i := range indices {
+	for n, i := range indices {
 		bkt := tx.Bucket(i.bk)
 		copy(key, u32tob(i.k1))
 		copy(key[4:], u32tob(i.k2))
@@ -756,27 +1179,41 @@ func (db *Store) storeTriple(tx *bolt.Tx, s, p, o uint32) error {
 		if bo != nil {
 			_, err := bitmap.ReadFrom(bytes.NewReader(bo))
 			if err != nil {
-				return err
+				return false, err
 			}
 		}
+		newKey[n] = bo == nil
 
 		newTriple := bitmap.CheckedAdd(i.v)
 		if !newTriple {
-			return nil
+			return false, nil
 		}
 		var b bytes.Buffer
 		_, err := bitmap.WriteTo(&b)
 		if err != nil {
-			return err
+			return false, err
 		}
 		err = bkt.Put(key, b.Bytes())
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
 	atomic.AddInt64(&db.numTr, 1)
 
-	return nil
+	// A new SPO key means a new subject for the predicate, and a new
+	// POS key a new object.
+	if err := db.updateStats(tx, p, 1, b2i(newKey[0]), b2i(newKey[2])); err != nil {
+		return false, err
+	}
+	if err := db.updateClassStats(tx, [][3]uint32{{s, p, o}}, 1); err != nil {
+		return false, err
+	}
+	if db.sameAs {
+		if err := db.linkSameAs(tx, [][3]uint32{{s, p, o}}); err != nil {
+			return false, err
+		}
+	}
+	return true, db.indexText(tx, [][3]uint32{{s, p, o}})
 }
 
 // removeTriple removes a triple from the indices. If the triple
@@ -797,7 +1234,8 @@ func (db *Store) removeTriple(tx *bolt.Tx, s, p, o uint32) error {
 	}
 
 	key := make([]byte, 8)
-	for _, i := range indices {
+	var emptied [3]bool // true if the composite key of the index is removed
+	for n, i := range indices {
 		bkt := tx.Bucket(i.bk)
 		copy(key, u32tob(i.k1))
 		copy(key[4:], u32tob(i.k2))
@@ -818,6 +1256,7 @@ func (db *Store) removeTriple(tx *bolt.Tx, s, p, o uint32) error {
 		}
 		// Remove from index if bitmap is empty
 		if bitmap.GetCardinality() == 0 {
+			emptied[n] = true
 			err = bkt.Delete(key)
 			if err != nil {
 				return err
@@ -837,16 +1276,30 @@ func (db *Store) removeTriple(tx *bolt.Tx, s, p, o uint32) error {
 
 	atomic.AddInt64(&db.numTr, -1)
 
+	if err := db.updateStats(tx, p, -1, -b2i(emptied[0]), -b2i(emptied[2])); err != nil {
+		return err
+	}
+	if err := db.updateClassStats(tx, [][3]uint32{{s, p, o}}, -1); err != nil {
+		return err
+	}
+	if err := db.unindexText(tx, s, o); err != nil {
+		return err
+	}
+	if db.sameAs {
+		if err := db.unlinkSameAs(tx, s, p, o); err != nil {
+			return err
+		}
+	}
 	return db.removeOrphanedTerms(tx, s, p, o)
 }
 
 // removeOrphanedTerms removes any of the given Terms if they are no longer
-// part of any triple.
-func (db *Store) removeOrphanedTerms(tx *bolt.Tx, s, p, o uint32) error {
+// part of any triple, nor the name of any graph.
+func (db *Store) removeOrphanedTerms(tx *bolt.Tx, ids ...uint32) error {
 	// TODO by now we don't know whether object is a Literal or and IRI.
 	// If we knew it to be a Literal, checking the OSP index would suffice.
-	for _, id := range []uint32{s, p, o} {
-		if db.notInIndex(tx, id, bSPO) && db.notInIndex(tx, id, bOSP) && db.notInIndex(tx, id, bPOS) {
+	for _, id := range ids {
+		if db.notInIndex(tx, id, bSPO) && db.notInIndex(tx, id, bOSP) && db.notInIndex(tx, id, bPOS) && db.notInIndex(tx, id, bGSPO) {
 			err := db.removeTerm(tx, id)
 			if err != nil {
 				if err == ErrNotFound {
@@ -890,11 +1343,61 @@ func (db *Store) removeTerm(tx *bolt.Tx, termID uint32) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	// The ID is free for reuse, as no index refers to the term anymore.
+	err = tx.Bucket(bFree).Put(u32tob(termID), []byte{})
+	if err != nil {
+		return err
+	}
+	return db.unindexValue(tx, termID, term)
 }
 
 // Helper functions -----------------------------------------------------------
 
+// importNTriples decodes triples from an N-Triples stream, skolemizing blank
+// nodes, and calls load with batches of the given size. It returns the total
+// number of triples loaded.
+func (db *Store) importNTriples(r io.Reader, batchSize int, logErr bool, load func(rdf.Graph) error) (int, error) {
+	dec := rdf.NewNTDecoder(r)
+	sk := db.newSkolemizer()
+	dec.BNodeFunc = sk.iri
+	g := rdf.NewGraph()
+	c := 0 // totalt count
+	i := 0 // current batch count
+	for tr, err := dec.Decode(); err != io.EOF; tr, err = dec.Decode() {
+		if err != nil {
+			if logErr {
+				log.Println(err.Error())
+			}
+			continue
+		}
+		g.Add(tr)
+		i++
+		if i == batchSize {
+			if sk.err != nil {
+				return c, sk.err
+			}
+			err = load(g)
+			if err != nil {
+				return c, err
+			}
+			c += i
+			i = 0
+			g = rdf.NewGraph()
+		}
+	}
+	if sk.err != nil {
+		return c, sk.err
+	}
+	if len(g) > 0 {
+		err := load(g)
+		if err != nil {
+			return c, err
+		}
+		c += i
+	}
+	return c, nil
+}
+
 // u32tob converts a uint32 into a 4-byte slice.
 func u32tob(v uint32) []byte {
 	b := make([]byte, 4)
@@ -907,6 +1410,18 @@ func btou32(b []byte) uint32 {
 	return binary.BigEndian.Uint32(b)
 }
 
+// u64tob converts a uint64 into an 8-byte slice.
+func u64tob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// btou64 converts an 8-byte slice into an uint64.
+func btou64(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
 // u16tob converts a uint16 into a 2-byte slice.
 func u16tob(v uint16) []byte {
 	b := make([]byte, 2)
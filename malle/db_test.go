@@ -374,7 +374,7 @@ func TestResourceQuery(t *testing.T) {
 		t.Fatalf("Store.ImportGraph(%v) == %v; want no error", g, err)
 	}
 
-	res, err := testDB.Query(NewQuery().Resource(s))
+	res, _, err := testDB.Query(NewQuery().Resource(s))
 	if err != nil {
 		t.Fatalf("Store.Query(NewQuery().Resource(%v)) == %v; want no error", s, err)
 	}
@@ -407,25 +407,135 @@ func TestCBDQuery(t *testing.T) {
 <z1> <p4> "c" .
 <z3> <p1> <z1> .`))
 
-	res, err := testDB.Query(q)
+	res, _, err := testDB.Query(q)
 	if err != nil || !want.Eq(res) {
 		t.Fatalf("Store.Query(NewQuery().CBD(%v, 0)) == %v, %v; want %v, <nil>", s, res, err, want)
 	}
 
 }
 
+func TestQueryPages(t *testing.T) {
+	g := rdf.NewGraph()
+	for i := 0; i < 15; i++ {
+		g.Add(rdf.NewTriple("pg1", "pgp", mustNewLiteral(fmt.Sprintf("value %d", i))))
+		g.Add(rdf.NewTriple(rdf.IRI(fmt.Sprintf("pgs%d", i)), "pgp", mustNewIRI("pg1")))
+	}
+	g.Add(rdf.NewTriple("pgs1", "pgp", mustNewLiteral("neighbour")))
+	if err := testDB.ImportGraph(g); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, depth := range []int{0, 1} {
+		want := 30 + depth
+		all := rdf.NewGraph()
+		n, pages := 0, 0
+		for next := ""; pages == 0 || next != ""; pages++ {
+			res, token, err := testDB.Query(NewQuery().CBD("pg1", depth).Limit(7).Continue(next))
+			if err != nil {
+				t.Fatalf("Store.Query(CBD(pg1, %d).Limit(7).Continue(%q)) == %v", depth, next, err)
+			}
+			if c := len(res.Triples()); c > 7 || c < 7 && token != "" {
+				t.Errorf("Store.Query(CBD(pg1, %d).Limit(7).Continue(%q)) returned %d triples, and token %q", depth, next, c, token)
+			}
+			for _, tr := range res.Triples() {
+				all.Add(tr)
+				n++
+			}
+			next = token
+		}
+		if n != want || len(all.Triples()) != want || pages != (want+6)/7 {
+			t.Errorf("paged Store.Query(CBD(pg1, %d)) returned %d triples, %d distinct, in %d pages; want %d in %d pages",
+				depth, n, len(all.Triples()), pages, want, (want+6)/7)
+		}
+	}
+
+	res, next, err := testDB.Query(NewQuery().Resource("pg1").Offset(10))
+	if err != nil || len(res.Triples()) != 5 || next != "" {
+		t.Errorf("Store.Query(Resource(pg1).Offset(10)) == %v, %q, %v; want 5 triples, no token", res, next, err)
+	}
+	if _, _, err := testDB.Query(NewQuery().Resource("pg1").Continue("not a token")); err != ErrInvalidToken {
+		t.Errorf("Store.Query() with invalid token == %v; want %v", err, ErrInvalidToken)
+	}
+	if _, next, _ = testDB.Query(NewQuery().Resource("pg1").Limit(7)); next == "" {
+		t.Fatal("Store.Query(Resource(pg1).Limit(7)) returned no token")
+	}
+	for _, q := range []*Query{NewQuery().Resource("pgs1"), NewQuery().CBD("pg1", 0), NewQuery().Resource("pg1").From("pgg")} {
+		if _, _, err := testDB.Query(q.Continue(next)); err != ErrInvalidToken {
+			t.Errorf("Store.Query() with token of another query == %v; want %v", err, ErrInvalidToken)
+		}
+	}
+
+	// At most MaxResults triples are returned by default.
+	g = rdf.NewGraph()
+	for i := 0; i < MaxResults+1; i++ {
+		g.Add(rdf.NewTriple(rdf.IRI(fmt.Sprintf("pgm%d", i)), "pgp", mustNewIRI("pg2")))
+	}
+	if err := testDB.ImportGraph(g); err != nil {
+		t.Fatal(err)
+	}
+	res, next, err = testDB.Query(NewQuery().CBD("pg2", 0))
+	if err != nil || len(res.Triples()) != MaxResults || next == "" {
+		t.Fatalf("Store.Query(CBD(pg2, 0)) returned %d triples, token %q, %v; want %d triples and a token", len(res.Triples()), next, err, MaxResults)
+	}
+	if res, next, err = testDB.Query(NewQuery().CBD("pg2", 0).Continue(next)); err != nil || len(res.Triples()) != 1 || next != "" {
+		t.Errorf("Store.Query(CBD(pg2, 0)) second page == %v, %q, %v; want 1 triple and no token", res, next, err)
+	}
+
+	// The pages of a resource with more than MaxResults triples hold all
+	// of them once, also when triples before the position of the token
+	// are removed between pages.
+	const n = 2*MaxResults + 500
+	g = rdf.NewGraph()
+	for i := 0; i < n; i++ {
+		g.Add(rdf.NewTriple("pg3", rdf.IRI(fmt.Sprintf("pgp%d", i%3)), mustNewLiteral(fmt.Sprintf("value %d", i))))
+	}
+	if err := testDB.ImportGraph(g); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	var removed rdf.Triple
+	pages := 0
+	for next = ""; pages == 0 || next != ""; pages++ {
+		res, next, err = testDB.Query(NewQuery().Resource("pg3").Continue(next))
+		if err != nil {
+			t.Fatalf("Store.Query(Resource(pg3)) page %d == %v", pages, err)
+		}
+		for _, tr := range res.Triples() {
+			seen[tr.String()]++
+		}
+		if pages == 0 {
+			removed = res.Triples()[0]
+			if err := testDB.RemoveTriple(removed); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if pages != 3 || len(seen) != n {
+		t.Errorf("paged Store.Query(Resource(pg3)) returned %d distinct triples in %d pages; want %d in 3 pages", len(seen), pages, n)
+	}
+	for _, tr := range g.Triples() {
+		if c := seen[tr.String()]; c != 1 {
+			t.Errorf("paged Store.Query(Resource(pg3)) returned %v %d times; want once", tr, c)
+		}
+	}
+	if err := testDB.AddTriple(removed); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestImport(t *testing.T) {
 	graph := `<s_1> <p_1> <o_1> .
 <s_1> <p_1> "abc . # invalid triple
 <s_2> z f . # another invalid triple
-_:b1 <p_2> <o_1> . # triples with blank node are ignored
+_:b1 <p_2> <o_1> . # blank nodes are skolemized
+<s_1> _:b2 <o_1> . # but not as predicates
 <s_1> <p_2> "oz"@fr .
 # a blank line
 <s_11> <p_1> <o_1> .`
 
 	n, err := testDB.Import(bytes.NewBufferString(graph), 10, false)
-	if err != nil || n != 3 {
-		t.Fatalf("Store.Import(%s) == %d, %v; want 3, <nil>", graph, n, err)
+	if err != nil || n != 4 {
+		t.Fatalf("Store.Import(%s) == %d, %v; want 4, <nil>", graph, n, err)
 	}
 }
 
@@ -469,3 +579,46 @@ func TestDeleteGraph(t *testing.T) {
 		}
 	}
 }
+
+func TestCBDQueryWithDepth(t *testing.T) {
+	graph := `<y1> <p1> <y2> .
+<y1> <p2> "a" .
+<y2> <p1> <y3> .
+<y2> <p2> "b" .
+<y3> <p1> <y1> .
+<y3> <p1> <y4> .
+<y4> <p2> "c" .
+<y5> <p1> <y2> .
+<y6> <p1> <y5> .
+`
+	_, err := testDB.Import(bytes.NewBufferString(graph), 10, false)
+	if err != nil {
+		t.Fatalf("Store.Import(%s) == %v; want no error", graph, err)
+	}
+
+	tests := []struct {
+		depth int
+		want  string
+	}{
+		{0, `<y1> <p1> <y2> .
+<y1> <p2> "a" .
+<y3> <p1> <y1> .`},
+		{1, `<y1> <p1> <y2> .
+<y1> <p2> "a" .
+<y3> <p1> <y1> .
+<y2> <p1> <y3> .
+<y2> <p2> "b" .
+<y5> <p1> <y2> .
+<y3> <p1> <y4> .`},
+		{10, graph},
+	}
+
+	s := mustNewIRI("y1")
+	for _, test := range tests {
+		want := rdf.Load(bytes.NewBufferString(test.want))
+		res, _, err := testDB.Query(NewQuery().CBD(s, test.depth))
+		if err != nil || !want.Eq(res) {
+			t.Errorf("Store.Query(NewQuery().CBD(%v, %d)) == %v, %v; want %v, <nil>", s, test.depth, res, err, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package malle
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// ExportOptions restricts which triples are exported. The zero value
+// exports all triples.
+type ExportOptions struct {
+	// Namespace, if set, restricts the export to triples where the
+	// subject IRI starts with it.
+	Namespace string
+
+	// Predicate, if set, restricts the export to triples with the
+	// given predicate.
+	Predicate rdf.IRI
+
+	// Graphs, if set, restricts the export to triples in the given graphs.
+	// Use DefaultGraph to include the default graph.
+	Graphs []rdf.IRI
+
+	// SkolemIRIs, if set, exports the skolem IRIs replacing blank nodes
+	// as they are stored, instead of as blank nodes.
+	SkolemIRIs bool
+}
+
+// Export writes the stored triples as N-Triples to the given writer, and
+// returns the number of triples written.
+//
+// The SPO index is walked in key order, so the triples are grouped by subject
+// and ordered by term IDs. The order is stable as long as the store is not
+// modified, but two stores holding the same triples will only export them
+// in the same order if the terms were added in the same order.
+func (db *Store) Export(w io.Writer, opts ExportOptions) (n int, err error) {
+	bw := bufio.NewWriter(w)
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		var pID uint32
+		if opts.Predicate != "" {
+			id, err := db.getID(tx, opts.Predicate)
+			if err == ErrNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			pID = id
+		}
+
+		scope, err := db.scope(tx, opts.Graphs)
+		if err != nil {
+			return err
+		}
+
+		it := db.matchIDs(tx, 0, 0, 0)
+		it.scope = scope
+		for s, p, o, err := it.nextIDs(); err != io.EOF; s, p, o, err = it.nextIDs() {
+			if err != nil {
+				return err
+			}
+			if pID != 0 && p != pID {
+				continue
+			}
+			if opts.Namespace != "" && !strings.HasPrefix(string(it.t1.(rdf.IRI)), opts.Namespace) {
+				continue
+			}
+			tr, err := it.triple(s, p, o)
+			if err != nil {
+				return err
+			}
+			line := tr.String()
+			if !opts.SkolemIRIs {
+				line = ntBlankNode(tr.Subject()) + " " + tr.Predicate().String() + " " + ntBlankNode(tr.Object()) + " .\n"
+			}
+			if _, err = bw.WriteString(line); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
@@ -0,0 +1,56 @@
+package malle
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestExport(t *testing.T) {
+	input := `<http://export.org/a> <http://export.org/p1> <http://export.org/b> .
+<http://export.org/a> <http://export.org/p2> "a" .
+<http://export.org/b> <http://export.org/p1> <http://other.org/c> .
+<http://other.org/c> <http://export.org/p2> "c" .
+`
+	if _, err := testDB.Import(bytes.NewBufferString(input), 10, false); err != nil {
+		t.Fatalf("Store.Import(%s) == %v; want no error", input, err)
+	}
+	named := rdf.NewTriple("http://other.org/c", "http://export.org/p1", mustNewIRI("http://export.org/a"))
+	if err := testDB.AddTriple(named, "http://export.org/g"); err != nil {
+		t.Fatalf("Store.AddTriple(%v) == %v; want no error", named, err)
+	}
+
+	var all bytes.Buffer
+	n, err := testDB.Export(&all, ExportOptions{})
+	if err != nil || n != testDB.Stats().NumTriples {
+		t.Fatalf("Store.Export(ExportOptions{}) == %d, %v; want %d, <nil>", n, err, testDB.Stats().NumTriples)
+	}
+	var again bytes.Buffer
+	testDB.Export(&again, ExportOptions{})
+	if all.String() != again.String() {
+		t.Errorf("Store.Export(ExportOptions{}) is not stable:\n%s\n%s", all.String(), again.String())
+	}
+
+	tests := []struct {
+		opts ExportOptions
+		want string
+	}{
+		{ExportOptions{Namespace: "http://export.org/"}, `<http://export.org/a> <http://export.org/p1> <http://export.org/b> .
+<http://export.org/a> <http://export.org/p2> "a" .
+<http://export.org/b> <http://export.org/p1> <http://other.org/c> .`},
+		{ExportOptions{Predicate: "http://export.org/p2"}, `<http://export.org/a> <http://export.org/p2> "a" .
+<http://other.org/c> <http://export.org/p2> "c" .`},
+		{ExportOptions{Namespace: "http://other.org/", Predicate: "http://export.org/p1"}, `<http://other.org/c> <http://export.org/p1> <http://export.org/a> .`},
+		{ExportOptions{Graphs: []rdf.IRI{"http://export.org/g"}}, `<http://other.org/c> <http://export.org/p1> <http://export.org/a> .`},
+		{ExportOptions{Predicate: "http://export.org/nopred"}, ``},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		n, err := testDB.Export(&b, test.opts)
+		want := rdf.Load(bytes.NewBufferString(test.want))
+		if got := rdf.Load(&b); err != nil || n != want.Size() || !got.Eq(want) {
+			t.Errorf("Store.Export(%+v) == %d, %v:\n%v\nwant:\n%v", test.opts, n, err, got, want)
+		}
+	}
+}
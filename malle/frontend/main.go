@@ -1,17 +1,26 @@
 package main
 
 import (
+	"crypto/subtle"
 	"flag"
 	"fmt"
 	"html/template"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 
 	"github.com/boutros/x/malle"
 	"github.com/boutros/x/malle/rdf"
+	"github.com/boutros/x/malle/shacl"
+	"github.com/boutros/x/malle/sparql"
 )
 
 const htmlIndex = `<!DOCTYPE html>
@@ -23,6 +32,9 @@ const htmlIndex = `<!DOCTYPE html>
 	<style type="text/css">
 		body { font-family: sans serif; margin: 40px auto; max-width: 1140px; line-height: 1.6; font-size: 18px; color: #222; padding: 0 10px }
 		h1, h2, h3 { line-height: 1.2;}
+		a { text-decoration: none }
+		td { padding-right: 2em; vertical-align: top; }
+		.right { text-align: right; }
 	</style>
 </head>
 <body>
@@ -34,10 +46,37 @@ const htmlIndex = `<!DOCTYPE html>
 		<tr><td><b>Number of triples</b></td><td>{{.NumTriples}}</td></tr>
 		<tr><td><b>Number of different IRI namespaces</b></td><td>{{.NumNamespaces}}</td></tr>
 	</table>
+	<form action="/search">
+		<p>Search for resources to start browsing:</p>
+		<input type="search" name="q"/> <button>Search</button>
+	</form>
 	<form action="/describe">
-		<p>Enter the IRI of a RDF resource to start browsing:</p>
+		<p>Or enter the IRI of a RDF resource:</p>
 		<input type="search" name="IRI"/> <button>Explore</button>
 	</form>
+	<form action="/sparql">
+		<p>Or query the triple store using SPARQL:</p>
+		<textarea name="query" rows="8" cols="80">SELECT * WHERE { ?s ?p ?o } LIMIT 10</textarea><br/>
+		<button>Query</button>
+	</form>
+	{{if .Predicates}}
+	<h3>Predicates</h3>
+	<table>
+		<tr><th>Predicate</th><th class="right">Triples</th><th class="right">Subjects</th><th class="right">Objects</th></tr>
+		{{range .Predicates}}
+		<tr><td><a href="/describe?IRI={{.Predicate.Value}}">{{.Predicate}}</a></td><td class="right">{{.Triples}}</td><td class="right">{{.Subjects}}</td><td class="right">{{.Objects}}</td></tr>
+		{{end}}
+	</table>
+	{{end}}
+	{{if .Classes}}
+	<h3>Classes</h3>
+	<table>
+		<tr><th>Class</th><th class="right">Instances</th></tr>
+		{{range .Classes}}
+		<tr><td><a href="/describe?IRI={{.Class.Value}}">{{.Class}}</a></td><td class="right">{{.Instances}}</td></tr>
+		{{end}}
+	</table>
+	{{end}}
 </body>
 </html>`
 
@@ -72,19 +111,21 @@ const htmlResource = `<!DOCTYPE html>
 		.show-all:hover { cursor: pointer; }
 	</style>
 </head>
-<body>
+<body data-subj="{{.Subj.Value}}" data-next="{{.Next}}">
 	<div class="container">
 		<h2>{{.Props | chooseTitle}}</h2>
 		<h3>{{.Subj | html}} ⟹</h3>
 		<div>
 			{{range $pred, $terms := .Props}}
-				<div class="props border clearfix">
-					<div class="narrow float-left" title="{{$pred | html}}"><b>{{$pred | shortPred}}</b>{{if gt (len $terms) 1 }} <span class="grey">({{len $terms}})</span>{{end}}</div>
-					<ul class="wide float-right">
+				<div class="props border clearfix" data-dir="out" data-pred="{{$pred.Value}}">
+					<div class="narrow float-left" title="{{$pred | html}}"><b>{{$pred | shortPred}}</b>{{if gt (len $terms) 1 }} <span class="grey">({{len $terms}}{{if $.Next}}+{{end}})</span>{{end}}</div>
+					<ul class="wide float-right items">
 					{{range $i, $obj := $terms}}
-						<li class="{{if not (isLink $obj)}}{{if (gt $i 20)}}hidden {{end}}literal{{else}}{{if (gt $i 10)}}hidden {{end}}resource{{end}}">{{$obj | linkify}}</li>
+						<li class="item {{if not (isLink $obj)}}{{if (gt $i 20)}}hidden {{end}}literal{{else}}{{if (gt $i 10)}}hidden {{end}}resource{{end}}">{{$obj | linkify}}</li>
 					{{end}}
-					{{if (isLink (index $terms 0))}}
+					{{if $.Next}}
+						<div class="wide float-left"><a class="show-all">show all...</a></div>
+					{{else if (isLink (index $terms 0))}}
 						{{if (gt (len $terms) 10)}}<div class="wide float-left"><a class="show-all">show all {{len $terms}}...</a></div>{{end}}
 					{{else}}
 						{{if (gt (len $terms) 20)}}<div class="wide float-left"><a class="show-all">show all {{len $terms}}...</a></div>{{end}}
@@ -97,32 +138,105 @@ const htmlResource = `<!DOCTYPE html>
 		<h3 class="right">⟹ {{.Subj | html}}</h3>
 		<div>
 			{{range $pred, $subjs := .Incoming}}
-			<div class="props border clearfix">
-				<div class="narrow float-right"><b>{{$pred | shortPred}}</b>{{if gt (len $subjs) 1 }} <span class="grey">({{len $subjs}})</span>{{end}}</div>
+			<div class="props border clearfix items" data-dir="in" data-pred="{{$pred.Value}}">
+				<div class="narrow float-right"><b>{{$pred | shortPred}}</b>{{if gt (len $subjs) 1 }} <span class="grey">({{len $subjs}}{{if $.Next}}+{{end}})</span>{{end}}</div>
 				{{range $i, $s := $subjs}}
-					<div class="{{if (gt $i 10)}}hidden {{end}}wide float-left">{{$s | linkify}}</div>
+					<div class="item {{if (gt $i 10)}}hidden {{end}}wide float-left">{{$s | linkify}}</div>
+				{{end}}
+				{{if $.Next}}
+					<div class="wide float-left"><a class="show-all">show all...</a></div>
+				{{else if (gt (len $subjs) 10)}}
+					<div class="wide float-left"><a class="show-all">show all {{len $subjs}}...</a></div>
 				{{end}}
-				{{if (gt (len $subjs) 10)}}<div class="wide float-left"><a class="show-all">show all {{len $subjs}}...</a></div>{{end}}
 			</div>
 			{{end}}
 		</div>
 	</div>
 	<div class="clearfix"></div>
+	{{if .Next}}<p><a href="/describe?IRI={{.Subj.Value}}&continue={{.Next}}">more triples...</a></p>{{end}}
 	<script>
+		var subj = document.body.getAttribute("data-subj");
+
+		// fetchPages appends the items of the section from the following
+		// pages of the description, starting with the page of the token.
+		function fetchPages(section, token) {
+			var url = "/describe?IRI=" + encodeURIComponent(subj) + "&continue=" + encodeURIComponent(token);
+			fetch(url).then(function(res) {
+				return res.text();
+			}).then(function(html) {
+				var page = new DOMParser().parseFromString(html, "text/html");
+				var sections = page.querySelectorAll("[data-dir]");
+				[].forEach.call(sections, function(s) {
+					if (s.getAttribute("data-dir") != section.getAttribute("data-dir") ||
+						s.getAttribute("data-pred") != section.getAttribute("data-pred")) {
+						return;
+					}
+					var items = section.getElementsByClassName("items")[0] || section;
+					[].forEach.call(s.getElementsByClassName("item"), function(item) {
+						item.classList.remove("hidden");
+						items.appendChild(document.importNode(item, true));
+					});
+				});
+				var next = page.body.getAttribute("data-next");
+				if (next) {
+					fetchPages(section, next);
+				}
+			});
+		}
+
 		var els = document.getElementsByClassName("show-all");
 		[].forEach.call(els, function(el) {
 			el.addEventListener("click", function(e) {
-				var hidden = e.target.parentNode.parentNode.getElementsByClassName("hidden");
+				var section = e.target.parentNode.parentNode;
+				if (!section.hasAttribute("data-dir")) {
+					section = section.parentNode;
+				}
+				var hidden = section.getElementsByClassName("hidden");
 				[].slice.call(hidden).forEach(function(h) {
 					h.classList.remove("hidden");
 				});
 				el.parentNode.removeChild(el);
+				var next = document.body.getAttribute("data-next");
+				if (next) {
+					fetchPages(section, next);
+				}
 			});
 		});
 	</script>
 </body>
 </html>`
 
+const htmlSearch = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Search: {{.Query}}</title>
+	<style type="text/css">
+		body { font-family: sans serif; margin: 40px auto; max-width: 1140px; line-height: 1.6; font-size: 18px; color: #222; padding: 0 10px }
+		h1, h2, h3 { line-height: 1.2;}
+		a { text-decoration: none }
+		ul { list-style: none; padding: 0; margin: 0; }
+		li { padding: 0.15em; }
+	</style>
+</head>
+<body>
+	<form action="/search">
+		<input type="search" name="q" value="{{.Query}}"/> <button>Search</button>
+	</form>
+	<h3>{{len .Results}} resources found</h3>
+	<ul>
+	{{range .Results}}
+		<li><a href="/describe?IRI={{.Value}}">{{.}}</a></li>
+	{{end}}
+	</ul>
+</body>
+</html>`
+
+// maxIndexStats is the maximum number of predicates and classes listed on
+// the index page.
+const maxIndexStats = 100
+
 var titlePreferences = map[rdf.Term]rdf.IRI{
 	mustNewIRI("http://lexvo.org/ontology#Language"):                mustNewIRI("http://www.w3.org/2008/05/skos#prefLabel"),
 	mustNewIRI("http://www.w3.org/2004/02/skos/core#Concept"):       mustNewIRI("http://www.w3.org/2004/02/skos/core#prefLabel"),
@@ -140,6 +254,15 @@ func mustNewIRI(iri string) rdf.IRI {
 	return i
 }
 
+// shortIRI returns the IRI as a CURIE if it has a namespace with a prefix,
+// or else the part of it after the last '/' or '#'.
+func shortIRI(prefixes rdf.Prefixes, s string) string {
+	if curie, ok := prefixes.Compact(rdf.IRI(s)); ok {
+		return curie
+	}
+	return shorten(s)
+}
+
 func shorten(s string) string {
 	i := len(s)
 	for r, w := utf8.DecodeLastRuneInString(s[:i]); i > 0; r, w = utf8.DecodeLastRuneInString(s[:i]) {
@@ -150,11 +273,118 @@ func shorten(s string) string {
 	}
 	return s
 }
+
+// negotiate chooses the best of the offered media types given the Accept header,
+// or returns an empty string if none is acceptable. The first offer is
+// chosen when the client accepts anything, or there is no Accept header.
+func negotiate(accept string, offers []string) string {
+	if accept == "" {
+		return offers[0]
+	}
+	type accepted struct {
+		mediaType string
+		q         float64
+	}
+	var accepts []accepted
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		accepts = append(accepts, accepted{mediaType, q})
+	}
+	sort.SliceStable(accepts, func(i, j int) bool { return accepts[i].q > accepts[j].q })
+	for _, a := range accepts {
+		if a.q == 0 {
+			break
+		}
+		for _, offer := range offers {
+			switch {
+			case a.mediaType == offer, a.mediaType == "*/*",
+				strings.HasSuffix(a.mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(a.mediaType, "*")):
+				return offer
+			}
+		}
+		// text/plain is an alias for N-Triples
+		if a.mediaType == "text/plain" {
+			for _, offer := range offers {
+				if offer == sparql.MediaTypeNTriples {
+					return a.mediaType
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// sparqlQuery extracts the query string of a SPARQL 1.1 Protocol request.
+func sparqlQuery(req *http.Request) (string, error) {
+	switch req.Method {
+	case "GET":
+		return req.URL.Query().Get("query"), nil
+	case "POST":
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/x-www-form-urlencoded":
+			return req.PostFormValue("query"), nil
+		case "application/sparql-query":
+			b, err := ioutil.ReadAll(req.Body)
+			return string(b), err
+		}
+	}
+	return "", nil
+}
+
+// authorized checks the basic auth credentials of the request against the
+// given user:password. No request is authorized if credentials is empty.
+func authorized(req *http.Request, credentials string) bool {
+	if credentials == "" {
+		return false
+	}
+	user, pass, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	given := []byte(user + ":" + pass)
+	return subtle.ConstantTimeCompare(given, []byte(credentials)) == 1
+}
+
+// checkDB checks, and optionally repairs, the integrity of the database,
+// logging any problems found. It returns the exit status: 1 if any problems
+// are left unrepaired.
+func checkDB(db *malle.Store, repair bool) int {
+	check := db.Check
+	if repair {
+		check = db.Repair
+	}
+	problems, err := check()
+	if err != nil {
+		log.Printf("Integrity check failed: %v", err)
+		return 1
+	}
+	status := 0
+	for _, p := range problems {
+		log.Print(p)
+		if !p.Repaired {
+			status = 1
+		}
+	}
+	log.Printf("Integrity check done: %d problems found", len(problems))
+	return status
+}
+
 func main() {
+	var db *malle.Store
 	funcMap := template.FuncMap{
 		"shortPred": func(t rdf.Term) string {
 			s := t.Value().(string)
-			return shorten(s)
+			return shortIRI(db.Prefixes(), s)
 		},
 		"isLink": func(term rdf.Term) bool {
 			_, ok := term.(rdf.IRI)
@@ -179,7 +409,7 @@ func main() {
 					literal := fmt.Sprintf("%v <span class=\"grey\" title=\"%s\">(%v)</span>",
 						t.Value(),
 						template.HTMLEscapeString(t.DataType().Value().(string)),
-						shorten(t.DataType().Value().(string)))
+						shortIRI(db.Prefixes(), t.DataType().Value().(string)))
 					return template.HTML(literal)
 				}
 			}
@@ -201,18 +431,63 @@ func main() {
 		// templates:
 		tplIndex    = template.Must(template.New("index").Parse(htmlIndex))
 		tplResource = template.Must(template.New("index").Funcs(funcMap).Parse(htmlResource))
+		tplSearch   = template.Must(template.New("search").Parse(htmlSearch))
 		// command line flags:
-		dbFile     = flag.String("db", "", "database file")
-		port       = flag.Int("p", 8080, "port to serve from")
-		importFile = flag.String("import", "", "import triples from file (n-triples)")
+		dbFile      = flag.String("db", "", "database file")
+		port        = flag.Int("p", 8080, "port to serve from")
+		importFile  = flag.String("import", "", "import triples from file (n-triples)")
+		restoreFile = flag.String("restore", "", "restore database from backup file before serving")
+		admin       = flag.String("admin", "", "credentials (user:password) required to download backups; disabled if empty")
+		leader      = flag.String("follow", "", "run as read-only replica of the frontend at the given URL; start from a backup of the leader with -restore")
+		poll        = flag.Duration("poll", 5*time.Second, "how often to poll the leader for changes when following")
+		check       = flag.Bool("check", false, "check the integrity of the database and exit")
+		repair      = flag.Bool("repair", false, "check the integrity of the database, repair any problems found and exit")
+		prefixes    = flag.String("prefixes", "", "set prefixes of namespaces, used to shorten IRIs, as a comma-separated list of prefix=namespace")
+		compact     = flag.Bool("compact", false, "renumber the terms of the database densely, reclaiming the IDs of removed terms, and exit")
+		rdfs        = flag.Bool("rdfs", false, "infer RDFS entailments (subclass, subproperty, domain and range) into the graph <"+string(malle.InferredGraph)+">; kept on in the database once turned on")
+		shapesFile  = flag.String("shapes", "", "reject imported triples not conforming to the SHACL shapes in the given file (n-triples)")
+		sameAs      = flag.Bool("sameas", false, "merge resources linked by owl:sameAs, describing them under a canonical IRI; kept on in the database once turned on")
 	)
 	flag.Parse()
 	if *dbFile == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *leader != "" && *importFile != "" {
+		log.Fatal("Cannot import triples into a replica")
+	}
+	if *leader != "" && *rdfs {
+		log.Fatal("A replica gets the entailments of its leader; cannot infer them")
+	}
+	if *leader != "" && *sameAs {
+		log.Fatal("A replica gets the owl:sameAs mode of its leader; cannot turn it on")
+	}
+
+	if *restoreFile != "" {
+		log.Printf("Restoring %s from backup file: %s", *dbFile, *restoreFile)
+		f, err := os.Open(*restoreFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = malle.Restore(f, *dbFile)
+		f.Close()
+		if berr, ok := err.(*malle.BackupError); ok {
+			log.Fatalf("Not restoring %s, since %s is not a valid backup: %v", *dbFile, *restoreFile, berr.Cause)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	if *importFile == "" {
+	if *compact {
+		log.Printf("Compacting %s", *dbFile)
+		if err := malle.Compact(*dbFile); err != nil {
+			log.Fatal(err)
+		}
+		log.Print("Compaction done")
+		os.Exit(0)
+	}
+
+	if *importFile == "" && *leader == "" {
 		_, err := os.Stat(*dbFile)
 		if err != nil {
 			log.Fatal(err)
@@ -227,6 +502,55 @@ func main() {
 	log.Print("Triple store OK")
 	defer db.Close()
 
+	if *check || *repair {
+		status := checkDB(db, *repair)
+		db.Close()
+		os.Exit(status)
+	}
+
+	if *rdfs && !db.Entailment() {
+		log.Print("Inferring RDFS entailments")
+		if err := db.SetEntailment(true); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *prefixes != "" {
+		for _, pair := range strings.Split(*prefixes, ",") {
+			i := strings.IndexByte(pair, '=')
+			if i < 0 {
+				log.Fatalf("Invalid prefix, want prefix=namespace: %s", pair)
+			}
+			if err := db.SetPrefix(pair[:i], rdf.IRI(pair[i+1:])); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if *sameAs && !db.SameAs() {
+		log.Print("Linking owl:sameAs clusters")
+		if err := db.SetSameAs(true); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *shapesFile != "" {
+		log.Printf("Loading SHACL shapes from file: %v", *shapesFile)
+		f, err := os.Open(*shapesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dec := rdf.NewNTDecoder(f)
+		dec.BNodeAsIRI = true
+		dec.BNodeNS = "urn:x-bnode:"
+		shapes, err := shacl.Parse(dec.DecodeAll())
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		db.SetValidator(shapes.Reject)
+	}
+
 	if *importFile != "" {
 		log.Printf("Importing triples from file: %v", *importFile)
 		go func() {
@@ -246,10 +570,35 @@ func main() {
 		}()
 	}
 
+	if *leader != "" {
+		log.Printf("Following changes from leader: %s", *leader)
+		go follow(db, *leader, *poll)
+	}
+
 	log.Printf("DB: %+v", db.Stats())
 	log.Printf("Serving from port %d", *port)
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-		tplIndex.Execute(w, db.Stats())
+		preds, err := db.PredicateStats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		classes, err := db.ClassStats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(preds) > maxIndexStats {
+			preds = preds[:maxIndexStats]
+		}
+		if len(classes) > maxIndexStats {
+			classes = classes[:maxIndexStats]
+		}
+		tplIndex.Execute(w, struct {
+			malle.Stats
+			Predicates []malle.PredicateStats
+			Classes    []malle.ClassStats
+		}{db.Stats(), preds, classes})
 	})
 	http.HandleFunc("/describe", func(w http.ResponseWriter, req *http.Request) {
 		q := req.URL.Query()["IRI"][0] // TODO check if IRI param present
@@ -258,8 +607,31 @@ func main() {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		graph, err := db.Query(malle.NewQuery().CBD(iri, 0))
-		if err != nil {
+		if full, ok := db.Prefixes().Expand(q); ok {
+			http.Redirect(w, req, "/describe?IRI="+url.QueryEscape(string(full)), http.StatusSeeOther)
+			return
+		}
+		query := malle.NewQuery().CBD(iri, 0)
+		if db.SameAs() {
+			canonical, err := db.Canonical(iri)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if canonical != iri {
+				http.Redirect(w, req, "/describe?IRI="+url.QueryEscape(string(canonical)), http.StatusSeeOther)
+				return
+			}
+			query = query.SameAs()
+		}
+		if token := req.URL.Query().Get("continue"); token != "" {
+			query = query.Continue(token)
+		}
+		graph, next, err := db.Query(query)
+		if err == malle.ErrInvalidToken {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -283,8 +655,77 @@ func main() {
 			Subj     rdf.IRI
 			Props    map[rdf.IRI]rdf.Terms
 			Incoming map[rdf.IRI]rdf.Terms
-		}{iri, graph[iri], incoming})
+			Next     string // continuation token of the next page, if any
+		}{iri, graph[iri], incoming, next})
+	})
+	http.HandleFunc("/search", func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query().Get("q")
+		var opts malle.SearchOptions
+		if lang := req.URL.Query().Get("lang"); lang != "" {
+			opts.Langs = []string{lang}
+		}
+		res, err := db.Search(q, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tplSearch.Execute(w, struct {
+			Query   string
+			Results []rdf.IRI
+		}{q, res})
+	})
+	http.HandleFunc("/sparql", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "POST" {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query, err := sparqlQuery(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if query == "" {
+			http.Error(w, "Missing query", http.StatusBadRequest)
+			return
+		}
+		q, err := sparql.Parse(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := q.Exec(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		mediaType := negotiate(req.Header.Get("Accept"), res.MediaTypes())
+		if mediaType == "" {
+			http.Error(w, "Not acceptable", http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+		if mediaType == "text/plain" {
+			mediaType = sparql.MediaTypeNTriples
+		}
+		if err := res.Encode(w, mediaType); err != nil {
+			log.Printf("Failed to encode SPARQL result: %v", err)
+		}
+	})
+	http.HandleFunc("/backup", func(w http.ResponseWriter, req *http.Request) {
+		if !authorized(req, *admin) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="malle"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=\"malle-%s.db\"", time.Now().Format("20060102-150405")))
+		if _, err := db.Backup(w); err != nil {
+			log.Printf("Backup failed: %v", err)
+		}
 	})
+	http.HandleFunc("/changes", changesHandler(db))
 	err = http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// jsonTerm is a RDF term, encoded as in the SPARQL 1.1 Query Results JSON Format.
+type jsonTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Lang     string `json:"xml:lang,omitempty"`
+	Datatype string `json:"datatype,omitempty"`
+}
+
+// jsonChange is a change log entry as served by the /changes endpoint.
+type jsonChange struct {
+	Seq   uint64   `json:"seq"`
+	Op    string   `json:"op"`
+	Subj  jsonTerm `json:"s"`
+	Pred  jsonTerm `json:"p"`
+	Obj   jsonTerm `json:"o"`
+	Graph string   `json:"g,omitempty"`
+}
+
+func toJSONTerm(t rdf.Term) jsonTerm {
+	switch t := t.(type) {
+	case rdf.IRI:
+		return jsonTerm{Type: "uri", Value: string(t)}
+	case rdf.Literal:
+		jt := jsonTerm{Type: "literal", Value: fmt.Sprint(t.Value()), Lang: t.Lang()}
+		if t.Lang() == "" {
+			jt.Datatype = string(t.DataType())
+		}
+		return jt
+	}
+	panic("toJSONTerm: unreachable")
+}
+
+func fromJSONTerm(jt jsonTerm) (rdf.Term, error) {
+	switch jt.Type {
+	case "uri":
+		return rdf.NewIRI(jt.Value)
+	case "literal":
+		if jt.Lang != "" {
+			return rdf.NewLangLiteral(jt.Value, jt.Lang)
+		}
+		if jt.Datatype == "" {
+			return rdf.NewLiteral(jt.Value)
+		}
+		dt, err := rdf.NewIRI(jt.Datatype)
+		if err != nil {
+			return nil, err
+		}
+		return rdf.NewTypedLiteral(jt.Value, dt)
+	}
+	return nil, fmt.Errorf("unknown term type: %q", jt.Type)
+}
+
+func toJSONChange(c malle.Change) jsonChange {
+	return jsonChange{
+		Seq:   c.Seq,
+		Op:    c.Op.String(),
+		Subj:  toJSONTerm(c.Triple.Subject()),
+		Pred:  toJSONTerm(c.Triple.Predicate()),
+		Obj:   toJSONTerm(c.Triple.Object()),
+		Graph: string(c.Graph),
+	}
+}
+
+func fromJSONChange(jc jsonChange) (c malle.Change, err error) {
+	c.Seq = jc.Seq
+	switch jc.Op {
+	case malle.ChangeAdd.String():
+		c.Op = malle.ChangeAdd
+	case malle.ChangeRemove.String():
+		c.Op = malle.ChangeRemove
+	default:
+		return c, fmt.Errorf("unknown change operation: %q", jc.Op)
+	}
+	var terms [3]rdf.Term
+	for i, jt := range []jsonTerm{jc.Subj, jc.Pred, jc.Obj} {
+		if terms[i], err = fromJSONTerm(jt); err != nil {
+			return c, err
+		}
+	}
+	s, ok := terms[0].(rdf.IRI)
+	if !ok {
+		return c, errors.New("subject must be an IRI")
+	}
+	p, ok := terms[1].(rdf.IRI)
+	if !ok {
+		return c, errors.New("predicate must be an IRI")
+	}
+	c.Triple = rdf.NewTriple(s, p, terms[2])
+	if jc.Graph != "" {
+		if c.Graph, err = rdf.NewIRI(jc.Graph); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// changesHandler serves the change log of the store as a JSON array, starting
+// after the sequence number given by the since parameter.
+func changesHandler(db *malle.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var since uint64
+		if s := req.URL.Query().Get("since"); s != "" {
+			var err error
+			if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+				http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		changes, err := db.Changes(since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res := make([]jsonChange, len(changes))
+		for i, c := range changes {
+			res[i] = toJSONChange(c)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("Failed to encode changes: %v", err)
+		}
+	}
+}
+
+// pullChanges fetches the changes after the given sequence number from the
+// /changes endpoint of the leader.
+func pullChanges(leader string, since uint64) ([]malle.Change, error) {
+	u := strings.TrimSuffix(leader, "/") + "/changes?" +
+		url.Values{"since": {strconv.FormatUint(since, 10)}}.Encode()
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	var res []jsonChange
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	changes := make([]malle.Change, len(res))
+	for i, jc := range res {
+		if changes[i], err = fromJSONChange(jc); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// follow keeps the store up to date with the leader, by pulling and applying
+// its changes in order. Full batches are followed by another pull right away;
+// otherwise the follower waits for the poll interval. It never returns.
+func follow(db *malle.Store, leader string, poll time.Duration) {
+	for {
+		last, err := db.Replicated()
+		if err == nil {
+			var changes []malle.Change
+			changes, err = pullChanges(leader, last)
+			if err == nil {
+				err = db.ApplyChanges(changes)
+			}
+			if err == nil && len(changes) > 0 {
+				log.Printf("Replicated changes %d-%d from %s", changes[0].Seq, changes[len(changes)-1].Seq, leader)
+			}
+			if err == nil && len(changes) == malle.MaxResults {
+				continue
+			}
+		}
+		if err != nil {
+			log.Printf("Replication from %s failed: %v", leader, err)
+		}
+		time.Sleep(poll)
+	}
+}
@@ -0,0 +1,386 @@
+package malle
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// Named graphs
+//
+// The triple indices (SPO, OSP, POS) hold the union of all graphs. The GSPO
+// index holds the triples of each named graph, and the SPOG index maps a
+// triple to the named graphs it belongs to. Triples without an entry in the
+// SPOG index belong to the default graph only, which is the case for any
+// triple stored without a graph. If a triple belongs to both the default
+// graph and a named graph, the default graph is recorded with ID 0 in its
+// SPOG bitmap.
+
+// DefaultGraph identifies the default (unnamed) graph.
+const DefaultGraph rdf.IRI = ""
+
+// ListGraphs returns the names of all named graphs in the store.
+func (db *Store) ListGraphs() (graphs []rdf.IRI, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(bGSPO).Cursor()
+		for k, _ := cur.First(); k != nil; {
+			id := btou32(k[:4])
+			t, err := db.getTerm(tx, id)
+			if err != nil {
+				return err
+			}
+			graphs = append(graphs, t.(rdf.IRI))
+			if id == MaxTerms {
+				break
+			}
+			k, _ = cur.Seek(u32tob(id + 1))
+		}
+		return nil
+	})
+	return graphs, err
+}
+
+// ClearGraph removes all triples from the given graph.
+func (db *Store) ClearGraph(graph rdf.IRI) error {
+	return db.update(func(tx *bolt.Tx) error {
+		gIDs, err := db.graphIDs(tx, []rdf.IRI{graph}, false)
+		if err != nil || len(gIDs) == 0 {
+			return err
+		}
+		return db.clearGraph(tx, gIDs[0])
+	})
+}
+
+// ReplaceGraph replaces the contents of the given graph with the triples
+// of g, in a single transaction.
+func (db *Store) ReplaceGraph(graph rdf.IRI, g rdf.Graph) error {
+	return db.update(func(tx *bolt.Tx) error {
+		gIDs, err := db.graphIDs(tx, []rdf.IRI{graph}, false)
+		if err != nil {
+			return err
+		}
+		if len(gIDs) > 0 {
+			if err := db.clearGraph(tx, gIDs[0]); err != nil {
+				return err
+			}
+		}
+		// The graph name is removed along with its last triple,
+		// so it must be stored again.
+		if gIDs, err = db.graphIDs(tx, []rdf.IRI{graph}, true); err != nil {
+			return err
+		}
+		return db.importGraph(tx, g, gIDs)
+	})
+}
+
+// graphScope is the set of graph IDs a query is restricted to, where
+// the default graph has ID 0. A nil graphScope includes all graphs.
+type graphScope map[uint32]bool
+
+// scope returns the graphScope of the given graphs. Graphs which are
+// not stored are ignored. If no graphs are given, the scope is nil.
+func (db *Store) scope(tx *bolt.Tx, graphs []rdf.IRI) (graphScope, error) {
+	if len(graphs) == 0 {
+		return nil, nil
+	}
+	gIDs, err := db.graphIDs(tx, graphs, false)
+	if err != nil {
+		return nil, err
+	}
+	scope := make(graphScope, len(gIDs))
+	for _, id := range gIDs {
+		scope[id] = true
+	}
+	return scope, nil
+}
+
+// inScope checks if the stored triple of the given term IDs belongs to
+// any of the graphs in scope.
+func (db *Store) inScope(tx *bolt.Tx, scope graphScope, s, p, o uint32) (bool, error) {
+	if scope == nil {
+		return true, nil
+	}
+	bo := tx.Bucket(bSPOG).Get(tripleKey(s, p, o))
+	if bo == nil {
+		return scope[0], nil
+	}
+	bitmap := roaring.NewRoaringBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+		return false, err
+	}
+	it := bitmap.Iterator()
+	for it.HasNext() {
+		if scope[it.Next()] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// graphIDs returns the term IDs of the given graphs, or the ID of the
+// default graph (0) if none are given. If create is set, the names of new
+// graphs are stored, otherwise graphs which are not stored are left out.
+func (db *Store) graphIDs(tx *bolt.Tx, graphs []rdf.IRI, create bool) ([]uint32, error) {
+	if len(graphs) == 0 {
+		return []uint32{0}, nil
+	}
+	ids := make([]uint32, 0, len(graphs))
+	for _, g := range graphs {
+		if g == DefaultGraph {
+			ids = append(ids, 0)
+			continue
+		}
+		var id uint32
+		var err error
+		if create {
+			id, err = db.addTerm(tx, g)
+		} else {
+			id, err = db.getID(tx, g)
+		}
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// importGraph stores the triples of g in each of the graphs with the given IDs.
+func (db *Store) importGraph(tx *bolt.Tx, g rdf.Graph, gIDs []uint32) error {
+	for subj, props := range g {
+
+		sID, err := db.addTerm(tx, subj)
+		if err != nil {
+			return err
+		}
+
+		for pred, terms := range props {
+			pID, err := db.addTerm(tx, pred)
+			if err != nil {
+				return err
+			}
+
+			for _, obj := range terms {
+				// TODO batch bitmap operations for all obj in terms
+				oID, err := db.addTerm(tx, obj)
+				if err != nil {
+					return err
+				}
+
+				for _, gID := range gIDs {
+					err = db.storeInGraph(tx, sID, pID, oID, gID)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// clearGraph removes all triples from the graph with the given ID.
+func (db *Store) clearGraph(tx *bolt.Tx, g uint32) error {
+	// Collect the triples first, since the indices cannot be modified
+	// while iterating over them.
+	var triples [][3]uint32
+	if g == 0 {
+		it := db.matchIDs(tx, 0, 0, 0)
+		it.scope = graphScope{0: true}
+		for s, p, o, err := it.nextIDs(); err != io.EOF; s, p, o, err = it.nextIDs() {
+			if err != nil {
+				return err
+			}
+			triples = append(triples, [3]uint32{s, p, o})
+		}
+	} else {
+		prefix := u32tob(g)
+		cur := tx.Bucket(bGSPO).Cursor()
+		for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			bitmap := roaring.NewRoaringBitmap()
+			if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+				return err
+			}
+			s, p := btou32(k[4:8]), btou32(k[8:])
+			it := bitmap.Iterator()
+			for it.HasNext() {
+				triples = append(triples, [3]uint32{s, p, it.Next()})
+			}
+		}
+	}
+
+	for _, tr := range triples {
+		if err := db.removeFromGraph(tx, tr[0], tr[1], tr[2], g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeInGraph stores a triple in the graph with the given ID, where 0 is
+// the default graph.
+func (db *Store) storeInGraph(tx *bolt.Tx, s, p, o, g uint32) error {
+	added, err := db.storeTriple(tx, s, p, o)
+	if err != nil {
+		return err
+	}
+
+	bkt := tx.Bucket(bSPOG)
+	key := tripleKey(s, p, o)
+	bo := bkt.Get(key)
+	if g == 0 && (added || bo == nil) {
+		// New triple, or allready in the default graph only.
+		if added {
+			return db.logChange(tx, ChangeAdd, s, p, o, 0)
+		}
+		return nil
+	}
+
+	graphs := roaring.NewRoaringBitmap()
+	if bo != nil {
+		if _, err = graphs.ReadFrom(bytes.NewReader(bo)); err != nil {
+			return err
+		}
+	} else if !added {
+		// The triple was stored in the default graph only.
+		graphs.Add(0)
+	}
+	if !graphs.CheckedAdd(g) {
+		return nil
+	}
+	var b bytes.Buffer
+	if _, err = graphs.WriteTo(&b); err != nil {
+		return err
+	}
+	if err = bkt.Put(key, b.Bytes()); err != nil {
+		return err
+	}
+	if err = db.logChange(tx, ChangeAdd, s, p, o, g); err != nil {
+		return err
+	}
+
+	if g == 0 {
+		return nil
+	}
+	bkt = tx.Bucket(bGSPO)
+	key = tripleKey(g, s, p)
+	objects := roaring.NewRoaringBitmap()
+	if bo = bkt.Get(key); bo != nil {
+		if _, err = objects.ReadFrom(bytes.NewReader(bo)); err != nil {
+			return err
+		}
+	}
+	objects.Add(o)
+	var ob bytes.Buffer
+	if _, err = objects.WriteTo(&ob); err != nil {
+		return err
+	}
+	return bkt.Put(key, ob.Bytes())
+}
+
+// removeFromGraph removes a triple from the graph with the given ID, where 0
+// is the default graph. The triple is removed from the triple indices when it
+// no longer belongs to any graph. It returns ErrNotFound if the triple is not
+// in the graph.
+func (db *Store) removeFromGraph(tx *bolt.Tx, s, p, o, g uint32) error {
+	bkt := tx.Bucket(bSPOG)
+	key := tripleKey(s, p, o)
+	bo := bkt.Get(key)
+	if bo == nil {
+		if g != 0 {
+			return ErrNotFound
+		}
+		ok, err := db.hasIDs(tx, s, p, o)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotFound
+		}
+		// Logged first, since the terms may be removed along with the triple.
+		if err := db.logChange(tx, ChangeRemove, s, p, o, 0); err != nil {
+			return err
+		}
+		return db.removeTriple(tx, s, p, o)
+	}
+
+	graphs := roaring.NewRoaringBitmap()
+	if _, err := graphs.ReadFrom(bytes.NewReader(bo)); err != nil {
+		return err
+	}
+	if !graphs.CheckedRemove(g) {
+		return ErrNotFound
+	}
+	if err := db.logChange(tx, ChangeRemove, s, p, o, g); err != nil {
+		return err
+	}
+
+	if g != 0 {
+		gkey := tripleKey(g, s, p)
+		gbkt := tx.Bucket(bGSPO)
+		objects := roaring.NewRoaringBitmap()
+		if bo := gbkt.Get(gkey); bo != nil {
+			if _, err := objects.ReadFrom(bytes.NewReader(bo)); err != nil {
+				return err
+			}
+		}
+		objects.CheckedRemove(o)
+		if objects.GetCardinality() == 0 {
+			if err := gbkt.Delete(gkey); err != nil {
+				return err
+			}
+		} else {
+			var b bytes.Buffer
+			if _, err := objects.WriteTo(&b); err != nil {
+				return err
+			}
+			if err := gbkt.Put(gkey, b.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case graphs.GetCardinality() == 0:
+		if err := bkt.Delete(key); err != nil {
+			return err
+		}
+		if err := db.removeTriple(tx, s, p, o); err != nil {
+			return err
+		}
+	case graphs.GetCardinality() == 1 && graphs.Contains(0):
+		// Left in the default graph only.
+		if err := bkt.Delete(key); err != nil {
+			return err
+		}
+	default:
+		var b bytes.Buffer
+		if _, err := graphs.WriteTo(&b); err != nil {
+			return err
+		}
+		if err := bkt.Put(key, b.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if g != 0 && g != s && g != p && g != o {
+		// The graph name may now be orphaned. If it is part of
+		// the triple, it is allready taken care of by removeTriple.
+		return db.removeOrphanedTerms(tx, g)
+	}
+	return nil
+}
+
+// tripleKey returns the 12-byte index key of the three given IDs.
+func tripleKey(k1, k2, k3 uint32) []byte {
+	key := make([]byte, 12)
+	copy(key, u32tob(k1))
+	copy(key[4:], u32tob(k2))
+	copy(key[8:], u32tob(k3))
+	return key
+}
@@ -0,0 +1,129 @@
+package malle
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func hasGraph(graphs []rdf.IRI, g rdf.IRI) bool {
+	for _, iri := range graphs {
+		if iri == g {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNamedGraphs(t *testing.T) {
+	g1, g2 := mustNewIRI("ng1"), mustNewIRI("ng2")
+	agency1 := rdf.Load(bytes.NewBufferString(`<n1> <np1> <n2> .
+<n1> <np2> "a" .
+<n2> <np2> "b" .
+`))
+	agency2 := rdf.Load(bytes.NewBufferString(`<n1> <np1> <n2> .
+<n3> <np2> "c" .
+`))
+	shared := rdf.NewTriple(mustNewIRI("n1"), mustNewIRI("np1"), mustNewIRI("n2"))
+	only1 := rdf.NewTriple(mustNewIRI("n2"), mustNewIRI("np2"), mustNewLiteral("b"))
+	startStats := testDB.Stats()
+
+	if err := testDB.ImportGraph(agency1, g1); err != nil {
+		t.Fatalf("Store.ImportGraph(%v, %v) == %v; want no error", agency1, g1, err)
+	}
+	if err := testDB.ImportGraph(agency2, g2); err != nil {
+		t.Fatalf("Store.ImportGraph(%v, %v) == %v; want no error", agency2, g2, err)
+	}
+	if err := testDB.AddTriple(shared); err != nil {
+		t.Fatalf("Store.AddTriple(%v) == %v; want no error", shared, err)
+	}
+
+	if n := testDB.Stats().NumTriples - startStats.NumTriples; n != 4 {
+		t.Errorf("stored %d new triples; want 4", n)
+	}
+
+	graphs, err := testDB.ListGraphs()
+	if err != nil || !hasGraph(graphs, g1) || !hasGraph(graphs, g2) {
+		t.Fatalf("Store.ListGraphs() == %v, %v; want %v and %v included", graphs, err, g1, g2)
+	}
+
+	hasTests := []struct {
+		tr     rdf.Triple
+		graphs []rdf.IRI
+		want   bool
+	}{
+		{shared, nil, true},
+		{shared, []rdf.IRI{g1}, true},
+		{shared, []rdf.IRI{g2}, true},
+		{shared, []rdf.IRI{DefaultGraph}, true},
+		{only1, nil, true},
+		{only1, []rdf.IRI{g1}, true},
+		{only1, []rdf.IRI{g2}, false},
+		{only1, []rdf.IRI{g2, g1}, true},
+		{only1, []rdf.IRI{DefaultGraph}, false},
+		{only1, []rdf.IRI{"ng-unknown"}, false},
+	}
+	for _, test := range hasTests {
+		if got, err := testDB.HasTriple(test.tr, test.graphs...); err != nil || got != test.want {
+			t.Errorf("Store.HasTriple(%v, %v) == %v, %v; want %v, <nil>", test.tr, test.graphs, got, err, test.want)
+		}
+	}
+
+	res, _, err := testDB.Query(NewQuery().Resource("n1").From(g2))
+	want := rdf.Load(bytes.NewBufferString(`<n1> <np1> <n2> .`))
+	if err != nil || !want.Eq(res) {
+		t.Errorf("Store.Query(NewQuery().Resource(n1).From(%v)) == %v, %v; want %v, <nil>", g2, res, err, want)
+	}
+
+	sols, err := testDB.Select(NewBGP().Where(Variable("s"), mustNewIRI("np2"), Variable("o")).From(g2, DefaultGraph))
+	if err != nil || len(sols) != 1 || bindingString(sols[0]) != `?o="c" ?s=<n3>` {
+		t.Errorf("Store.Select(?s <np2> ?o FROM %v) == %v, %v; want 1 solution", g2, sols, err)
+	}
+
+	// Removing a triple from one graph keeps it in the others.
+	if err := testDB.RemoveTriple(shared, g1); err != nil {
+		t.Fatalf("Store.RemoveTriple(%v, %v) == %v; want no error", shared, g1, err)
+	}
+	if err := testDB.RemoveTriple(shared, g1); err != ErrNotFound {
+		t.Errorf("Store.RemoveTriple(%v, %v) == %v; want ErrNotFound", shared, g1, err)
+	}
+	if ok, _ := testDB.HasTriple(shared); !ok {
+		t.Errorf("Store.RemoveTriple(%v, %v) removed the triple from all graphs", shared, g1)
+	}
+
+	// Clearing a graph removes its triples and name, unless in other graphs.
+	if err := testDB.ClearGraph(g1); err != nil {
+		t.Fatalf("Store.ClearGraph(%v) == %v; want no error", g1, err)
+	}
+	graphs, err = testDB.ListGraphs()
+	if err != nil || hasGraph(graphs, g1) {
+		t.Errorf("Store.ListGraphs() == %v, %v; want %v removed", graphs, err, g1)
+	}
+	if ok, _ := testDB.HasTriple(only1); ok {
+		t.Errorf("Store.ClearGraph(%v) failed to delete %v", g1, only1)
+	}
+	if ok, _ := testDB.HasTriple(shared, g2, DefaultGraph); !ok {
+		t.Errorf("Store.ClearGraph(%v) deleted %v from other graphs", g1, shared)
+	}
+
+	// Replacing a graph.
+	replacement := rdf.Load(bytes.NewBufferString(`<n4> <np2> "d" .`))
+	if err := testDB.ReplaceGraph(g2, replacement); err != nil {
+		t.Fatalf("Store.ReplaceGraph(%v, %v) == %v; want no error", g2, replacement, err)
+	}
+	sols, err = testDB.Select(NewBGP().Where(Variable("s"), Variable("p"), Variable("o")).From(g2))
+	if err != nil || len(sols) != 1 || bindingString(sols[0]) != `?o="d" ?p=<np2> ?s=<n4>` {
+		t.Errorf("Store.Select(?s ?p ?o FROM %v) == %v, %v; want 1 solution", g2, sols, err)
+	}
+
+	if err := testDB.ClearGraph(g2); err != nil {
+		t.Fatalf("Store.ClearGraph(%v) == %v; want no error", g2, err)
+	}
+	if err := testDB.RemoveTriple(shared); err != nil {
+		t.Fatalf("Store.RemoveTriple(%v) == %v; want no error", shared, err)
+	}
+	if stats := testDB.Stats(); stats.NumTriples != startStats.NumTriples || stats.NumTerms != startStats.NumTerms {
+		t.Errorf("Store.Stats() == %+v after clearing all graphs; want %+v", stats, startStats)
+	}
+}
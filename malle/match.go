@@ -0,0 +1,300 @@
+package malle
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// idIterator iterates over the term IDs of a bitmap.
+type idIterator interface {
+	HasNext() bool
+	Next() uint32
+}
+
+// TripleIterator iterates over the triples matching a pattern.
+// It must be closed after use, since it holds an open read transaction.
+type TripleIterator struct {
+	db  *Store
+	tx  *bolt.Tx
+	own bool // true if the iterator must close the transaction
+
+	bkt    []byte // index bucket
+	prefix []byte // composite key prefix to scan
+	v      uint32 // bitmap value to filter on, when filter is set
+	filter bool
+	spo    func(k1, k2, v uint32) (s, p, o uint32)
+	scope  graphScope // graphs to match triples in; all if nil
+
+	from  []byte // composite key to start from, if set
+	fromV uint32 // bitmap value to start after, in the key to start from
+
+	cur     *bolt.Cursor
+	k1, k2  uint32
+	t1, t2  rdf.Term
+	it      idIterator
+	last    uint32 // bitmap value of the last triple
+	started bool
+	done    bool
+}
+
+// Match returns an iterator over all stored triples matching the given pattern.
+// An empty IRI as subject or predicate, or a nil object, acts as a wildcard.
+// The best suited index is chosen depending on which positions are given.
+func (db *Store) Match(s, p rdf.IRI, o rdf.Term) (*TripleIterator, error) {
+	tx, err := db.kv.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	it, err := db.match(tx, s, p, o)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	it.own = true
+	return it, nil
+}
+
+// Next returns the next matching triple, or io.EOF when there are no more.
+func (it *TripleIterator) Next() (rdf.Triple, error) {
+	s, p, o, err := it.nextIDs()
+	if err != nil {
+		return rdf.Triple{}, err
+	}
+	return it.triple(s, p, o)
+}
+
+// nextIDs returns the term IDs of the next matching triple, or io.EOF when
+// there are no more.
+func (it *TripleIterator) nextIDs() (s, p, o uint32, err error) {
+	for !it.done {
+		if it.it != nil && it.it.HasNext() {
+			it.last = it.it.Next()
+			s, p, o = it.spo(it.k1, it.k2, it.last)
+			if it.scope != nil {
+				ok, err := it.db.inScope(it.tx, it.scope, s, p, o)
+				if err != nil {
+					it.done = true
+					return 0, 0, 0, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			return s, p, o, nil
+		}
+		if err = it.advance(); err != nil {
+			it.done = true
+			return 0, 0, 0, err
+		}
+	}
+	return 0, 0, 0, io.EOF
+}
+
+// Close releases the resources held by the iterator.
+func (it *TripleIterator) Close() error {
+	it.done = true
+	if it.own && it.tx != nil {
+		err := it.tx.Rollback()
+		it.tx = nil
+		return err
+	}
+	return nil
+}
+
+// advance moves the cursor to the next composite key matching the prefix
+// and loads its bitmap.
+func (it *TripleIterator) advance() error {
+	var k, v []byte
+	if !it.started {
+		it.cur = it.tx.Bucket(it.bkt).Cursor()
+		if it.from != nil {
+			k, v = it.cur.Seek(it.from)
+		} else {
+			k, v = it.cur.Seek(it.prefix)
+		}
+		it.started = true
+	} else {
+		k, v = it.cur.Next()
+	}
+	if k == nil || !bytes.HasPrefix(k, it.prefix) {
+		it.done = true
+		return nil
+	}
+
+	k1, k2 := btou32(k[:4]), btou32(k[4:])
+	if it.t1 == nil || k1 != it.k1 {
+		t, err := it.db.getTerm(it.tx, k1)
+		if err != nil {
+			return err
+		}
+		it.t1 = t
+	}
+	if it.t2 == nil || k2 != it.k2 {
+		t, err := it.db.getTerm(it.tx, k2)
+		if err != nil {
+			return err
+		}
+		it.t2 = t
+	}
+	it.k1, it.k2 = k1, k2
+
+	bitmap := roaring.NewRoaringBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+		return err
+	}
+	if it.filter {
+		// Only the value filtered on can match, so there is no need
+		// to iterate over the bitmap.
+		it.it = &singleID{id: it.v, done: !bitmap.Contains(it.v)}
+		return nil
+	}
+	it.it = bitmap.Iterator()
+	if it.from != nil && bytes.Equal(k, it.from) {
+		it.it = newAfterID(it.it, it.fromV)
+	}
+	return nil
+}
+
+// seek makes the iterator start after the triple with the given composite
+// key and bitmap value in its index, which must match the prefix.
+func (it *TripleIterator) seek(k1, k2, v uint32) {
+	it.from, it.fromV = compositeKey(k1, k2), v
+}
+
+// position returns the composite key and bitmap value in the index of the
+// last triple returned by nextIDs.
+func (it *TripleIterator) position() (k1, k2, v uint32) {
+	return it.k1, it.k2, it.last
+}
+
+// singleID is an idIterator over a single ID, or none if done.
+type singleID struct {
+	id   uint32
+	done bool
+}
+
+func (s *singleID) HasNext() bool { return !s.done }
+
+func (s *singleID) Next() uint32 {
+	s.done = true
+	return s.id
+}
+
+// afterID is an idIterator over the IDs of another after a given ID.
+type afterID struct {
+	it   idIterator
+	next uint32
+	ok   bool
+}
+
+func newAfterID(it idIterator, after uint32) *afterID {
+	a := &afterID{it: it}
+	for it.HasNext() {
+		if id := it.Next(); id > after {
+			a.next, a.ok = id, true
+			break
+		}
+	}
+	return a
+}
+
+func (a *afterID) HasNext() bool { return a.ok }
+
+func (a *afterID) Next() uint32 {
+	id := a.next
+	if a.ok = a.it.HasNext(); a.ok {
+		a.next = a.it.Next()
+	}
+	return id
+}
+
+// triple looks up the terms of the given IDs and returns them as a Triple.
+// The terms of the composite key are allready decoded.
+func (it *TripleIterator) triple(s, p, o uint32) (rdf.Triple, error) {
+	terms := make([]rdf.Term, 3)
+	for i, id := range []uint32{s, p, o} {
+		switch id {
+		case it.k1:
+			terms[i] = it.t1
+		case it.k2:
+			terms[i] = it.t2
+		default:
+			t, err := it.db.getTerm(it.tx, id)
+			if err != nil {
+				return rdf.Triple{}, err
+			}
+			terms[i] = t
+		}
+	}
+	return rdf.NewTriple(terms[0].(rdf.IRI), terms[1].(rdf.IRI), terms[2]), nil
+}
+
+// match sets up a TripleIterator for the given pattern using the given transaction.
+func (db *Store) match(tx *bolt.Tx, s, p rdf.IRI, o rdf.Term) (*TripleIterator, error) {
+	var sID, pID, oID uint32
+	var err error
+	for _, x := range []struct {
+		bound bool
+		term  rdf.Term
+		id    *uint32
+	}{
+		{s != "", s, &sID},
+		{p != "", p, &pID},
+		{o != nil, o, &oID},
+	} {
+		if !x.bound {
+			continue
+		}
+		*x.id, err = db.getID(tx, x.term)
+		if err == ErrNotFound {
+			// No stored triple can match a pattern with an unknown term.
+			return &TripleIterator{db: db, tx: tx, done: true}, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return db.matchIDs(tx, sID, pID, oID), nil
+}
+
+// matchIDs sets up a TripleIterator for the given pattern of term IDs, using
+// the given transaction. Term IDs start at 1, so 0 acts as a wildcard.
+func (db *Store) matchIDs(tx *bolt.Tx, s, p, o uint32) *TripleIterator {
+	it := &TripleIterator{db: db, tx: tx}
+
+	spo := func(s, p, o uint32) (uint32, uint32, uint32) { return s, p, o }
+	osp := func(o, s, p uint32) (uint32, uint32, uint32) { return s, p, o }
+	pos := func(p, o, s uint32) (uint32, uint32, uint32) { return s, p, o }
+
+	switch {
+	case s != 0 && p != 0 && o != 0:
+		it.bkt, it.spo, it.prefix = bSPO, spo, compositeKey(s, p)
+		it.v, it.filter = o, true
+	case s != 0 && p != 0:
+		it.bkt, it.spo, it.prefix = bSPO, spo, compositeKey(s, p)
+	case s != 0 && o != 0:
+		it.bkt, it.spo, it.prefix = bOSP, osp, compositeKey(o, s)
+	case p != 0 && o != 0:
+		it.bkt, it.spo, it.prefix = bPOS, pos, compositeKey(p, o)
+	case s != 0:
+		it.bkt, it.spo, it.prefix = bSPO, spo, u32tob(s)
+	case p != 0:
+		it.bkt, it.spo, it.prefix = bPOS, pos, u32tob(p)
+	case o != 0:
+		it.bkt, it.spo, it.prefix = bOSP, osp, u32tob(o)
+	default:
+		it.bkt, it.spo, it.prefix = bSPO, spo, []byte{}
+	}
+	return it
+}
+
+// compositeKey returns the 8-byte index key of the two given IDs.
+func compositeKey(k1, k2 uint32) []byte {
+	key := make([]byte, 8)
+	copy(key, u32tob(k1))
+	copy(key[4:], u32tob(k2))
+	return key
+}
@@ -0,0 +1,85 @@
+package malle
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestMatch(t *testing.T) {
+	input := `<m1> <mp1> <m2> .
+<m1> <mp1> <m3> .
+<m1> <mp2> "a" .
+<m2> <mp1> <m3> .
+<m2> <mp2> "a" .
+<m3> <mp2> "b" .
+`
+	_, err := testDB.Import(bytes.NewBufferString(input), 10, false)
+	if err != nil {
+		t.Fatalf("Store.Import(%s) == %v; want no error", input, err)
+	}
+
+	tests := []struct {
+		s, p rdf.IRI
+		o    rdf.Term
+		want string
+	}{
+		{"m1", "mp1", mustNewIRI("m2"), `<m1> <mp1> <m2> .`},
+		{"m1", "mp1", mustNewIRI("m9"), ``},
+		{"m1", "mp1", mustNewIRI("m1"), ``},
+		{"m2", "mp1", mustNewIRI("m3"), `<m2> <mp1> <m3> .`},
+		{"m1", "mp1", nil, `<m1> <mp1> <m2> .
+<m1> <mp1> <m3> .`},
+		{"m1", "", nil, `<m1> <mp1> <m2> .
+<m1> <mp1> <m3> .
+<m1> <mp2> "a" .`},
+		{"", "mp2", mustNewLiteral("a"), `<m1> <mp2> "a" .
+<m2> <mp2> "a" .`},
+		{"", "", mustNewIRI("m3"), `<m1> <mp1> <m3> .
+<m2> <mp1> <m3> .`},
+		{"m2", "", mustNewIRI("m3"), `<m2> <mp1> <m3> .`},
+		{"", "mp2", nil, `<m1> <mp2> "a" .
+<m2> <mp2> "a" .
+<m3> <mp2> "b" .`},
+		{"unknown", "", nil, ``},
+	}
+
+	for _, test := range tests {
+		it, err := testDB.Match(test.s, test.p, test.o)
+		if err != nil {
+			t.Fatalf("Store.Match(%v, %v, %v) == %v; want no error", test.s, test.p, test.o, err)
+		}
+		got := rdf.NewGraph()
+		for tr, err := it.Next(); err != io.EOF; tr, err = it.Next() {
+			if err != nil {
+				t.Fatalf("TripleIterator.Next() == %v; want no error", err)
+			}
+			got.Add(tr)
+		}
+		if err := it.Close(); err != nil {
+			t.Fatalf("TripleIterator.Close() == %v; want no error", err)
+		}
+		want := rdf.Load(bytes.NewBufferString(test.want))
+		if !got.Eq(want) {
+			t.Errorf("Store.Match(%v, %v, %v) == %v; want %v", test.s, test.p, test.o, got, want)
+		}
+	}
+
+	it, err := testDB.Match("", "", nil)
+	if err != nil {
+		t.Fatalf("Store.Match(_, _, _) == %v; want no error", err)
+	}
+	defer it.Close()
+	n := 0
+	for _, err := it.Next(); err != io.EOF; _, err = it.Next() {
+		if err != nil {
+			t.Fatalf("TripleIterator.Next() == %v; want no error", err)
+		}
+		n++
+	}
+	if want := testDB.Stats().NumTriples; n != want {
+		t.Errorf("Store.Match(_, _, _) returned %d triples; want %d", n, want)
+	}
+}
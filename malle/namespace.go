@@ -0,0 +1,89 @@
+package malle
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// Namespaces
+//
+// IRIs are stored split in a namespace, up to and including the last '/' or
+// '#', and a local part, so that the namespace is stored only once. There
+// can be at most MaxNamespaces namespaces; when they are exhausted, IRIs of
+// new namespaces are stored whole.
+//
+// A namespace can be given a prefix, for writing its IRIs as CURIEs, ex
+// foaf:name. The prefixes are stored with the namespaces, but are not part
+// of the change log, and so are not replicated.
+
+// Namespace is a namespace of the stored IRIs.
+type Namespace struct {
+	ID     uint16
+	IRI    rdf.IRI
+	Prefix string // the lowest of any prefixes, or empty if none
+}
+
+// Namespaces returns the stored namespaces, in the order they were stored.
+// There can be at most MaxNamespaces of them.
+func (db *Store) Namespaces() (ns []Namespace, err error) {
+	prefixes := db.Prefixes()
+	byNS := make(map[rdf.IRI]string, len(prefixes))
+	for prefix, iri := range prefixes {
+		if p, ok := byNS[iri]; !ok || prefix < p {
+			byNS[iri] = prefix
+		}
+	}
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bNS).ForEach(func(k, v []byte) error {
+			iri := rdf.IRI(v)
+			ns = append(ns, Namespace{ID: btou16(k), IRI: iri, Prefix: byNS[iri]})
+			return nil
+		})
+	})
+	return ns, err
+}
+
+// Prefixes returns the prefixes set with SetPrefix.
+func (db *Store) Prefixes() rdf.Prefixes {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	prefixes := make(rdf.Prefixes, len(db.prefixes))
+	for prefix, ns := range db.prefixes {
+		prefixes[prefix] = ns
+	}
+	return prefixes
+}
+
+// SetPrefix sets the prefix of the namespace, storing the namespace if it
+// is not stored allready, or removes the prefix if the namespace is empty.
+// A namespace keeps any other prefixes it has. It returns
+// ErrNamespacesExhausted if the namespace cannot be stored.
+func (db *Store) SetPrefix(prefix string, ns rdf.IRI) error {
+	if prefix == "" || !rdf.ValidPrefix(prefix) {
+		return fmt.Errorf("invalid prefix: %q", prefix)
+	}
+	err := db.kv.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bPrefix)
+		if ns == "" {
+			return bkt.Delete([]byte(prefix))
+		}
+		if _, err := db.getOrSetNS(tx, string(ns)); err != nil {
+			return err
+		}
+		return bkt.Put([]byte(prefix), []byte(ns))
+	})
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	if ns == "" {
+		delete(db.prefixes, prefix)
+	} else {
+		db.prefixes[prefix] = ns
+	}
+	db.mu.Unlock()
+	return nil
+}
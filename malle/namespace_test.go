@@ -0,0 +1,90 @@
+package malle
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestNamespaces(t *testing.T) {
+	const file = "_ns.db"
+	db, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer func() { db.Close() }()
+
+	tr := rdf.NewTriple("http://ex.org/book", "http://purl.org/dc/terms/title", mustNewLiteral("Sult"))
+	if err := db.AddTriple(tr); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPrefix("dc", "http://purl.org/dc/terms/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPrefix("foaf", "http://xmlns.com/foaf/0.1/"); err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range []string{"", "1a", "a b"} {
+		if err := db.SetPrefix(prefix, "http://ex.org/"); err == nil {
+			t.Errorf("Store.SetPrefix(%q) == <nil>; want error", prefix)
+		}
+	}
+
+	want := []Namespace{
+		{ID: 1, IRI: "http://ex.org/"},
+		{ID: 2, IRI: "http://purl.org/dc/terms/", Prefix: "dc"},
+		{ID: 3, IRI: "http://xmlns.com/foaf/0.1/", Prefix: "foaf"},
+	}
+	ns, err := db.Namespaces()
+	if err != nil || len(ns) != len(want) {
+		t.Fatalf("Store.Namespaces() == %v, %v; want %v", ns, err, want)
+	}
+	for i := range want {
+		if ns[i] != want[i] {
+			t.Errorf("Store.Namespaces()[%d] == %v; want %v", i, ns[i], want[i])
+		}
+	}
+	if curie, ok := db.Prefixes().Compact(tr.Predicate()); !ok || curie != "dc:title" {
+		t.Errorf("Store.Prefixes().Compact(%v) == %q, %v; want \"dc:title\", true", tr.Predicate(), curie, ok)
+	}
+
+	// Prefixes are persisted, and can be removed.
+	db.Close()
+	if db, err = Init(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPrefix("foaf", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Prefixes(); len(got) != 1 || got["dc"] != "http://purl.org/dc/terms/" {
+		t.Errorf("Store.Prefixes() == %v; want map[dc:<http://purl.org/dc/terms/>]", got)
+	}
+	if problems, err := db.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() == %v, %v; want no problems", problems, err)
+	}
+
+	// When the namespaces are exhausted, IRIs of new namespaces are
+	// stored whole.
+	err = db.kv.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bNS).SetSequence(MaxNamespaces)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPrefix("skos", "http://www.w3.org/2004/02/skos/core#"); err != ErrNamespacesExhausted {
+		t.Errorf("Store.SetPrefix() when exhausted == %v; want %v", err, ErrNamespacesExhausted)
+	}
+	whole := rdf.NewTriple("http://new.org/book", "http://purl.org/dc/terms/title", mustNewLiteral("Pan"))
+	if err := db.AddTriple(whole); err != nil {
+		t.Fatalf("Store.AddTriple() when namespaces exhausted == %v; want <nil>", err)
+	}
+	if ok, err := db.HasTriple(whole); err != nil || !ok {
+		t.Errorf("Store.HasTriple(%v) == %v, %v; want true, <nil>", whole, ok, err)
+	}
+	if ns, _ := db.Namespaces(); len(ns) != 3 {
+		t.Errorf("Store.Namespaces() when exhausted == %v; want 3 namespaces", ns)
+	}
+}
@@ -0,0 +1,382 @@
+package malle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// Patch is a sequence of triples to add to and remove from a store, to be
+// applied in order and in a single transaction with ApplyPatch.
+type Patch struct {
+	// Header holds the headers of the patch, ex "id" and "prev",
+	// as given in the RDF Patch format.
+	Header map[string]rdf.Term
+
+	// Changes are the triples to add and remove. The sequence numbers
+	// are not used.
+	Changes []Change
+}
+
+// NewPatch returns a patch which removes the triples of del and then adds the
+// triples of ins, in each of the given named graphs, or in the default graph
+// if none are given. Either graph may be nil.
+func NewPatch(del, ins rdf.Graph, graphs ...rdf.IRI) *Patch {
+	if len(graphs) == 0 {
+		graphs = []rdf.IRI{DefaultGraph}
+	}
+	p := &Patch{Header: make(map[string]rdf.Term)}
+	for _, x := range []struct {
+		op ChangeOp
+		g  rdf.Graph
+	}{
+		{ChangeRemove, del},
+		{ChangeAdd, ins},
+	} {
+		for _, tr := range x.g.Triples() {
+			for _, g := range graphs {
+				p.Changes = append(p.Changes, Change{Op: x.op, Triple: tr, Graph: g})
+			}
+		}
+	}
+	return p
+}
+
+// ParsePatch parses a patch in the RDF Patch text format, where each line is
+// a header (H), a transaction marker (TX, TC, TA), a prefix declaration (PA,
+// PD), or a triple or quad to add (A) or delete (D). Terms may be written as
+// in N-Triples, or as prefixed names using the declared prefixes.
+//
+// Notes and (possible) deviations from the RDF Patch specification:
+//   - Blank nodes are not supported.
+//   - The changes of a transaction ended with TA (abort) are left out, and a
+//     transaction which is not ended is an error.
+//   - Prefix declarations only apply to the parsing of the patch; they are
+//     not stored.
+//   - The final dot of a line is optional.
+func ParsePatch(r io.Reader) (*Patch, error) {
+	p := &Patch{Header: make(map[string]rdf.Term)}
+	prefixes := make(rdf.Prefixes)
+	inTx := false
+	txStart := 0 // index of the first change of the current transaction
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<24)
+	line := 0
+	for sc.Scan() {
+		line++
+		toks, err := patchTokens(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("patch: line %d: %v", line, err)
+		}
+		if len(toks) > 0 && toks[len(toks)-1] == "." {
+			toks = toks[:len(toks)-1]
+		}
+		if len(toks) == 0 {
+			continue
+		}
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("patch: line %d: %s", line, fmt.Sprintf(format, args...))
+		}
+
+		switch toks[0] {
+		case "H":
+			if len(toks) != 3 {
+				return nil, errorf("H requires a name and a value")
+			}
+			t, err := patchTerm(toks[2], prefixes)
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			p.Header[toks[1]] = t
+		case "TX":
+			if inTx {
+				return nil, errorf("nested transaction")
+			}
+			inTx, txStart = true, len(p.Changes)
+		case "TC", "TA":
+			if !inTx {
+				return nil, errorf("%s outside transaction", toks[0])
+			}
+			if toks[0] == "TA" {
+				p.Changes = p.Changes[:txStart]
+			}
+			inTx = false
+		case "PA":
+			if len(toks) != 3 {
+				return nil, errorf("PA requires a prefix and an IRI")
+			}
+			t, err := patchTerm(toks[2], prefixes)
+			iri, ok := t.(rdf.IRI)
+			if err != nil || !ok {
+				return nil, errorf("PA requires an IRI: %s", toks[2])
+			}
+			prefixes[strings.TrimSuffix(toks[1], ":")] = iri
+		case "PD":
+			if len(toks) != 2 {
+				return nil, errorf("PD requires a prefix")
+			}
+			delete(prefixes, strings.TrimSuffix(toks[1], ":"))
+		case "A", "D":
+			if len(toks) != 4 && len(toks) != 5 {
+				return nil, errorf("%s requires a triple or a quad", toks[0])
+			}
+			var terms [4]rdf.Term
+			for i, tok := range toks[1:] {
+				if terms[i], err = patchTerm(tok, prefixes); err != nil {
+					return nil, errorf("%v", err)
+				}
+			}
+			s, ok1 := terms[0].(rdf.IRI)
+			pred, ok2 := terms[1].(rdf.IRI)
+			if !ok1 || !ok2 {
+				return nil, errorf("subject and predicate must be IRIs")
+			}
+			c := Change{Op: ChangeOp(toks[0][0]), Triple: rdf.NewTriple(s, pred, terms[2])}
+			if terms[3] != nil {
+				g, ok := terms[3].(rdf.IRI)
+				if !ok {
+					return nil, errorf("graph must be an IRI")
+				}
+				c.Graph = g
+			}
+			p.Changes = append(p.Changes, c)
+		default:
+			return nil, errorf("unknown row: %s", toks[0])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if inTx {
+		return nil, fmt.Errorf("patch: line %d: unterminated transaction", line)
+	}
+	return p, nil
+}
+
+// ApplyPatch applies the changes of the patch in order and in a single
+// transaction, and returns the number of triples actually added and
+// removed. Adding a triple allready stored, or removing a triple which is
+// not, has no effect. The validator set with SetValidator is called with a
+// graph of the triples added and removed, and may reject the patch.
+func (db *Store) ApplyPatch(p *Patch) (added, removed int, err error) {
+	validate := db.validator()
+	err = db.update(func(tx *bolt.Tx) error {
+		g := rdf.NewGraph()
+		for _, c := range p.Changes {
+			var graphs []rdf.IRI
+			if c.Graph != DefaultGraph {
+				graphs = []rdf.IRI{c.Graph}
+			}
+			var err error
+			switch c.Op {
+			case ChangeAdd:
+				err = db.addTriple(tx, c.Triple, graphs)
+			case ChangeRemove:
+				if err = db.removeTripleFrom(tx, c.Triple, graphs); err == ErrNotFound {
+					err = nil
+				}
+			default:
+				err = errCorruptChange
+			}
+			if err != nil {
+				return err
+			}
+			g.Add(c.Triple)
+		}
+
+		// Every triple added to or removed from a graph is logged.
+		added, removed = 0, 0
+		for _, c := range db.pending {
+			if c.Op == ChangeAdd {
+				added++
+			} else {
+				removed++
+			}
+		}
+		if validate != nil && !g.IsEmpty() {
+			return validate(&Tx{db: db, tx: tx}, g)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return added, removed, nil
+}
+
+// patchTokens splits a line of an RDF Patch into tokens; IRIs, literals
+// including any language tag or datatype, and words separated by space.
+// Comments are left out.
+func patchTokens(line string) (toks []string, err error) {
+	i := 0
+	for i < len(line) {
+		start := i
+		switch line[i] {
+		case ' ', '\t', '\r':
+			i++
+			continue
+		case '#':
+			return toks, nil
+		case '<':
+			end := strings.IndexByte(line[i:], '>')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed IRI: %s", line[i:])
+			}
+			i += end + 1
+		case '"':
+			i += closingQuote(line[i:])
+			if i >= len(line) {
+				return nil, fmt.Errorf("unclosed literal: %s", line[start:])
+			}
+			i++
+			suffix := i
+			if strings.HasPrefix(line[i:], "^^<") {
+				end := strings.IndexByte(line[i:], '>')
+				if end < 0 {
+					return nil, fmt.Errorf("unclosed IRI: %s", line[i:])
+				}
+				i += end + 1
+			} else if i < len(line) && (line[i] == '@' || line[i] == '^') {
+				i = wordEnd(line, i)
+				if line[suffix:i] == "^^" {
+					return nil, fmt.Errorf("missing datatype: %s", line[start:i])
+				}
+			}
+		default:
+			i = wordEnd(line, i)
+		}
+		toks = append(toks, line[start:i])
+	}
+	return toks, nil
+}
+
+// wordEnd returns the position of the first space after i in the line, or
+// of a final dot before it.
+func wordEnd(line string, i int) int {
+	start := i
+	for i < len(line) && line[i] != ' ' && line[i] != '\t' && line[i] != '\r' {
+		i++
+	}
+	if i-start > 1 && line[i-1] == '.' {
+		i--
+	}
+	return i
+}
+
+// closingQuote returns the position of the quote ending the literal at the
+// start of s, or len(s) if it is not closed.
+func closingQuote(s string) int {
+	i := 1
+	for i < len(s) && s[i] != '"' {
+		if s[i] == '\\' {
+			i++
+		}
+		i++
+	}
+	if i > len(s) {
+		return len(s)
+	}
+	return i
+}
+
+// patchTerm parses a term of an RDF Patch.
+func patchTerm(tok string, prefixes rdf.Prefixes) (rdf.Term, error) {
+	if tok == "" {
+		return nil, fmt.Errorf("missing term")
+	}
+	switch {
+	case tok[0] == '<':
+		return rdf.NewIRI(tok[1 : len(tok)-1])
+	case tok[0] == '"':
+		end := closingQuote(tok)
+		val, err := unescapeLiteral(tok[1:end])
+		if err != nil {
+			return nil, err
+		}
+		switch suffix := tok[end+1:]; {
+		case suffix == "":
+			return rdf.NewLiteral(val)
+		case suffix[0] == '@':
+			return rdf.NewLangLiteral(val, suffix[1:])
+		case strings.HasPrefix(suffix, "^^"):
+			dt, err := patchTerm(suffix[2:], prefixes)
+			if err != nil {
+				return nil, err
+			}
+			iri, ok := dt.(rdf.IRI)
+			if !ok {
+				return nil, fmt.Errorf("datatype must be an IRI: %s", tok)
+			}
+			return rdf.NewTypedLiteral(val, iri)
+		default:
+			return nil, fmt.Errorf("unexpected token: %s", tok)
+		}
+	case strings.HasPrefix(tok, "_:"):
+		return nil, fmt.Errorf("blank nodes are not supported: %s", tok)
+	}
+	if strings.IndexByte(tok, ':') >= 0 {
+		iri, ok := prefixes.Expand(tok)
+		if !ok {
+			return nil, fmt.Errorf("unknown prefix: %s", tok)
+		}
+		return iri, nil
+	}
+	return nil, fmt.Errorf("unexpected token: %s", tok)
+}
+
+// unescapeLiteral replaces the escape sequences of a literal in N-Triples
+// syntax with the characters they represent.
+func unescapeLiteral(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("illegal escape sequence: %q", s)
+		}
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'b':
+			b.WriteByte('\b')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case '"', '\'', '\\':
+			b.WriteByte(s[i])
+		case 'u', 'U':
+			digits := 4
+			if s[i] == 'U' {
+				digits = 8
+			}
+			if i+digits >= len(s) {
+				return "", fmt.Errorf("illegal escape sequence: %q", s[i-1:])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+digits], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", fmt.Errorf("illegal escape sequence: %q", s[i-1:i+1+digits])
+			}
+			b.WriteRune(rune(r))
+			i += digits
+		default:
+			return "", fmt.Errorf("illegal escape sequence: %q", s[i-1:i+1])
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,155 @@
+package malle
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestParsePatch(t *testing.T) {
+	const patch = `H id <uuid:1> .
+H prev <uuid:0>
+PA ex <http://ex.org/> .
+PA xsd: <http://www.w3.org/2001/XMLSchema#> .
+# a comment
+TX .
+A ex:s ex:p "one" .
+A <http://ex.org/s> ex:p "two"@en ex:g .
+A ex:s ex:p "3"^^xsd:int.
+D ex:s ex:p "quote \"4\" \u00e5" # trailing comment
+TC .
+TX .
+A ex:s ex:p "aborted" .
+TA .
+PD ex .
+D <http://ex.org/s> <http://ex.org/p> <http://ex.org/o>
+`
+	p, err := ParsePatch(strings.NewReader(patch))
+	if err != nil {
+		t.Fatalf("ParsePatch() == %v; want no error", err)
+	}
+	if got := p.Header["id"]; got != rdf.IRI("uuid:1") {
+		t.Errorf("ParsePatch() header id == %v; want <uuid:1>", got)
+	}
+	s, pred := rdf.IRI("http://ex.org/s"), rdf.IRI("http://ex.org/p")
+	want := []Change{
+		{Op: ChangeAdd, Triple: rdf.NewTriple(s, pred, mustNewLiteral("one"))},
+		{Op: ChangeAdd, Triple: rdf.NewTriple(s, pred, mustNewLangLiteral("two", "en")), Graph: "http://ex.org/g"},
+		{Op: ChangeAdd, Triple: rdf.NewTriple(s, pred, mustNewTypedLiteral("3", "http://www.w3.org/2001/XMLSchema#int"))},
+		{Op: ChangeRemove, Triple: rdf.NewTriple(s, pred, mustNewLiteral(`quote "4" å`))},
+		{Op: ChangeRemove, Triple: rdf.NewTriple(s, pred, rdf.IRI("http://ex.org/o"))},
+	}
+	if len(p.Changes) != len(want) {
+		t.Fatalf("ParsePatch() == %v; want %v", p.Changes, want)
+	}
+	for i, c := range p.Changes {
+		if c.Op != want[i].Op || !c.Triple.Eq(want[i].Triple) || c.Graph != want[i].Graph {
+			t.Errorf("ParsePatch() change %d == %v; want %v", i, c, want[i])
+		}
+	}
+
+	for _, bad := range []string{
+		"A <s> <p> .",
+		"A <s> <p> <o> <g> <x> .",
+		"A \"s\" <p> <o> .",
+		"A <s> <p> _:b1 .",
+		"A <s> ex:p <o> .",
+		"A <s> <p> \"unclosed .",
+		"A <s> <p> \"\" .",
+		"A <s> <p> \"\\q\" .",
+		"A <s> <p> \"x\"^^ .",
+		"A <s> <p> \"x\"^^",
+		"A <s> <p> \"x\"^ .",
+		"H id \"x\"^^",
+		"X <s> <p> <o> .",
+		"TX .\nTX .",
+		"TC .",
+		"TX .\nA <s> <p> <o> .",
+	} {
+		if _, err := ParsePatch(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParsePatch(%q) == nil; want error", bad)
+		}
+	}
+	if _, err := patchTerm("", nil); err == nil {
+		t.Errorf("patchTerm(\"\") == nil; want error")
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	const file = "_patch.db"
+	db, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer db.Close()
+
+	title := rdf.NewTriple("book", "title", mustNewLiteral("Sult"))
+	typo := rdf.NewTriple("book", "title", mustNewLiteral("Slut"))
+	creator := rdf.NewTriple("book", "creator", mustNewIRI("hamsun"))
+	if err := db.ImportGraph(rdf.NewGraph().Add(typo).Add(creator)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The typo is removed and the title added, while the creator is
+	// allready stored and the missing triple not stored.
+	del := rdf.NewGraph().Add(typo).Add(rdf.NewTriple("book", "title", mustNewLiteral("missing")))
+	ins := rdf.NewGraph().Add(title).Add(creator)
+	added, removed, err := db.ApplyPatch(NewPatch(del, ins))
+	if err != nil || added != 1 || removed != 1 {
+		t.Errorf("Store.ApplyPatch() == %d, %d, %v; want 1, 1, <nil>", added, removed, err)
+	}
+	for tr, want := range map[rdf.Triple]bool{title: true, typo: false, creator: true} {
+		if ok, _ := db.HasTriple(tr); ok != want {
+			t.Errorf("Store.HasTriple(%v) after patch == %v; want %v", tr, ok, want)
+		}
+	}
+
+	// A patch is applied atomically; if rejected, none of it is.
+	rejected := errors.New("rejected")
+	db.SetValidator(func(tx *Tx, g rdf.Graph) error {
+		if len(g["book"]["title"]) > 0 {
+			if ok, _ := tx.Has(title); !ok {
+				return rejected
+			}
+		}
+		return nil
+	})
+	p, err := ParsePatch(strings.NewReader("A <book> <creator> <undset> .\nD <book> <title> \"Sult\" .\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.ApplyPatch(p); err != rejected {
+		t.Errorf("Store.ApplyPatch() == %v; want %v", err, rejected)
+	}
+	if ok, _ := db.HasTriple(rdf.NewTriple("book", "creator", mustNewIRI("undset"))); ok {
+		t.Errorf("Store.ApplyPatch() rejected, but added triple is stored")
+	}
+	if ok, _ := db.HasTriple(title); !ok {
+		t.Errorf("Store.ApplyPatch() rejected, but removed triple is not stored")
+	}
+	db.SetValidator(nil)
+
+	// Changes to named graphs, and a triple added and then removed.
+	p, err = ParsePatch(strings.NewReader(`A <book> <title> "Sult" <g1> .
+A <book> <note> "temporary" .
+D <book> <note> "temporary" .
+D <book> <title> "Sult" .
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, removed, err = db.ApplyPatch(p)
+	if err != nil || added != 2 || removed != 2 {
+		t.Errorf("Store.ApplyPatch() == %d, %d, %v; want 2, 2, <nil>", added, removed, err)
+	}
+	if ok, _ := db.HasTriple(title, DefaultGraph); ok {
+		t.Errorf("Store.HasTriple(%v, DefaultGraph) == true; want false", title)
+	}
+	if ok, _ := db.HasTriple(title, "g1"); !ok {
+		t.Errorf("Store.HasTriple(%v, <g1>) == false; want true", title)
+	}
+}
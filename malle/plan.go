@@ -0,0 +1,422 @@
+package malle
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/tgruben/roaring"
+)
+
+// PlanOp is the operation of a step in a query Plan.
+type PlanOp byte
+
+// Plan operations
+const (
+	PlanScan  PlanOp = 'S' // iterate the triples matching a pattern, binding its variables
+	PlanJoin  PlanOp = 'J' // bind a variable by intersecting index bitmaps
+	PlanCheck PlanOp = 'C' // check that a pattern with no unbound positions is stored
+)
+
+func (op PlanOp) String() string {
+	switch op {
+	case PlanScan:
+		return "scan"
+	case PlanJoin:
+		return "join"
+	case PlanCheck:
+		return "check"
+	default:
+		return "unknown"
+	}
+}
+
+// PlanStep is a step in the evaluation of a basic graph pattern query.
+type PlanStep struct {
+	Op PlanOp
+
+	// Patterns evaluated by the step. A join intersects the bitmaps of all
+	// patterns where its variable is the only unbound position.
+	Patterns []Pattern
+
+	// Indexes holds the index used to look up each of the patterns:
+	// "spo", "osp" or "pos".
+	Indexes []string
+
+	// Var is the variable bound by a join, and Range is true if the join
+	// is intersected with the range of the variable.
+	Var   Variable
+	Range bool
+
+	// Estimate is the estimated number of matches of the step, for each
+	// solution of the preceding steps. It is less than 1 for a check, or a
+	// join which is likely to exclude a solution.
+	Estimate float64
+}
+
+// Plan is the order in which the patterns of a basic graph pattern query are
+// evaluated, as chosen by the query planner.
+type Plan []PlanStep
+
+// String returns the plan with one step per line, followed by the patterns
+// of the step and the index they are looked up in.
+func (p Plan) String() string {
+	var b bytes.Buffer
+	for i, step := range p {
+		fmt.Fprintf(&b, "%d. %v", i+1, step.Op)
+		if step.Op == PlanJoin {
+			fmt.Fprintf(&b, " %v", step.Var)
+			if step.Range {
+				b.WriteString(" in range")
+			}
+		}
+		fmt.Fprintf(&b, " (estimate %.3g)\n", step.Estimate)
+		for j, pat := range step.Patterns {
+			fmt.Fprintf(&b, "\t%s %v %v %v\n", step.Indexes[j], pat.Subject, pat.Predicate, pat.Object)
+		}
+	}
+	return b.String()
+}
+
+// Explain returns the plan Select would use to evaluate the query. The plan
+// is empty if the query has no solutions because one of its terms is not
+// stored.
+func (db *Store) Explain(q *BGP) (plan Plan, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		e, err := db.newBGPEval(tx, q.patterns)
+		if err != nil {
+			return err
+		}
+		if err = e.setRanges(q.ranges); err != nil {
+			return err
+		}
+		if e.empty {
+			return nil
+		}
+		steps, err := e.plan(make([]bool, len(e.vars)))
+		if err != nil {
+			return err
+		}
+		for _, step := range steps {
+			ps := PlanStep{Op: step.op, Estimate: step.estimate}
+			if step.op == PlanJoin {
+				ps.Var = e.vars[step.v]
+				_, ps.Range = e.ranges[step.v]
+			}
+			for _, i := range step.patterns {
+				ps.Patterns = append(ps.Patterns, q.patterns[i])
+				ps.Indexes = append(ps.Indexes, step.indexes[len(ps.Indexes)])
+			}
+			plan = append(plan, ps)
+		}
+		return nil
+	})
+	return plan, err
+}
+
+// bgpStep is a step of an evaluation plan, referring to patterns and
+// variables by index.
+type bgpStep struct {
+	op       PlanOp
+	patterns []int
+	indexes  []string
+	v        int // variable bound by a join
+	estimate float64
+}
+
+// planScanLimit is the maximum number of index keys counted when estimating
+// the number of triples with a given subject or object.
+const planScanLimit = 1000
+
+// plan orders the patterns for evaluation, given the variables which are
+// bound initially.
+//
+// The plan is built greedily: patterns with no unbound positions are checked
+// as soon as possible. Otherwise the step expected to have the fewest matches
+// is taken next; either a join of a variable which is the only unbound position
+// of some patterns, or restricted to a range, or a scan of a pattern with
+// several unbound positions. The number of matches is estimated from the
+// index bitmaps for constant terms, and the predicate statistics for bound
+// variables.
+func (e *bgpEval) plan(initial []bool) ([]bgpStep, error) {
+	bound := make([]bool, len(e.vars))
+	copy(bound, initial)
+	remaining := make([]int, len(e.patterns))
+	for i := range remaining {
+		remaining[i] = i
+	}
+	est := &planEstimator{e: e, counts: make(map[[3]uint32]float64)}
+
+	var steps []bgpStep
+	for len(remaining) > 0 {
+		var (
+			rest  []int
+			best  bgpStep
+			found bool
+		)
+		// Patterns with no unbound positions are checked first.
+		for _, i := range remaining {
+			bp, n, _ := e.boundPositions(e.patterns[i], bound)
+			if n > 0 {
+				rest = append(rest, i)
+				continue
+			}
+			f, err := est.estimate(e.patterns[i], bp)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, bgpStep{op: PlanCheck, patterns: []int{i}, indexes: []string{indexFor(bp)}, estimate: f})
+		}
+		if len(rest) == 0 {
+			break
+		}
+
+		// Joins of each unbound variable.
+		for v := range e.vars {
+			if bound[v] {
+				continue
+			}
+			step := bgpStep{op: PlanJoin, v: v, estimate: -1}
+			if r, ok := e.ranges[v]; ok {
+				step.estimate = float64(r.cardinality)
+			}
+			for _, i := range rest {
+				bp, n, u := e.boundPositions(e.patterns[i], bound)
+				if n != 1 || u != v {
+					continue
+				}
+				f, err := est.estimate(e.patterns[i], bp)
+				if err != nil {
+					return nil, err
+				}
+				if step.estimate < 0 || f < step.estimate {
+					step.estimate = f
+				}
+				step.patterns = append(step.patterns, i)
+				step.indexes = append(step.indexes, indexFor(bp))
+			}
+			if step.estimate >= 0 && (!found || step.estimate < best.estimate) {
+				best, found = step, true
+			}
+		}
+
+		// Scans of patterns with several unbound positions.
+		for _, i := range rest {
+			bp, n, _ := e.boundPositions(e.patterns[i], bound)
+			if n < 2 {
+				continue
+			}
+			f, err := est.estimate(e.patterns[i], bp)
+			if err != nil {
+				return nil, err
+			}
+			if !found || f < best.estimate {
+				best = bgpStep{op: PlanScan, patterns: []int{i}, indexes: []string{indexFor(bp)}, estimate: f}
+				found = true
+			}
+		}
+
+		steps = append(steps, best)
+		done := make(map[int]bool, len(best.patterns))
+		for _, i := range best.patterns {
+			done[i] = true
+		}
+		remaining = remaining[:0]
+		for _, i := range rest {
+			if !done[i] {
+				remaining = append(remaining, i)
+			}
+		}
+		if best.op == PlanJoin {
+			bound[best.v] = true
+		} else {
+			for _, v := range e.patterns[best.patterns[0]].vars {
+				if v != -1 {
+					bound[v] = true
+				}
+			}
+		}
+	}
+	return steps, nil
+}
+
+// boundPositions returns which positions of the pattern are bound, either to
+// a constant or a bound variable, the number of unbound positions, and the
+// variable index of the last unbound position.
+func (e *bgpEval) boundPositions(pat bgpPattern, bound []bool) (bp [3]bool, n int, v int) {
+	v = -1
+	for i, idx := range pat.vars {
+		if idx == -1 || bound[idx] {
+			bp[i] = true
+			continue
+		}
+		n++
+		v = idx
+	}
+	return bp, n, v
+}
+
+// indexFor returns the index used to look up a pattern with the given bound
+// positions, the same as chosen by matchIDs and lookupIDs.
+func indexFor(bp [3]bool) string {
+	switch {
+	case bp[0] && bp[1]:
+		return "spo"
+	case bp[0] && bp[2]:
+		return "osp"
+	case bp[1]:
+		return "pos"
+	case bp[2]:
+		return "osp"
+	default:
+		return "spo"
+	}
+}
+
+// planEstimator estimates the number of triples matching a pattern.
+type planEstimator struct {
+	e      *bgpEval
+	counts map[[3]uint32]float64 // number of triples matching the constant positions
+
+	// Predicate statistics, read when first needed.
+	stats             map[uint32][3]uint64
+	triples           float64
+	subjects, objects float64 // lower bounds of the number of distinct terms
+	predicates        float64
+}
+
+// estimate returns the expected number of triples matching the pattern when
+// the given positions are bound.
+//
+// The triples matching the constant positions are counted from the indices,
+// and for each position bound to a variable, the count is divided by the
+// number of distinct terms in that position; those of the predicate if it is
+// a constant, otherwise all terms.
+func (est *planEstimator) estimate(pat bgpPattern, bp [3]bool) (float64, error) {
+	var ids [3]uint32
+	for i, v := range pat.vars {
+		if v == -1 {
+			ids[i] = pat.ids[i]
+		}
+	}
+	f, err := est.count(ids)
+	if err != nil {
+		return 0, err
+	}
+	est.readStats()
+	for i, v := range pat.vars {
+		if v == -1 || !bp[i] {
+			continue
+		}
+		var distinct float64
+		switch i {
+		case 0:
+			distinct = est.subjects
+			if ids[1] != 0 {
+				distinct = float64(est.stats[ids[1]][1])
+			}
+		case 1:
+			distinct = est.predicates
+		case 2:
+			distinct = est.objects
+			if ids[1] != 0 {
+				distinct = float64(est.stats[ids[1]][2])
+			}
+		}
+		if distinct > 1 {
+			f /= distinct
+		}
+	}
+	return f, nil
+}
+
+// count returns the number of triples matching the given term IDs, where 0
+// is unbound. It is exact if two or three positions are bound.
+func (est *planEstimator) count(ids [3]uint32) (float64, error) {
+	if f, ok := est.counts[ids]; ok {
+		return f, nil
+	}
+	var (
+		f   float64
+		err error
+	)
+	s, p, o := ids[0], ids[1], ids[2]
+	switch {
+	case s != 0 && p != 0 && o != 0:
+		var ok bool
+		if ok, err = est.e.db.hasIDs(est.e.tx, s, p, o); ok {
+			f = 1
+		}
+	case (s != 0 && p != 0) || (s != 0 && o != 0) || (p != 0 && o != 0):
+		f, err = bitmapCardinality(est.e.db.lookupIDs(est.e.tx, s, p, o))
+	case p != 0:
+		est.readStats()
+		f = float64(est.stats[p][0])
+	case s != 0:
+		f, err = countPrefix(est.e.tx.Bucket(bSPO), u32tob(s))
+	case o != 0:
+		f, err = countPrefix(est.e.tx.Bucket(bOSP), u32tob(o))
+	default:
+		est.readStats()
+		f = est.triples
+	}
+	if err != nil {
+		return 0, err
+	}
+	est.counts[ids] = f
+	return f, nil
+}
+
+// readStats reads the predicate statistics, unless already read.
+func (est *planEstimator) readStats() {
+	if est.stats != nil {
+		return
+	}
+	est.stats = make(map[uint32][3]uint64)
+	est.e.tx.Bucket(bStats).ForEach(func(k, v []byte) error {
+		if len(k) != 4 {
+			return nil // class statistics
+		}
+		c := decodeStats(v)
+		est.stats[btou32(k)] = c
+		est.triples += float64(c[0])
+		if float64(c[1]) > est.subjects {
+			est.subjects = float64(c[1])
+		}
+		if float64(c[2]) > est.objects {
+			est.objects = float64(c[2])
+		}
+		est.predicates++
+		return nil
+	})
+}
+
+// bitmapCardinality returns the cardinality of the serialized bitmap, or 0
+// if it is nil.
+func bitmapCardinality(bo []byte) (float64, error) {
+	if bo == nil {
+		return 0, nil
+	}
+	bitmap := roaring.NewRoaringBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+		return 0, err
+	}
+	return float64(bitmap.GetCardinality()), nil
+}
+
+// countPrefix sums the cardinalities of the bitmaps of the index keys with
+// the given prefix, counting at most planScanLimit keys.
+func countPrefix(bkt *bolt.Bucket, prefix []byte) (float64, error) {
+	var f float64
+	cur := bkt.Cursor()
+	n := 0
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && n < planScanLimit; k, v = cur.Next() {
+		c, err := bitmapCardinality(v)
+		if err != nil {
+			return 0, err
+		}
+		f += c
+		n++
+	}
+	return f, nil
+}
@@ -0,0 +1,102 @@
+package malle
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestExplain(t *testing.T) {
+	var (
+		typ     = mustNewIRI("http://ex.org/planType")
+		work    = mustNewIRI("http://ex.org/PlanWork")
+		creator = mustNewIRI("http://ex.org/planCreator")
+		name    = mustNewIRI("http://ex.org/planName")
+	)
+	g := rdf.NewGraph()
+	for i := 0; i < 50; i++ {
+		w := mustNewIRI(fmt.Sprintf("http://ex.org/planWork%d", i))
+		p := mustNewIRI(fmt.Sprintf("http://ex.org/planPerson%d", i%10))
+		g.Add(rdf.NewTriple(w, typ, work))
+		g.Add(rdf.NewTriple(w, creator, p))
+		g.Add(rdf.NewTriple(p, name, mustNewLiteral(fmt.Sprintf("Plan Person %d", i%10))))
+	}
+	if err := testDB.ImportGraph(g); err != nil {
+		t.Fatal(err)
+	}
+	defer testDB.DeleteGraph(g)
+
+	type step struct {
+		op      PlanOp
+		v       Variable
+		indexes []string
+	}
+	tests := []struct {
+		q    *BGP
+		want []step
+	}{
+		{
+			// The pattern with a single match is evaluated first, even
+			// if given last.
+			NewBGP().
+				Where(Variable("w"), typ, work).
+				Where(Variable("w"), creator, Variable("p")).
+				Where(Variable("p"), name, mustNewLiteral("Plan Person 3")),
+			[]step{
+				{PlanJoin, "p", []string{"pos"}},
+				{PlanJoin, "w", []string{"pos", "pos"}},
+			},
+		},
+		{
+			NewBGP().
+				Where(Variable("w"), creator, Variable("p")).
+				Where(Variable("p"), name, Variable("name")).
+				Where(mustNewIRI("http://ex.org/planWork1"), typ, work),
+			[]step{
+				{PlanCheck, "", []string{"spo"}},
+				{PlanScan, "", []string{"pos"}},
+				{PlanJoin, "w", []string{"pos"}},
+			},
+		},
+		{
+			NewBGP().
+				Where(Variable("w"), creator, Variable("p")).
+				Where(Variable("w"), typ, Variable("class")).
+				Where(Variable("p"), name, Variable("name")),
+			[]step{
+				// There are fewer names than creators.
+				{PlanScan, "", []string{"pos"}},
+				{PlanJoin, "w", []string{"pos"}},
+				{PlanJoin, "class", []string{"spo"}},
+			},
+		},
+		{
+			NewBGP().Where(Variable("w"), creator, mustNewIRI("http://ex.org/nobody")),
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		plan, err := testDB.Explain(test.q)
+		if err != nil {
+			t.Fatalf("Store.Explain(%v) == %v; want no error", test.q.Patterns(), err)
+		}
+		var got []step
+		for _, s := range plan {
+			got = append(got, step{s.Op, s.Var, s.Indexes})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Store.Explain(%v) ==\n%v; want %v", test.q.Patterns(), plan, test.want)
+		}
+	}
+
+	res, err := testDB.Select(tests[0].q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 5 {
+		t.Errorf("Store.Select(%v) gave %d solutions; want 5", tests[0].q.Patterns(), len(res))
+	}
+}
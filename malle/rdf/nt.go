@@ -18,6 +18,10 @@ type NTDecoder struct {
 	lex        *lexer
 	BNodeAsIRI bool   // If true, convert blank nodes to IRIs
 	BNodeNS    string // Namespace for converted blank nodes
+
+	// BNodeFunc, if set, converts blank nodes to the IRIs it returns for
+	// their labels, instead of the namespace and label of BNodeAsIRI.
+	BNodeFunc func(label string) IRI
 }
 
 // NewNTDecoder returns a new NTDecoder on the given stream.
@@ -25,6 +29,19 @@ func NewNTDecoder(r io.Reader) *NTDecoder {
 	return &NTDecoder{lex: newLexer(r)}
 }
 
+// bnode returns the IRI a blank node with the given label is converted to,
+// or false if blank nodes are not converted.
+func (d *NTDecoder) bnode(label string) (IRI, bool) {
+	switch {
+	case d.BNodeFunc != nil:
+		return d.BNodeFunc(label), true
+	case d.BNodeAsIRI:
+		return IRI(d.BNodeNS + label), true
+	default:
+		return "", false
+	}
+}
+
 func (d *NTDecoder) ignoreLine() {
 	for tok := d.lex.next(); tok.Typ != tokenEOL && tok.Typ != tokenEOF; tok = d.lex.next() {
 	}
@@ -114,11 +131,12 @@ newLine:
 		return Triple{}, err
 	}
 	if tok.Typ == tokenBNode {
-		if !d.BNodeAsIRI {
+		iri, ok := d.bnode(tok.value)
+		if !ok {
 			d.ignoreLine()
 			goto newLine
 		}
-		tr.subj = IRI(d.BNodeNS + tok.value)
+		tr.subj = iri
 	} else {
 		tr.subj = IRI(tok.value)
 	}
@@ -143,11 +161,12 @@ newLine:
 		return Triple{}, err
 	}
 	if tok.Typ == tokenBNode {
-		if !d.BNodeAsIRI {
+		iri, ok := d.bnode(tok.value)
+		if !ok {
 			d.ignoreLine()
 			goto newLine
 		}
-		tr.obj = IRI(d.BNodeNS + tok.value)
+		tr.obj = iri
 		goto dot
 	}
 	if tok.Typ == tokenIRI {
@@ -0,0 +1,77 @@
+package rdf
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Prefixes maps prefixes to namespace IRIs, for writing IRIs as CURIEs
+// (compact IRIs), ex foaf:name, and reading them back.
+type Prefixes map[string]IRI
+
+// Expand returns the IRI of the CURIE, or false if it is not a CURIE with
+// one of the prefixes.
+func (p Prefixes) Expand(curie string) (IRI, bool) {
+	i := strings.IndexByte(curie, ':')
+	if i < 0 {
+		return "", false
+	}
+	ns, ok := p[curie[:i]]
+	if !ok {
+		return "", false
+	}
+	return ns + IRI(curie[i+1:]), true
+}
+
+// Compact returns the IRI as a CURIE, using the prefix of the longest
+// namespace the IRI starts with. It returns false if there is no such
+// namespace, or if the rest of the IRI is not a valid local name.
+func (p Prefixes) Compact(iri IRI) (string, bool) {
+	prefix, ns := "", IRI("")
+	found := false
+	for pr, n := range p {
+		if len(n) > len(ns) && strings.HasPrefix(string(iri), string(n)) ||
+			found && n == ns && pr < prefix {
+			prefix, ns, found = pr, n, true
+		}
+	}
+	if !found || !validLocalName(string(iri[len(ns):])) {
+		return "", false
+	}
+	return prefix + ":" + string(iri[len(ns):]), true
+}
+
+// ValidPrefix reports whether the string can be used as a prefix; it must
+// be empty, or start with a letter followed by letters, digits, '_', '-'
+// and '.', but not end with '.'.
+func ValidPrefix(prefix string) bool {
+	for i, r := range prefix {
+		switch {
+		case unicode.IsLetter(r):
+		case i == 0:
+			return false
+		case unicode.IsDigit(r), r == '_', r == '-', r == '.':
+		default:
+			return false
+		}
+	}
+	return !strings.HasSuffix(prefix, ".")
+}
+
+// validLocalName reports whether the string can be written as the local
+// name of a CURIE without escaping.
+func validLocalName(s string) bool {
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+		case r == '_', r == '-', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	if r, _ := utf8.DecodeRuneInString(s); r == '-' || r == '.' {
+		return false
+	}
+	return !strings.HasSuffix(s, ".")
+}
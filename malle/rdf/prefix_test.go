@@ -0,0 +1,60 @@
+package rdf
+
+import "testing"
+
+func TestPrefixes(t *testing.T) {
+	p := Prefixes{
+		"ex":    "http://example.org/",
+		"exv":   "http://example.org/vocab#",
+		"other": "http://example.org/vocab#",
+		"foaf":  "http://xmlns.com/foaf/0.1/",
+	}
+
+	compactTests := []struct {
+		iri   IRI
+		curie string
+		ok    bool
+	}{
+		{"http://xmlns.com/foaf/0.1/name", "foaf:name", true},
+		{"http://example.org/book1", "ex:book1", true},
+		{"http://example.org/vocab#title", "exv:title", true}, // longest namespace, then lowest prefix
+		{"http://example.org/", "ex:", true},
+		{"http://example.org/a/b", "", false},
+		{"http://example.org/a.", "", false},
+		{"http://example.org/-a", "", false},
+		{"http://unknown.org/a", "", false},
+	}
+	for _, test := range compactTests {
+		curie, ok := p.Compact(test.iri)
+		if curie != test.curie || ok != test.ok {
+			t.Errorf("Prefixes.Compact(%v) == %q, %v; want %q, %v", test.iri, curie, ok, test.curie, test.ok)
+		}
+		if ok {
+			if iri, ok := p.Expand(curie); !ok || iri != test.iri {
+				t.Errorf("Prefixes.Expand(%q) == %v, %v; want %v, true", curie, iri, ok, test.iri)
+			}
+		}
+	}
+
+	for _, curie := range []string{"foaf", "dc:title", ":name"} {
+		if iri, ok := p.Expand(curie); ok {
+			t.Errorf("Prefixes.Expand(%q) == %v, true; want false", curie, iri)
+		}
+	}
+
+	for prefix, want := range map[string]bool{
+		"":      true,
+		"foaf":  true,
+		"a.b-c": true,
+		"ø1":    true,
+		"1a":    false,
+		"_a":    false,
+		"a.":    false,
+		"a:b":   false,
+		"a b":   false,
+	} {
+		if got := ValidPrefix(prefix); got != want {
+			t.Errorf("ValidPrefix(%q) == %v; want %v", prefix, got, want)
+		}
+	}
+}
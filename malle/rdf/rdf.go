@@ -57,7 +57,7 @@ func DecodeTerm(b []byte) (Term, error) {
 	case 0x00: // IRI
 		return IRI(string(b[1:])), nil
 	case 0x01: // rdf:langString
-		if len(b) <= 2 || len(b) < int(b[1])+1 {
+		if len(b) <= 2 || len(b) < 2+int(b[1]) {
 			return nil, ErrUndecodable
 		}
 		if int(b[1]) == 0 {
@@ -65,10 +65,22 @@ func DecodeTerm(b []byte) (Term, error) {
 			// TODO or return ErrUndecodable?
 			return Literal{val: string(b[2:]), datatype: XSDString}, nil
 		}
-		ll := 2 + b[1]
+		ll := 2 + int(b[1])
 		return Literal{val: string(b[ll:]), lang: string(b[2:ll]), datatype: RDFLangString}, nil
 	case 0x02: // xsd:String
 		return Literal{val: string(b[1:]), datatype: XSDString}, nil
+	case 0x03: // xsd:long
+		v, n := binary.Varint(b[1:])
+		if n <= 0 {
+			return nil, ErrUndecodable
+		}
+		return Literal{val: strconv.FormatInt(v, 10), datatype: XSDLong}, nil
+	case 0x04: // xsd:unsignedLong
+		v, n := binary.Uvarint(b[1:])
+		if n <= 0 {
+			return nil, ErrUndecodable
+		}
+		return Literal{val: strconv.FormatUint(v, 10), datatype: XSDUnsignedLong}, nil
 	case 0xFF: // Other typed literals
 		ll := int(b[1])
 		return Literal{val: string(b[2+ll:]), datatype: IRI(string(b[2 : 2+ll]))}, nil
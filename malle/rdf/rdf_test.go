@@ -46,9 +46,9 @@ func TestEncodeDecodeRoundtrip(t *testing.T) {
 		mustNewTypedLiteral("a", XSDString),
 		mustNewLiteral("En litt lengre streng\nmed æøå\tog andre tegn!"),
 		mustNewTypedLiteral("101", mustNewIRI("http://ex.org/binary")),
-		//mustNewLiteral(1),
-		//mustNewLiteral(-4341581235912348234),
-		//mustNewLiteral(uint(33)),
+		mustNewLiteral(1),
+		mustNewLiteral(-4341581235912348234),
+		mustNewLiteral(uint(33)),
 	}
 
 	for _, t1 := range tests {
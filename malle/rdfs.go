@@ -0,0 +1,422 @@
+package malle
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// RDFS entailment
+//
+// When entailment is turned on, the triples entailed by the rdfs:subClassOf,
+// rdfs:subPropertyOf, rdfs:domain and rdfs:range statements of the store
+// (the rules rdfs2, rdfs3, rdfs5, rdfs7, rdfs9 and rdfs11 of RDF Semantics)
+// are materialized in InferredGraph. They are thus found by all queries not
+// restricted to other graphs, but kept apart from the asserted triples, which
+// are those in any other graph.
+//
+// The entailments of a resource only depend on the schema and the asserted
+// triples where the resource is subject or object. When a write transaction
+// is about to commit, the inferred triples with the subject or object of an
+// added or removed triple as subject are brought in line with what is now
+// entailed; inferences no longer supported are retracted. A change to the
+// schema itself brings the entailments of all resources in line.
+
+// InferredGraph is the named graph holding the triples inferred by RDFS
+// entailment. It should not be written to directly.
+const InferredGraph rdf.IRI = "urn:x-malle:inferred"
+
+// metaEntailment is the key in the meta bucket which is set when RDFS
+// entailment is turned on.
+var metaEntailment = []byte("entailment")
+
+// The RDFS vocabulary
+var (
+	rdfsSubClassOf    = rdf.IRI("http://www.w3.org/2000/01/rdf-schema#subClassOf")
+	rdfsSubPropertyOf = rdf.IRI("http://www.w3.org/2000/01/rdf-schema#subPropertyOf")
+	rdfsDomain        = rdf.IRI("http://www.w3.org/2000/01/rdf-schema#domain")
+	rdfsRange         = rdf.IRI("http://www.w3.org/2000/01/rdf-schema#range")
+)
+
+// SetEntailment turns RDFS entailment on or off. Turning it on infers all
+// triples entailed by the stored triples, and keeps them up to date as
+// triples are added and removed. Turning it off removes all inferred
+// triples. The setting is stored in the database.
+//
+// A replica gets the inferred triples of its leader through the change log,
+// so entailment should not be turned on for a replica.
+func (db *Store) SetEntailment(on bool) error {
+	err := db.update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bMeta)
+		if !on {
+			if err := bkt.Delete(metaEntailment); err != nil {
+				return err
+			}
+			id, err := db.getID(tx, InferredGraph)
+			if err == ErrNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			return db.clearGraph(tx, id)
+		}
+		if err := bkt.Put(metaEntailment, []byte{1}); err != nil {
+			return err
+		}
+		r, err := db.newReasoner(tx)
+		if err != nil {
+			return err
+		}
+		return r.syncAll()
+	})
+	if err == nil {
+		db.entail = on
+	}
+	return err
+}
+
+// Entailment returns true if RDFS entailment is turned on.
+func (db *Store) Entailment() bool {
+	return db.entail
+}
+
+// maintainEntailments brings the inferred triples in line with the given
+// changes of asserted triples. Changes of inferred triples are ignored.
+func (db *Store) maintainEntailments(tx *bolt.Tx, changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	r, err := db.newReasoner(tx)
+	if err != nil {
+		return err
+	}
+	affected := roaring.NewRoaringBitmap()
+	for _, c := range changes {
+		if c.Graph == InferredGraph {
+			continue
+		}
+		switch c.Triple.Predicate() {
+		case rdfsSubClassOf, rdfsSubPropertyOf, rdfsDomain, rdfsRange:
+			return r.syncAll()
+		}
+		// The terms of removed triples may be gone.
+		ids := make([]uint32, 3)
+		for i, t := range []rdf.Term{c.Triple.Subject(), c.Triple.Predicate(), c.Triple.Object()} {
+			id, err := db.getID(tx, t)
+			if err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			ids[i] = id
+		}
+		if ids[0] != 0 {
+			affected.Add(ids[0])
+		}
+		if _, ok := c.Triple.Object().(rdf.IRI); ok && ids[2] != 0 && len(r.ranges[ids[1]]) > 0 {
+			affected.Add(ids[2])
+		}
+	}
+	for it := affected.Iterator(); it.HasNext(); {
+		if err := r.sync(it.Next()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reasoner infers the RDFS entailments of resources.
+type reasoner struct {
+	db *Store
+	tx *bolt.Tx
+
+	// IDs of InferredGraph and the vocabulary; 0 if not stored.
+	g, typ, subClassOf, subPropertyOf uint32
+
+	// The schema, from the asserted triples. The super classes and
+	// properties are transitive, and the domains and ranges of a property
+	// include those of its super properties.
+	superClasses map[uint32][]uint32
+	superProps   map[uint32][]uint32
+	domains      map[uint32][]uint32
+	ranges       map[uint32][]uint32
+}
+
+// newReasoner reads the schema from the asserted triples.
+func (db *Store) newReasoner(tx *bolt.Tx) (*reasoner, error) {
+	r := &reasoner{db: db, tx: tx}
+	var err error
+	for _, v := range []struct {
+		id  *uint32
+		iri rdf.IRI
+	}{{&r.g, InferredGraph}, {&r.typ, rdfType}, {&r.subClassOf, rdfsSubClassOf}, {&r.subPropertyOf, rdfsSubPropertyOf}} {
+		if *v.id, err = db.getID(tx, v.iri); err != nil && err != ErrNotFound {
+			return nil, err
+		}
+	}
+
+	var direct [4]map[uint32][]uint32
+	for i, p := range []rdf.IRI{rdfsSubClassOf, rdfsSubPropertyOf, rdfsDomain, rdfsRange} {
+		if direct[i], err = r.assertedPairs(p); err != nil {
+			return nil, err
+		}
+	}
+	r.superClasses = closure(direct[0])
+	r.superProps = closure(direct[1])
+	r.domains = inherit(direct[2], r.superProps)
+	r.ranges = inherit(direct[3], r.superProps)
+	return r, nil
+}
+
+// assertedPairs returns the asserted triples with the given predicate, as
+// a map from subject to objects.
+func (r *reasoner) assertedPairs(p rdf.IRI) (map[uint32][]uint32, error) {
+	pairs := make(map[uint32][]uint32)
+	pID, err := r.db.getID(r.tx, p)
+	if err == ErrNotFound {
+		return pairs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	prefix := u32tob(pID)
+	cur := r.tx.Bucket(bPOS).Cursor()
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+			return nil, err
+		}
+		o := btou32(k[4:])
+		for it := bitmap.Iterator(); it.HasNext(); {
+			s := it.Next()
+			ok, err := r.asserted(s, pID, o)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				pairs[s] = append(pairs[s], o)
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// closure returns the transitive closure of the relation.
+func closure(direct map[uint32][]uint32) map[uint32][]uint32 {
+	res := make(map[uint32][]uint32, len(direct))
+	for start := range direct {
+		seen := make(map[uint32]bool)
+		stack := append([]uint32(nil), direct[start]...)
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			res[start] = append(res[start], n)
+			stack = append(stack, direct[n]...)
+		}
+	}
+	return res
+}
+
+// inherit returns the values of each property, including those of its
+// super properties.
+func inherit(direct, superProps map[uint32][]uint32) map[uint32][]uint32 {
+	res := make(map[uint32][]uint32, len(direct))
+	for p, vals := range direct {
+		res[p] = append(res[p], vals...)
+	}
+	for p, supers := range superProps {
+		for _, q := range supers {
+			if len(direct[q]) > 0 {
+				res[p] = append(res[p], direct[q]...)
+			}
+		}
+	}
+	return res
+}
+
+// asserted checks if the triple is stored in any graph but InferredGraph.
+func (r *reasoner) asserted(s, p, o uint32) (bool, error) {
+	ok, err := r.db.hasIDs(r.tx, s, p, o)
+	if err != nil || !ok {
+		return false, err
+	}
+	bo := r.tx.Bucket(bSPOG).Get(tripleKey(s, p, o))
+	if bo == nil {
+		// In the default graph only.
+		return true, nil
+	}
+	graphs := roaring.NewRoaringBitmap()
+	if _, err := graphs.ReadFrom(bytes.NewReader(bo)); err != nil {
+		return false, err
+	}
+	return r.g == 0 || graphs.GetCardinality() > 1 || !graphs.Contains(r.g), nil
+}
+
+// entailed returns the triples with the resource as subject which are
+// entailed by the schema and the asserted triples of the resource, as
+// predicate and object pairs. Asserted triples may be included.
+func (r *reasoner) entailed(x uint32) (map[[2]uint32]bool, error) {
+	res := make(map[[2]uint32]bool)
+	types := make(map[uint32]bool)
+	err := r.db.subjectTriples(r.tx, x, func(p, o uint32) error {
+		ok, err := r.asserted(x, p, o)
+		if err != nil || !ok {
+			return err
+		}
+		for _, q := range r.superProps[p] {
+			res[[2]uint32{q, o}] = true
+		}
+		for _, c := range r.domains[p] {
+			types[c] = true
+		}
+		if p == r.typ && r.typ != 0 {
+			types[o] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the incoming triples of properties with a range entail
+	// anything, so there is no need to visit all of them.
+	for p, classes := range r.ranges {
+		bo := r.tx.Bucket(bPOS).Get(compositeKey(p, x))
+		if bo == nil {
+			continue
+		}
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+			return nil, err
+		}
+		for it := bitmap.Iterator(); it.HasNext(); {
+			ok, err := r.asserted(it.Next(), p, x)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				for _, c := range classes {
+					types[c] = true
+				}
+				break
+			}
+		}
+	}
+
+	for c := range types {
+		for _, d := range r.superClasses[c] {
+			types[d] = true
+		}
+	}
+	if len(types) > 0 && r.typ == 0 {
+		id, err := r.db.addTerm(r.tx, rdfType)
+		if err != nil {
+			return nil, err
+		}
+		r.typ = id
+	}
+	for c := range types {
+		res[[2]uint32{r.typ, c}] = true
+	}
+	for _, d := range r.superClasses[x] {
+		res[[2]uint32{r.subClassOf, d}] = true
+	}
+	for _, q := range r.superProps[x] {
+		res[[2]uint32{r.subPropertyOf, q}] = true
+	}
+	return res, nil
+}
+
+// sync brings the inferred triples with the resource as subject in line
+// with its entailments.
+func (r *reasoner) sync(x uint32) error {
+	if _, err := r.db.getTerm(r.tx, x); err == ErrNotFound {
+		// Removed along with its last triple.
+		return nil
+	}
+	want, err := r.entailed(x)
+	if err != nil {
+		return err
+	}
+	for po := range want {
+		ok, err := r.asserted(x, po[0], po[1])
+		if err != nil {
+			return err
+		}
+		if ok {
+			delete(want, po)
+		}
+	}
+
+	var stale [][2]uint32
+	prefix := tripleKey(r.g, x, 0)[:8]
+	cur := r.tx.Bucket(bGSPO).Cursor()
+	for k, v := cur.Seek(prefix); r.g != 0 && k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+			return err
+		}
+		p := btou32(k[8:])
+		for it := bitmap.Iterator(); it.HasNext(); {
+			po := [2]uint32{p, it.Next()}
+			if want[po] {
+				delete(want, po)
+			} else {
+				stale = append(stale, po)
+			}
+		}
+	}
+
+	for _, po := range stale {
+		if err := r.db.removeFromGraph(r.tx, x, po[0], po[1], r.g); err != nil {
+			return err
+		}
+	}
+	if len(want) > 0 && r.g == 0 {
+		if r.g, err = r.db.addTerm(r.tx, InferredGraph); err != nil {
+			return err
+		}
+	}
+	for po := range want {
+		if err := r.db.storeInGraph(r.tx, x, po[0], po[1], r.g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncAll brings the inferred triples of all resources in line with their
+// entailments.
+func (r *reasoner) syncAll() error {
+	// The resources are collected first, since the indices cannot be
+	// modified while iterating over them.
+	resources := roaring.NewRoaringBitmap()
+	err := r.tx.Bucket(bSPO).ForEach(func(k, v []byte) error {
+		resources.Add(btou32(k))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Objects need only be visited for the properties with a range.
+	for p := range r.ranges {
+		prefix := u32tob(p)
+		cur := r.tx.Bucket(bPOS).Cursor()
+		for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+			if b := r.tx.Bucket(bTerms).Get(k[4:]); b != nil && b[0] == 0x00 {
+				resources.Add(btou32(k[4:]))
+			}
+		}
+	}
+	for it := resources.Iterator(); it.HasNext(); {
+		if err := r.sync(it.Next()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
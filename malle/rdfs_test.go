@@ -0,0 +1,166 @@
+package malle
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestEntailment(t *testing.T) {
+	const file = "_rdfs.db"
+	db, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer func() { db.Close() }()
+
+	var (
+		person     = mustNewIRI("http://ex.org/Person")
+		corp       = mustNewIRI("http://ex.org/Corporation")
+		agent      = mustNewIRI("http://ex.org/Agent")
+		thing      = mustNewIRI("http://ex.org/Thing")
+		author     = mustNewIRI("http://ex.org/author")
+		creator    = mustNewIRI("http://ex.org/creator")
+		employs    = mustNewIRI("http://ex.org/employs")
+		hamsun     = mustNewIRI("http://ex.org/hamsun")
+		undset     = mustNewIRI("http://ex.org/undset")
+		gyldendal  = mustNewIRI("http://ex.org/gyldendal")
+		work       = mustNewIRI("http://ex.org/work")
+		subClassOf = rdfsSubClassOf
+	)
+	add := func(trs ...rdf.Triple) {
+		for _, tr := range trs {
+			if err := db.AddTriple(tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	remove := func(trs ...rdf.Triple) {
+		for _, tr := range trs {
+			if err := db.RemoveTriple(tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	instances := func(class rdf.IRI) string {
+		res, err := db.Select(NewBGP().Where(Variable("x"), rdfType, class))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for _, b := range res {
+			s = append(s, b["x"].String())
+		}
+		sort.Strings(s)
+		return strings.Join(s, " ")
+	}
+
+	// Data and schema stored before entailment is turned on.
+	add(
+		rdf.NewTriple(person, subClassOf, agent),
+		rdf.NewTriple(agent, subClassOf, thing),
+		rdf.NewTriple(hamsun, rdfType, person),
+	)
+	if got := instances(agent); got != "" {
+		t.Errorf("instances of %v without entailment == %v; want none", agent, got)
+	}
+	if err := db.SetEntailment(true); err != nil {
+		t.Fatal(err)
+	}
+	add(
+		rdf.NewTriple(corp, subClassOf, agent),
+		rdf.NewTriple(author, rdfsSubPropertyOf, creator),
+		rdf.NewTriple(creator, rdfsRange, agent),
+		rdf.NewTriple(employs, rdfsDomain, corp),
+		rdf.NewTriple(gyldendal, employs, hamsun),
+		rdf.NewTriple(work, author, undset),
+	)
+
+	tests := []struct {
+		class rdf.IRI
+		want  string
+	}{
+		{person, "<http://ex.org/hamsun>"},
+		{corp, "<http://ex.org/gyldendal>"},
+		{agent, "<http://ex.org/gyldendal> <http://ex.org/hamsun> <http://ex.org/undset>"},
+		{thing, "<http://ex.org/gyldendal> <http://ex.org/hamsun> <http://ex.org/undset>"},
+	}
+	for _, test := range tests {
+		if got := instances(test.class); got != test.want {
+			t.Errorf("instances of %v == %v; want %v", test.class, got, test.want)
+		}
+	}
+	for _, tr := range []rdf.Triple{
+		rdf.NewTriple(work, creator, undset),
+		rdf.NewTriple(person, subClassOf, thing),
+	} {
+		if ok, err := db.HasTriple(tr, InferredGraph); err != nil || !ok {
+			t.Errorf("Store.HasTriple(%v, InferredGraph) == %v, %v; want true", tr, ok, err)
+		}
+		if ok, err := db.HasTriple(tr, DefaultGraph); err != nil || ok {
+			t.Errorf("Store.HasTriple(%v, DefaultGraph) == %v, %v; want false", tr, ok, err)
+		}
+	}
+
+	// Asserting an inferred triple moves it out of the inferred graph.
+	add(rdf.NewTriple(hamsun, rdfType, agent))
+	if ok, _ := db.HasTriple(rdf.NewTriple(hamsun, rdfType, agent), InferredGraph); ok {
+		t.Errorf("asserted triple still in the inferred graph")
+	}
+
+	// Removing asserted triples retracts what depends on them, but not
+	// what is asserted or inferred otherwise.
+	remove(
+		rdf.NewTriple(work, author, undset),
+		rdf.NewTriple(hamsun, rdfType, person),
+	)
+	if got, want := instances(agent), "<http://ex.org/gyldendal> <http://ex.org/hamsun>"; got != want {
+		t.Errorf("instances of %v after removal == %v; want %v", agent, got, want)
+	}
+	if got := instances(person); got != "" {
+		t.Errorf("instances of %v after removal == %v; want none", person, got)
+	}
+	if ok, _ := db.HasTriple(rdf.NewTriple(work, creator, undset)); ok {
+		t.Errorf("inferred triple not retracted when the triple it depends on is removed")
+	}
+
+	// Changing the schema.
+	remove(rdf.NewTriple(corp, subClassOf, agent))
+	if got, want := instances(thing), "<http://ex.org/hamsun>"; got != want {
+		t.Errorf("instances of %v after schema change == %v; want %v", thing, got, want)
+	}
+
+	if problems, err := db.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() == %v, %v; want no problems", problems, err)
+	}
+
+	// The setting is stored.
+	db.Close()
+	if db, err = Init(file); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Entailment() {
+		t.Errorf("Store.Entailment() after reopening == false; want true")
+	}
+
+	if err := db.SetEntailment(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := instances(corp); got != "" {
+		t.Errorf("instances of %v after turning off entailment == %v; want none", corp, got)
+	}
+	graphs, err := db.ListGraphs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graphs) != 0 {
+		t.Errorf("Store.ListGraphs() after turning off entailment == %v; want none", graphs)
+	}
+	if got := instances(agent); got != "<http://ex.org/hamsun>" {
+		t.Errorf("instances of %v after turning off entailment == %v; want only the asserted <http://ex.org/hamsun>", agent, got)
+	}
+}
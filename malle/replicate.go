@@ -0,0 +1,81 @@
+package malle
+
+import (
+	"errors"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// metaReplicated is the key in the meta bucket holding the sequence number
+// of the last change applied by ApplyChanges.
+var metaReplicated = []byte("replicated")
+
+// ErrOutOfOrder is returned when applying changes which do not follow
+// directly after the last change applied.
+var ErrOutOfOrder = errors.New("changes missing or out of order")
+
+// ApplyChanges applies changes pulled from the change log of another store,
+// in order and in a single transaction. It records the sequence number of
+// the last change, so that replication can resume from Replicated. Changes
+// allready applied are skipped, and none are applied if any are missing.
+//
+// The changes of the other store include those of its RDFS entailments, so
+// the entailments are not maintained while applying them, even if turned on
+// in this store, as when restored from a backup of the other store.
+func (db *Store) ApplyChanges(changes []Change) error {
+	return db.updateTx(func(tx *bolt.Tx) error {
+		last, err := db.replicated(tx)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if c.Seq <= last {
+				continue
+			}
+			if c.Seq != last+1 {
+				return ErrOutOfOrder
+			}
+			var graphs []rdf.IRI
+			if c.Graph != DefaultGraph {
+				graphs = []rdf.IRI{c.Graph}
+			}
+			switch c.Op {
+			case ChangeAdd:
+				err = db.addTriple(tx, c.Triple, graphs)
+			case ChangeRemove:
+				err = db.removeTripleFrom(tx, c.Triple, graphs)
+			default:
+				err = errCorruptChange
+			}
+			if err != nil {
+				return err
+			}
+			last = c.Seq
+		}
+		return tx.Bucket(bMeta).Put(metaReplicated, u64tob(last))
+	}, false)
+}
+
+// Replicated returns the sequence number of the last change applied with
+// ApplyChanges. If none have been applied, it is the sequence number of the
+// last change in the store's own log; a store restored from a backup of
+// another store will thus resume replication from when the backup was taken.
+func (db *Store) Replicated() (seq uint64, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		seq, err = db.replicated(tx)
+		return err
+	})
+	return seq, err
+}
+
+// replicated works like the exported Replicated, but using the given transaction.
+func (db *Store) replicated(tx *bolt.Tx) (uint64, error) {
+	if v := tx.Bucket(bMeta).Get(metaReplicated); v != nil {
+		return btou64(v), nil
+	}
+	if k, _ := tx.Bucket(bLog).Cursor().Last(); k != nil {
+		return btou64(k), nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,116 @@
+package malle
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestApplyChanges(t *testing.T) {
+	const file = "_follower.db"
+	follower, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer follower.Close()
+
+	tr1 := rdf.NewTriple("rep1", "repp", mustNewLiteral("one"))
+	tr2 := rdf.NewTriple("rep1", "repp", mustNewIRI("rep2"))
+	changes := []Change{
+		{Seq: 1, Op: ChangeAdd, Triple: tr1},
+		{Seq: 2, Op: ChangeAdd, Triple: tr2, Graph: "repg"},
+		{Seq: 3, Op: ChangeRemove, Triple: tr1},
+	}
+
+	if err := follower.ApplyChanges(changes[:2]); err != nil {
+		t.Fatalf("Store.ApplyChanges() == %v; want no error", err)
+	}
+	if seq, err := follower.Replicated(); err != nil || seq != 2 {
+		t.Errorf("Store.Replicated() == %d, %v; want 2, <nil>", seq, err)
+	}
+
+	// Overlapping batches are fine, gaps are not.
+	if err := follower.ApplyChanges([]Change{{Seq: 5, Op: ChangeAdd, Triple: tr1}}); err != ErrOutOfOrder {
+		t.Errorf("Store.ApplyChanges() with missing changes == %v; want ErrOutOfOrder", err)
+	}
+	if err := follower.ApplyChanges(changes); err != nil {
+		t.Fatalf("Store.ApplyChanges() == %v; want no error", err)
+	}
+	if seq, _ := follower.Replicated(); seq != 3 {
+		t.Errorf("Store.Replicated() == %d; want 3", seq)
+	}
+
+	if ok, _ := follower.HasTriple(tr1); ok {
+		t.Errorf("replicated removal of %v not applied", tr1)
+	}
+	if ok, _ := follower.HasTriple(tr2, "repg"); !ok {
+		t.Errorf("replicated addition of %v to named graph not applied", tr2)
+	}
+}
+
+func TestApplyChangesWithEntailment(t *testing.T) {
+	const leaderFile, followerFile = "_leader_rdfs.db", "_follower_rdfs.db"
+	leader, err := Init(leaderFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(leaderFile)
+	defer leader.Close()
+	follower, err := Init(followerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(followerFile)
+	defer follower.Close()
+
+	// Both have entailment on, as a follower restored from a backup of
+	// its leader.
+	for _, db := range []*Store{leader, follower} {
+		if err := db.SetEntailment(true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since, err := leader.Replicated()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asserted := rdf.NewTriple("repHamsun", rdfType, mustNewIRI("RepPerson"))
+	inferred := rdf.NewTriple("repHamsun", rdfType, mustNewIRI("RepAgent"))
+	if err := leader.AddTriple(rdf.NewTriple("RepPerson", rdfsSubClassOf, mustNewIRI("RepAgent"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.AddTriple(asserted); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.RemoveTriple(asserted); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := leader.Changes(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Apply the changes in batches ending right after the removal of the
+	// asserted triple, before the removal of the inferred one.
+	i := len(changes)
+	for i > 0 && !(changes[i-1].Op == ChangeRemove && changes[i-1].Triple.Eq(asserted)) {
+		i--
+	}
+	if i == 0 || i == len(changes) {
+		t.Fatalf("Store.Changes() == %v; want removal of %v followed by removal of %v", changes, asserted, inferred)
+	}
+	for _, batch := range [][]Change{changes[:i], changes[i:]} {
+		if err := follower.ApplyChanges(batch); err != nil {
+			t.Fatalf("Store.ApplyChanges(%v) == %v; want no error", batch, err)
+		}
+	}
+	if ok, _ := follower.HasTriple(inferred, InferredGraph); ok {
+		t.Errorf("replicated removal of %v not applied", inferred)
+	}
+	if problems, err := follower.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() on follower == %v, %v; want no problems", problems, err)
+	}
+}
@@ -0,0 +1,369 @@
+package malle
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// owl:sameAs equivalence
+//
+// When sameAs equivalence is turned on, IRIs linked by owl:sameAs, in either
+// direction and transitively, are kept as clusters in a union-find structure
+// over their term IDs. The clusters are flattened, so every member points
+// directly to the canonical member of its cluster, and the canonical member
+// holds a bitmap of all members. Merging two clusters relabels the members of
+// the smaller one. Removing an owl:sameAs triple rebuilds the clusters of the
+// members of its cluster from their remaining owl:sameAs triples.
+//
+// The canonical member of a merged cluster is that of the larger cluster, and
+// it stays canonical when a cluster is split; it can be changed with
+// SetCanonical.
+
+// owlSameAs is the predicate linking IRIs denoting the same resource.
+var owlSameAs = rdf.IRI("http://www.w3.org/2002/07/owl#sameAs")
+
+// metaSameAs is the key in the meta bucket which is set when sameAs
+// equivalence is turned on.
+var metaSameAs = []byte("sameas")
+
+// SetSameAs turns owl:sameAs equivalence on or off. Turning it on builds the
+// clusters of IRIs linked by owl:sameAs, and keeps them up to date as triples
+// are added and removed. The setting is stored in the database.
+func (db *Store) SetSameAs(on bool) error {
+	err := db.update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bSame, bCluster} {
+			if err := tx.DeleteBucket(b); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(b); err != nil {
+				return err
+			}
+		}
+		if !on {
+			return tx.Bucket(bMeta).Delete(metaSameAs)
+		}
+		if err := tx.Bucket(bMeta).Put(metaSameAs, []byte{1}); err != nil {
+			return err
+		}
+		return db.linkAllSameAs(tx)
+	})
+	if err == nil {
+		db.sameAs = on
+	}
+	return err
+}
+
+// SameAs returns true if owl:sameAs equivalence is turned on.
+func (db *Store) SameAs() bool {
+	return db.sameAs
+}
+
+// Canonical returns the canonical IRI of the owl:sameAs cluster of the given
+// IRI, or the IRI itself if it is not linked to any other IRI.
+func (db *Store) Canonical(iri rdf.IRI) (canonical rdf.IRI, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		canonical, err = db.canonicalIRI(tx, iri, make(map[rdf.IRI]rdf.IRI))
+		return err
+	})
+	return canonical, err
+}
+
+// SameAsCluster returns the IRIs of the owl:sameAs cluster of the given IRI,
+// starting with the canonical IRI. An IRI which is not linked to any other IRI
+// is alone in its cluster.
+func (db *Store) SameAsCluster(iri rdf.IRI) (cluster []rdf.IRI, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		id, err := db.getID(tx, iri)
+		if err == ErrNotFound {
+			cluster = []rdf.IRI{iri}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		c := db.canonicalID(tx, id)
+		members, err := db.clusterMembers(tx, id)
+		if err != nil {
+			return err
+		}
+		for _, m := range append([]uint32{c}, members...) {
+			if m == c && len(cluster) > 0 {
+				continue
+			}
+			t, err := db.getTerm(tx, m)
+			if err != nil {
+				return err
+			}
+			cluster = append(cluster, t.(rdf.IRI))
+		}
+		return nil
+	})
+	return cluster, err
+}
+
+// SetCanonical makes the IRI the canonical IRI of its owl:sameAs cluster. It
+// returns ErrNotFound if the IRI is not linked to any other IRI.
+func (db *Store) SetCanonical(iri rdf.IRI) error {
+	return db.update(func(tx *bolt.Tx) error {
+		id, err := db.getID(tx, iri)
+		if err != nil {
+			return err
+		}
+		if tx.Bucket(bSame).Get(u32tob(id)) == nil {
+			return ErrNotFound
+		}
+		return db.setCanonical(tx, id)
+	})
+}
+
+// SameAs makes the query treat IRIs linked by owl:sameAs as one resource,
+// when sameAs equivalence is turned on. The descriptions of all the IRIs in
+// the cluster of a described IRI are merged, and every IRI of a cluster is
+// replaced by the canonical IRI, except in the objects of owl:sameAs triples,
+// which thus list the other IRIs of the cluster.
+func (q *Query) SameAs() *Query {
+	q.sameAs = true
+	return q
+}
+
+// canonicalID returns the ID of the canonical member of the cluster of the
+// term with the given ID.
+func (db *Store) canonicalID(tx *bolt.Tx, id uint32) uint32 {
+	if v := tx.Bucket(bSame).Get(u32tob(id)); v != nil {
+		return btou32(v)
+	}
+	return id
+}
+
+// canonicalIRI returns the canonical IRI of the cluster of the IRI. The
+// results are cached.
+func (db *Store) canonicalIRI(tx *bolt.Tx, iri rdf.IRI, cache map[rdf.IRI]rdf.IRI) (rdf.IRI, error) {
+	if c, ok := cache[iri]; ok {
+		return c, nil
+	}
+	c := iri
+	id, err := db.getID(tx, iri)
+	if err == ErrNotFound {
+		cache[iri] = c
+		return c, nil
+	} else if err != nil {
+		return iri, err
+	}
+	if cID := db.canonicalID(tx, id); cID != id {
+		t, err := db.getTerm(tx, cID)
+		if err != nil {
+			return iri, err
+		}
+		c = t.(rdf.IRI)
+	}
+	cache[iri] = c
+	return c, nil
+}
+
+// clusterMembers returns the IDs of the members of the cluster of the term
+// with the given ID, which is alone in its cluster if not linked to any other.
+func (db *Store) clusterMembers(tx *bolt.Tx, id uint32) ([]uint32, error) {
+	bo := tx.Bucket(bCluster).Get(u32tob(db.canonicalID(tx, id)))
+	if bo == nil {
+		return []uint32{id}, nil
+	}
+	bitmap := roaring.NewRoaringBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+		return nil, err
+	}
+	var members []uint32
+	for it := bitmap.Iterator(); it.HasNext(); {
+		members = append(members, it.Next())
+	}
+	return members, nil
+}
+
+// putCluster stores the members of the cluster with the given canonical ID,
+// and points the relabeled members to it.
+func (db *Store) putCluster(tx *bolt.Tx, c uint32, members, relabel []uint32) error {
+	bkt := tx.Bucket(bSame)
+	for _, m := range relabel {
+		if err := bkt.Put(u32tob(m), u32tob(c)); err != nil {
+			return err
+		}
+	}
+	bitmap := roaring.NewRoaringBitmap()
+	for _, m := range members {
+		bitmap.Add(m)
+	}
+	var b bytes.Buffer
+	if _, err := bitmap.WriteTo(&b); err != nil {
+		return err
+	}
+	return tx.Bucket(bCluster).Put(u32tob(c), b.Bytes())
+}
+
+// union merges the clusters of the terms with the given IDs.
+func (db *Store) union(tx *bolt.Tx, a, b uint32) error {
+	ca, cb := db.canonicalID(tx, a), db.canonicalID(tx, b)
+	if ca == cb {
+		return nil
+	}
+	ma, err := db.clusterMembers(tx, a)
+	if err != nil {
+		return err
+	}
+	mb, err := db.clusterMembers(tx, b)
+	if err != nil {
+		return err
+	}
+	if len(ma) < len(mb) || (len(ma) == len(mb) && cb < ca) {
+		ca, cb, ma, mb = cb, ca, mb, ma
+	}
+	if err := tx.Bucket(bCluster).Delete(u32tob(cb)); err != nil {
+		return err
+	}
+	relabel := mb
+	if len(ma) == 1 {
+		// Not linked before, so it has no entry.
+		relabel = append(relabel, ca)
+	}
+	return db.putCluster(tx, ca, append(ma, mb...), relabel)
+}
+
+// setCanonical makes the term with the given ID the canonical member of its
+// cluster.
+func (db *Store) setCanonical(tx *bolt.Tx, id uint32) error {
+	c := db.canonicalID(tx, id)
+	if c == id {
+		return nil
+	}
+	members, err := db.clusterMembers(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(bCluster).Delete(u32tob(c)); err != nil {
+		return err
+	}
+	return db.putCluster(tx, id, members, members)
+}
+
+// linkSameAs merges the clusters of the subjects and objects of the
+// owl:sameAs triples among the given triples.
+func (db *Store) linkSameAs(tx *bolt.Tx, triples [][3]uint32) error {
+	p, err := db.getID(tx, owlSameAs)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, tr := range triples {
+		if tr[1] != p || tr[0] == tr[2] || !db.isIRI(tx, tr[2]) {
+			continue
+		}
+		if err := db.union(tx, tr[0], tr[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unlinkSameAs splits the cluster of the subject and object of the removed
+// triple, if it is an owl:sameAs triple. The triple must be removed from the
+// indices, but its terms still be stored.
+func (db *Store) unlinkSameAs(tx *bolt.Tx, s, p, o uint32) error {
+	c := db.canonicalID(tx, s)
+	if c != db.canonicalID(tx, o) || s == o {
+		return nil
+	}
+	if id, err := db.getID(tx, owlSameAs); err != nil || id != p {
+		return nil
+	}
+	members, err := db.clusterMembers(tx, s)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if err := tx.Bucket(bSame).Delete(u32tob(m)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(bCluster).Delete(u32tob(c)); err != nil {
+		return err
+	}
+
+	var links [][3]uint32
+	for _, m := range members {
+		bo := tx.Bucket(bSPO).Get(compositeKey(m, p))
+		if bo == nil {
+			continue
+		}
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+			return err
+		}
+		for it := bitmap.Iterator(); it.HasNext(); {
+			links = append(links, [3]uint32{m, p, it.Next()})
+		}
+	}
+	if err := db.linkSameAs(tx, links); err != nil {
+		return err
+	}
+	if tx.Bucket(bSame).Get(u32tob(c)) != nil {
+		// The canonical member stays canonical of its part.
+		return db.setCanonical(tx, c)
+	}
+	return nil
+}
+
+// linkAllSameAs builds the clusters from all owl:sameAs triples.
+func (db *Store) linkAllSameAs(tx *bolt.Tx) error {
+	p, err := db.getID(tx, owlSameAs)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var links [][3]uint32
+	prefix := u32tob(p)
+	cur := tx.Bucket(bPOS).Cursor()
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+			return err
+		}
+		for it := bitmap.Iterator(); it.HasNext(); {
+			links = append(links, [3]uint32{it.Next(), p, btou32(k[4:])})
+		}
+	}
+	return db.linkSameAs(tx, links)
+}
+
+// isIRI checks if the term with the given ID is an IRI.
+func (db *Store) isIRI(tx *bolt.Tx, id uint32) bool {
+	b := tx.Bucket(bTerms).Get(u32tob(id))
+	return len(b) > 0 && b[0] == 0x00
+}
+
+// canonicalGraph returns the graph with every IRI replaced by the canonical
+// IRI of its cluster, except objects of owl:sameAs triples. Triples stating
+// that an IRI is the same as itself are left out.
+func (db *Store) canonicalGraph(tx *bolt.Tx, g rdf.Graph) (rdf.Graph, error) {
+	cache := make(map[rdf.IRI]rdf.IRI)
+	res := rdf.NewGraph()
+	for _, tr := range g.Triples() {
+		s, err := db.canonicalIRI(tx, tr.Subject(), cache)
+		if err != nil {
+			return nil, err
+		}
+		o := tr.Object()
+		if iri, ok := o.(rdf.IRI); ok {
+			if tr.Predicate() == owlSameAs {
+				if iri == s {
+					continue
+				}
+			} else if o, err = db.canonicalIRI(tx, iri, cache); err != nil {
+				return nil, err
+			}
+		}
+		res.Add(rdf.NewTriple(s, tr.Predicate(), o))
+	}
+	return res, nil
+}
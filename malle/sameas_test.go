@@ -0,0 +1,159 @@
+package malle
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestSameAs(t *testing.T) {
+	const file = "_sameas.db"
+	db, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer func() { db.Close() }()
+
+	var (
+		a1      = mustNewIRI("http://ex.org/a1")
+		a2      = mustNewIRI("http://ex.org/a2")
+		a3      = mustNewIRI("http://ex.org/a3")
+		a4      = mustNewIRI("http://ex.org/a4")
+		name    = mustNewIRI("http://ex.org/name")
+		born    = mustNewIRI("http://ex.org/born")
+		died    = mustNewIRI("http://ex.org/died")
+		creator = mustNewIRI("http://ex.org/creator")
+		work    = mustNewIRI("http://ex.org/work")
+	)
+	for _, tr := range []rdf.Triple{
+		rdf.NewTriple(a1, name, mustNewLiteral("Knut Hamsun")),
+		rdf.NewTriple(a2, born, mustNewLiteral(1859)),
+		rdf.NewTriple(a3, died, mustNewLiteral(1952)),
+		rdf.NewTriple(work, creator, a2),
+		rdf.NewTriple(a1, owlSameAs, a2),
+	} {
+		if err := db.AddTriple(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetSameAs(true); err != nil {
+		t.Fatal(err)
+	}
+	g := rdf.NewGraph()
+	g.Add(rdf.NewTriple(a2, owlSameAs, a3))
+	if err := db.BulkImportGraph(g); err != nil {
+		t.Fatal(err)
+	}
+
+	canonical := func(iri, want rdf.IRI) {
+		if got, err := db.Canonical(iri); err != nil || got != want {
+			t.Errorf("Store.Canonical(%v) == %v, %v; want %v", iri, got, err, want)
+		}
+	}
+	for _, iri := range []rdf.IRI{a1, a2, a3} {
+		canonical(iri, a1)
+	}
+	canonical(a4, a4)
+	if cluster, err := db.SameAsCluster(a3); err != nil || len(cluster) != 3 || cluster[0] != a1 {
+		t.Errorf("Store.SameAsCluster(%v) == %v, %v; want 3 IRIs, starting with %v", a3, cluster, err, a1)
+	}
+
+	res, _, err := db.Query(NewQuery().Resource(a3).SameAs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rdf.NewGraph()
+	for _, tr := range []rdf.Triple{
+		rdf.NewTriple(a1, name, mustNewLiteral("Knut Hamsun")),
+		rdf.NewTriple(a1, born, mustNewLiteral(1859)),
+		rdf.NewTriple(a1, died, mustNewLiteral(1952)),
+		rdf.NewTriple(a1, owlSameAs, a2),
+		rdf.NewTriple(a1, owlSameAs, a3),
+	} {
+		want.Add(tr)
+	}
+	if !res.Eq(want) {
+		t.Errorf("Store.Query(Resource(%v).SameAs()) == %v; want %v", a3, res.Triples(), want.Triples())
+	}
+	res, _, err = db.Query(NewQuery().CBD(a3, 0).SameAs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objs := res[work][creator]; len(objs) != 1 || !objs[0].Eq(a1) {
+		t.Errorf("Store.Query(CBD(%v, 0).SameAs()) has %v %v %v; want %v", a3, work, creator, objs, a1)
+	}
+	res, _, err = db.Query(NewQuery().Resource(a3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || len(res[a3]) != 1 {
+		t.Errorf("Store.Query(Resource(%v)) without SameAs == %v; want only the triples of %v", a3, res.Triples(), a3)
+	}
+
+	// Choosing the canonical IRI.
+	if err := db.SetCanonical(a3); err != nil {
+		t.Fatal(err)
+	}
+	canonical(a1, a3)
+	if err := db.SetCanonical(a4); err != ErrNotFound {
+		t.Errorf("Store.SetCanonical(%v) == %v; want ErrNotFound", a4, err)
+	}
+
+	// Removing links splits the cluster.
+	if err := db.RemoveTriple(rdf.NewTriple(a2, owlSameAs, a3)); err != nil {
+		t.Fatal(err)
+	}
+	canonical(a3, a3)
+	canonical(a2, a1)
+	if err := db.RemoveTriple(rdf.NewTriple(a1, owlSameAs, a2)); err != nil {
+		t.Fatal(err)
+	}
+	for _, iri := range []rdf.IRI{a1, a2, a3} {
+		if cluster, err := db.SameAsCluster(iri); err != nil || len(cluster) != 1 {
+			t.Errorf("Store.SameAsCluster(%v) after unlinking == %v, %v; want only %v", iri, cluster, err, iri)
+		}
+	}
+	if problems, err := db.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() == %v, %v; want no problems", problems, err)
+	}
+
+	// The setting is stored.
+	db.Close()
+	if db, err = Init(file); err != nil {
+		t.Fatal(err)
+	}
+	if !db.SameAs() {
+		t.Errorf("Store.SameAs() after reopening == false; want true")
+	}
+	if err := db.AddTriple(rdf.NewTriple(a3, owlSameAs, a4)); err != nil {
+		t.Fatal(err)
+	}
+	canonical(a4, a3)
+
+	// Check and Repair the clusters.
+	err = db.kv.Update(func(tx *bolt.Tx) error {
+		id, _ := db.getID(tx, a4)
+		return tx.Bucket(bSame).Delete(u32tob(id))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := db.Check(); err != nil || len(problems) == 0 {
+		t.Errorf("Store.Check() with a broken cluster == %v, %v; want problems", problems, err)
+	}
+	if _, err := db.Repair(); err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := db.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() after Store.Repair() == %v, %v; want no problems", problems, err)
+	}
+	canonical(a4, a3)
+
+	if err := db.SetSameAs(false); err != nil {
+		t.Fatal(err)
+	}
+	canonical(a4, a4)
+}
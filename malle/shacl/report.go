@@ -0,0 +1,90 @@
+package shacl
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+// ReportIRI is the IRI of the report node in the graph of a validation
+// report. The results are numbered after it: <urn:x-malle:shacl:report:1> etc.
+const ReportIRI rdf.IRI = "urn:x-malle:shacl:report"
+
+// Report is the result of validating data against shapes.
+type Report struct {
+	// Conforms is true if there are no validation results, of any severity.
+	Conforms bool
+	Results  []Result
+}
+
+// Result describes a focus node which does not conform to a constraint of
+// a shape.
+type Result struct {
+	FocusNode rdf.Term
+	Path      rdf.IRI  // path of a property shape; empty for a node shape
+	Value     rdf.Term // value node not conforming; nil for sh:minCount and sh:maxCount
+	Shape     rdf.IRI
+	Component rdf.IRI // constraint component, ex sh:MinCountConstraintComponent
+	Severity  rdf.IRI
+	Messages  rdf.Terms // sh:message of the shape
+}
+
+// String returns a single line description of the result, with the
+// messages of the shape if it has any.
+func (r Result) String() string {
+	var b bytes.Buffer
+	b.WriteString(r.FocusNode.String())
+	if r.Path != "" {
+		fmt.Fprintf(&b, " %v", r.Path)
+	}
+	if r.Value != nil {
+		fmt.Fprintf(&b, " %v", r.Value)
+	}
+	fmt.Fprintf(&b, ": %v of shape %v", r.Component, r.Shape)
+	for _, m := range r.Messages {
+		fmt.Fprintf(&b, ": %v", m.Value())
+	}
+	return b.String()
+}
+
+// Error returns a description of the first validation result, so that a
+// report which does not conform can be returned as an error.
+func (r *Report) Error() string {
+	switch len(r.Results) {
+	case 0:
+		return "shacl: conforms"
+	case 1:
+		return "shacl: " + r.Results[0].String()
+	default:
+		return fmt.Sprintf("shacl: %v (and %d more results)", r.Results[0], len(r.Results)-1)
+	}
+}
+
+// Graph returns the report as a SHACL validation report graph.
+func (r *Report) Graph() rdf.Graph {
+	g := rdf.NewGraph()
+	conforms, _ := rdf.NewTypedLiteral(strconv.FormatBool(r.Conforms), rdf.XSDBoolean)
+	g.Add(rdf.NewTriple(ReportIRI, rdfType, shValidationReport))
+	g.Add(rdf.NewTriple(ReportIRI, shConforms, conforms))
+	for i, res := range r.Results {
+		node := rdf.IRI(fmt.Sprintf("%s:%d", string(ReportIRI), i+1))
+		g.Add(rdf.NewTriple(ReportIRI, shResult, node))
+		g.Add(rdf.NewTriple(node, rdfType, shValidationResult))
+		g.Add(rdf.NewTriple(node, shFocusNode, res.FocusNode))
+		if res.Path != "" {
+			g.Add(rdf.NewTriple(node, shResultPath, res.Path))
+		}
+		if res.Value != nil {
+			g.Add(rdf.NewTriple(node, shValue, res.Value))
+		}
+		g.Add(rdf.NewTriple(node, shSourceShape, res.Shape))
+		g.Add(rdf.NewTriple(node, shSourceConstraint, res.Component))
+		g.Add(rdf.NewTriple(node, shResultSeverity, res.Severity))
+		for _, m := range res.Messages {
+			g.Add(rdf.NewTriple(node, shResultMessage, m))
+		}
+	}
+	return g
+}
@@ -0,0 +1,329 @@
+// Package shacl implements a subset of SHACL Core, the Shapes Constraint
+// Language, validating graphs and resources in a malle triple store.
+//
+// Supported are node and property shapes with the targets sh:targetNode,
+// sh:targetClass, sh:targetSubjectsOf and sh:targetObjectsOf, and the
+// constraints sh:property, sh:minCount, sh:maxCount, sh:datatype, sh:class,
+// sh:pattern (with sh:flags) and sh:in. The severity and messages of results
+// are taken from sh:severity and sh:message, and shapes with sh:deactivated
+// true are ignored.
+//
+// Notes and (possible) deviations from W3 specification:
+//   - Blank nodes are not supported, since rdf.Graph cannot hold them. Property
+//     shapes and the nodes of sh:in lists must be IRIs; when loading shapes
+//     from N-Triples, use rdf.NTDecoder with BNodeAsIRI to convert them.
+//   - The path of a property shape must be a predicate IRI.
+//   - Shapes which refer to themselves through sh:property are rejected.
+//   - The nodes of a validation report are IRIs instead of blank nodes.
+package shacl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+// sh is the SHACL namespace.
+const sh = "http://www.w3.org/ns/shacl#"
+
+// SHACL vocabulary
+var (
+	shNodeShape         = rdf.IRI(sh + "NodeShape")
+	shPropertyShape     = rdf.IRI(sh + "PropertyShape")
+	shTargetNode        = rdf.IRI(sh + "targetNode")
+	shTargetClass       = rdf.IRI(sh + "targetClass")
+	shTargetSubjectsOf  = rdf.IRI(sh + "targetSubjectsOf")
+	shTargetObjectsOf   = rdf.IRI(sh + "targetObjectsOf")
+	shProperty          = rdf.IRI(sh + "property")
+	shPath              = rdf.IRI(sh + "path")
+	shMinCount          = rdf.IRI(sh + "minCount")
+	shMaxCount          = rdf.IRI(sh + "maxCount")
+	shDatatype          = rdf.IRI(sh + "datatype")
+	shClass             = rdf.IRI(sh + "class")
+	shPattern           = rdf.IRI(sh + "pattern")
+	shFlags             = rdf.IRI(sh + "flags")
+	shIn                = rdf.IRI(sh + "in")
+	shSeverity          = rdf.IRI(sh + "severity")
+	shMessage           = rdf.IRI(sh + "message")
+	shDeactivated       = rdf.IRI(sh + "deactivated")
+	shValidationReport  = rdf.IRI(sh + "ValidationReport")
+	shValidationResult  = rdf.IRI(sh + "ValidationResult")
+	shConforms          = rdf.IRI(sh + "conforms")
+	shResult            = rdf.IRI(sh + "result")
+	shFocusNode         = rdf.IRI(sh + "focusNode")
+	shResultPath        = rdf.IRI(sh + "resultPath")
+	shValue             = rdf.IRI(sh + "value")
+	shSourceShape       = rdf.IRI(sh + "sourceShape")
+	shSourceConstraint  = rdf.IRI(sh + "sourceConstraintComponent")
+	shResultSeverity    = rdf.IRI(sh + "resultSeverity")
+	shResultMessage     = rdf.IRI(sh + "resultMessage")
+	shMinCountComponent = rdf.IRI(sh + "MinCountConstraintComponent")
+	shMaxCountComponent = rdf.IRI(sh + "MaxCountConstraintComponent")
+	shDatatypeComponent = rdf.IRI(sh + "DatatypeConstraintComponent")
+	shClassComponent    = rdf.IRI(sh + "ClassConstraintComponent")
+	shPatternComponent  = rdf.IRI(sh + "PatternConstraintComponent")
+	shInComponent       = rdf.IRI(sh + "InConstraintComponent")
+
+	rdfType        = rdf.IRI("http://www.w3.org/1999/02/22-rdf-syntax-ns#type")
+	rdfFirst       = rdf.IRI("http://www.w3.org/1999/02/22-rdf-syntax-ns#first")
+	rdfRest        = rdf.IRI("http://www.w3.org/1999/02/22-rdf-syntax-ns#rest")
+	rdfNil         = rdf.IRI("http://www.w3.org/1999/02/22-rdf-syntax-ns#nil")
+	rdfsSubClassOf = rdf.IRI("http://www.w3.org/2000/01/rdf-schema#subClassOf")
+)
+
+// Severities of validation results
+var (
+	Violation = rdf.IRI(sh + "Violation")
+	Warning   = rdf.IRI(sh + "Warning")
+	Info      = rdf.IRI(sh + "Info")
+)
+
+// Shapes is a set of parsed shapes, ready to validate data against.
+type Shapes struct {
+	shapes    []*shape         // shapes with targets, ordered by IRI
+	objectsOf map[rdf.IRI]bool // predicates of sh:targetObjectsOf
+}
+
+// shape is a node shape, or a property shape if path is not empty.
+type shape struct {
+	iri rdf.IRI
+
+	targetNodes      rdf.Terms
+	targetClasses    []rdf.IRI
+	targetSubjectsOf []rdf.IRI
+	targetObjectsOf  []rdf.IRI
+
+	path       rdf.IRI
+	minCount   int // 0 if not constrained
+	maxCount   int // -1 if not constrained
+	datatypes  []rdf.IRI
+	classes    []rdf.IRI
+	patterns   []*regexp.Regexp
+	in         rdf.Terms // nil if not constrained
+	properties []*shape
+
+	severity rdf.IRI
+	messages rdf.Terms
+}
+
+// hasTargets returns true if the shape has any targets.
+func (s *shape) hasTargets() bool {
+	return len(s.targetNodes)+len(s.targetClasses)+len(s.targetSubjectsOf)+len(s.targetObjectsOf) > 0
+}
+
+// Parse parses the shapes of the given shapes graph. The shapes are the
+// subjects typed as sh:NodeShape or sh:PropertyShape, those with targets,
+// and the objects of sh:property.
+func Parse(g rdf.Graph) (*Shapes, error) {
+	p := parser{g: g, shapes: make(map[rdf.IRI]*shape), parsing: make(map[rdf.IRI]bool)}
+	var iris []rdf.IRI
+	for subj, props := range g {
+		isShape := len(props[shTargetNode])+len(props[shTargetClass])+len(props[shTargetSubjectsOf])+len(props[shTargetObjectsOf]) > 0
+		for _, t := range props[rdfType] {
+			if t.Eq(shNodeShape) || t.Eq(shPropertyShape) {
+				isShape = true
+			}
+		}
+		if isShape {
+			iris = append(iris, subj)
+		}
+	}
+	sort.Slice(iris, func(i, j int) bool { return iris[i] < iris[j] })
+
+	s := &Shapes{objectsOf: make(map[rdf.IRI]bool)}
+	for _, iri := range iris {
+		shp, err := p.parse(iri)
+		if err != nil {
+			return nil, err
+		}
+		if shp == nil || !shp.hasTargets() {
+			continue
+		}
+		s.shapes = append(s.shapes, shp)
+		for _, pred := range shp.targetObjectsOf {
+			s.objectsOf[pred] = true
+		}
+	}
+	return s, nil
+}
+
+// parser parses the shapes of a shapes graph.
+type parser struct {
+	g       rdf.Graph
+	shapes  map[rdf.IRI]*shape // parsed shapes; nil if deactivated
+	parsing map[rdf.IRI]bool   // shapes being parsed, to detect recursion
+}
+
+// parse parses the shape with the given IRI, returning nil if it is
+// deactivated.
+func (p *parser) parse(iri rdf.IRI) (*shape, error) {
+	if s, ok := p.shapes[iri]; ok {
+		return s, nil
+	}
+	if p.parsing[iri] {
+		return nil, fmt.Errorf("shacl: shape %v refers to itself", iri)
+	}
+	p.parsing[iri] = true
+	defer delete(p.parsing, iri)
+
+	props := p.g[iri]
+	for _, t := range props[shDeactivated] {
+		if l, ok := t.(rdf.Literal); ok && l.DataType() == rdf.XSDBoolean && fmt.Sprint(l.Value()) == "true" {
+			p.shapes[iri] = nil
+			return nil, nil
+		}
+	}
+
+	s := &shape{iri: iri, maxCount: -1, severity: Violation, targetNodes: props[shTargetNode], messages: props[shMessage]}
+	var err error
+	for _, x := range []struct {
+		pred rdf.IRI
+		iris *[]rdf.IRI
+	}{
+		{shTargetClass, &s.targetClasses},
+		{shTargetSubjectsOf, &s.targetSubjectsOf},
+		{shTargetObjectsOf, &s.targetObjectsOf},
+		{shDatatype, &s.datatypes},
+		{shClass, &s.classes},
+	} {
+		if *x.iris, err = iris(iri, x.pred, props[x.pred]); err != nil {
+			return nil, err
+		}
+	}
+
+	if paths := props[shPath]; len(paths) > 0 {
+		path, ok := paths[0].(rdf.IRI)
+		if len(paths) > 1 || !ok {
+			return nil, fmt.Errorf("shacl: shape %v: sh:path must be a single predicate IRI", iri)
+		}
+		s.path = path
+	}
+	if s.minCount, err = count(iri, shMinCount, props[shMinCount], 0); err != nil {
+		return nil, err
+	}
+	if s.maxCount, err = count(iri, shMaxCount, props[shMaxCount], -1); err != nil {
+		return nil, err
+	}
+	if s.path == "" && (len(props[shMinCount]) > 0 || len(props[shMaxCount]) > 0) {
+		return nil, fmt.Errorf("shacl: shape %v: sh:minCount and sh:maxCount require sh:path", iri)
+	}
+	if sev := props[shSeverity]; len(sev) > 0 {
+		if s.severity, err = single(iri, shSeverity, sev); err != nil {
+			return nil, err
+		}
+	}
+
+	var flags string
+	if f := props[shFlags]; len(f) > 0 {
+		flags = fmt.Sprint(f[0].Value())
+	}
+	for _, t := range props[shPattern] {
+		pattern := fmt.Sprint(t.Value())
+		for _, f := range flags {
+			switch f {
+			case 'i', 's', 'm':
+				pattern = "(?" + string(f) + ")" + pattern
+			default:
+				return nil, fmt.Errorf("shacl: shape %v: unsupported regex flag: %q", iri, f)
+			}
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("shacl: shape %v: %v", iri, err)
+		}
+		s.patterns = append(s.patterns, re)
+	}
+
+	if lists := props[shIn]; len(lists) > 0 {
+		list, ok := lists[0].(rdf.IRI)
+		if len(lists) > 1 || !ok {
+			return nil, fmt.Errorf("shacl: shape %v: sh:in must be a single list", iri)
+		}
+		if s.in, err = p.list(iri, list); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, t := range props[shProperty] {
+		piri, ok := t.(rdf.IRI)
+		if !ok {
+			return nil, fmt.Errorf("shacl: shape %v: sh:property must be an IRI", iri)
+		}
+		ps, err := p.parse(piri)
+		if err != nil {
+			return nil, err
+		}
+		if ps == nil {
+			continue
+		}
+		if ps.path == "" {
+			return nil, fmt.Errorf("shacl: property shape %v has no sh:path", piri)
+		}
+		s.properties = append(s.properties, ps)
+	}
+
+	p.shapes[iri] = s
+	return s, nil
+}
+
+// list returns the members of the RDF list starting at the given node.
+func (p *parser) list(iri, node rdf.IRI) (members rdf.Terms, err error) {
+	members = rdf.Terms{}
+	seen := make(map[rdf.IRI]bool)
+	for node != rdfNil {
+		props := p.g[node]
+		if seen[node] || len(props[rdfFirst]) != 1 || len(props[rdfRest]) != 1 {
+			return nil, fmt.Errorf("shacl: shape %v: malformed list %v", iri, node)
+		}
+		seen[node] = true
+		members = append(members, props[rdfFirst][0])
+		next, ok := props[rdfRest][0].(rdf.IRI)
+		if !ok {
+			return nil, fmt.Errorf("shacl: shape %v: malformed list %v", iri, node)
+		}
+		node = next
+	}
+	return members, nil
+}
+
+// iris returns the objects of the predicate, which must be IRIs.
+func iris(iri, pred rdf.IRI, terms rdf.Terms) ([]rdf.IRI, error) {
+	var res []rdf.IRI
+	for _, t := range terms {
+		i, ok := t.(rdf.IRI)
+		if !ok {
+			return nil, fmt.Errorf("shacl: shape %v: %v must be an IRI", iri, pred)
+		}
+		res = append(res, i)
+	}
+	return res, nil
+}
+
+// single returns the object of the predicate, which must be a single IRI.
+func single(iri, pred rdf.IRI, terms rdf.Terms) (rdf.IRI, error) {
+	res, err := iris(iri, pred, terms)
+	if err != nil {
+		return "", err
+	}
+	if len(res) != 1 {
+		return "", fmt.Errorf("shacl: shape %v: %v must be a single IRI", iri, pred)
+	}
+	return res[0], nil
+}
+
+// count returns the object of the predicate, which must be a single
+// non-negative integer, or def if there is none.
+func count(iri, pred rdf.IRI, terms rdf.Terms, def int) (int, error) {
+	if len(terms) == 0 {
+		return def, nil
+	}
+	if l, ok := terms[0].(rdf.Literal); ok && len(terms) == 1 {
+		if n, err := strconv.Atoi(fmt.Sprint(l.Value())); err == nil && n >= 0 {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("shacl: shape %v: %v must be a single non-negative integer", iri, pred)
+}
@@ -0,0 +1,206 @@
+package shacl
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+const testShapes = `<http://ex.org/PersonShape> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.w3.org/ns/shacl#NodeShape> .
+<http://ex.org/PersonShape> <http://www.w3.org/ns/shacl#targetClass> <http://ex.org/Person> .
+<http://ex.org/PersonShape> <http://www.w3.org/ns/shacl#property> _:name .
+<http://ex.org/PersonShape> <http://www.w3.org/ns/shacl#property> _:born .
+<http://ex.org/PersonShape> <http://www.w3.org/ns/shacl#property> _:gender .
+_:name <http://www.w3.org/ns/shacl#path> <http://ex.org/name> .
+_:name <http://www.w3.org/ns/shacl#minCount> "1"^^<http://www.w3.org/2001/XMLSchema#integer> .
+_:name <http://www.w3.org/ns/shacl#maxCount> "1"^^<http://www.w3.org/2001/XMLSchema#integer> .
+_:name <http://www.w3.org/ns/shacl#datatype> <http://www.w3.org/2001/XMLSchema#string> .
+_:name <http://www.w3.org/ns/shacl#message> "A person must have a single name" .
+_:born <http://www.w3.org/ns/shacl#path> <http://ex.org/born> .
+_:born <http://www.w3.org/ns/shacl#pattern> "^[0-9]{4}$" .
+_:born <http://www.w3.org/ns/shacl#severity> <http://www.w3.org/ns/shacl#Warning> .
+_:gender <http://www.w3.org/ns/shacl#path> <http://ex.org/gender> .
+_:gender <http://www.w3.org/ns/shacl#in> _:l1 .
+_:l1 <http://www.w3.org/1999/02/22-rdf-syntax-ns#first> <http://ex.org/female> .
+_:l1 <http://www.w3.org/1999/02/22-rdf-syntax-ns#rest> _:l2 .
+_:l2 <http://www.w3.org/1999/02/22-rdf-syntax-ns#first> <http://ex.org/male> .
+_:l2 <http://www.w3.org/1999/02/22-rdf-syntax-ns#rest> <http://www.w3.org/1999/02/22-rdf-syntax-ns#nil> .
+<http://ex.org/CreatorShape> <http://www.w3.org/ns/shacl#targetObjectsOf> <http://ex.org/creator> .
+<http://ex.org/CreatorShape> <http://www.w3.org/ns/shacl#class> <http://ex.org/Agent> .
+<http://ex.org/Unused> <http://www.w3.org/ns/shacl#targetClass> <http://ex.org/Person> .
+<http://ex.org/Unused> <http://www.w3.org/ns/shacl#deactivated> "true"^^<http://www.w3.org/2001/XMLSchema#boolean> .
+<http://ex.org/Unused> <http://www.w3.org/ns/shacl#class> <http://ex.org/Nothing> .
+`
+
+const testData = `<http://ex.org/Person> <http://www.w3.org/2000/01/rdf-schema#subClassOf> <http://ex.org/Agent> .
+<http://ex.org/Author> <http://www.w3.org/2000/01/rdf-schema#subClassOf> <http://ex.org/Person> .
+<http://ex.org/hamsun> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://ex.org/Author> .
+<http://ex.org/hamsun> <http://ex.org/name> "Hamsun, Knut" .
+<http://ex.org/hamsun> <http://ex.org/born> "1859" .
+<http://ex.org/hamsun> <http://ex.org/gender> <http://ex.org/male> .
+<http://ex.org/undset> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://ex.org/Person> .
+<http://ex.org/undset> <http://ex.org/name> "Undset, Sigrid"@no .
+<http://ex.org/undset> <http://ex.org/name> "Sigrid Undset" .
+<http://ex.org/undset> <http://ex.org/born> "ca. 1882" .
+<http://ex.org/undset> <http://ex.org/gender> <http://ex.org/woman> .
+<http://ex.org/nameless> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://ex.org/Person> .
+<http://ex.org/sult> <http://ex.org/creator> <http://ex.org/hamsun> .
+<http://ex.org/sult> <http://ex.org/creator> <http://ex.org/nrk> .
+`
+
+func mustParse(t *testing.T, shapes string) *Shapes {
+	dec := rdf.NewNTDecoder(bytes.NewBufferString(shapes))
+	dec.BNodeAsIRI = true
+	dec.BNodeNS = "urn:x-bnode:"
+	s, err := Parse(dec.DecodeAll())
+	if err != nil {
+		t.Fatalf("Parse() == %v; want no error", err)
+	}
+	return s
+}
+
+func results(r *Report) []string {
+	var res []string
+	for _, x := range r.Results {
+		res = append(res, x.String())
+	}
+	return res
+}
+
+var wantResults = []string{
+	`<http://ex.org/nrk> <http://ex.org/nrk>: <http://www.w3.org/ns/shacl#ClassConstraintComponent> of shape <http://ex.org/CreatorShape>`,
+	`<http://ex.org/nameless> <http://ex.org/name>: <http://www.w3.org/ns/shacl#MinCountConstraintComponent> of shape <urn:x-bnode:name>: A person must have a single name`,
+	`<http://ex.org/undset> <http://ex.org/name>: <http://www.w3.org/ns/shacl#MaxCountConstraintComponent> of shape <urn:x-bnode:name>: A person must have a single name`,
+	`<http://ex.org/undset> <http://ex.org/name> "Undset, Sigrid"@no: <http://www.w3.org/ns/shacl#DatatypeConstraintComponent> of shape <urn:x-bnode:name>: A person must have a single name`,
+	`<http://ex.org/undset> <http://ex.org/born> "ca. 1882": <http://www.w3.org/ns/shacl#PatternConstraintComponent> of shape <urn:x-bnode:born>`,
+	`<http://ex.org/undset> <http://ex.org/gender> <http://ex.org/woman>: <http://www.w3.org/ns/shacl#InConstraintComponent> of shape <urn:x-bnode:gender>`,
+}
+
+func TestValidateGraph(t *testing.T) {
+	s := mustParse(t, testShapes)
+	r := s.ValidateGraph(rdf.Load(bytes.NewBufferString(testData)))
+	if r.Conforms {
+		t.Errorf("ValidateGraph().Conforms == true; want false")
+	}
+	if got := results(r); strings.Join(got, "\n") != strings.Join(wantResults, "\n") {
+		t.Errorf("ValidateGraph() ==\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(wantResults, "\n"))
+	}
+	for _, res := range r.Results {
+		if want := Violation; strings.Contains(res.Shape.String(), "born") {
+			if res.Severity != Warning {
+				t.Errorf("ValidateGraph() result %v has severity %v; want %v", res, res.Severity, Warning)
+			}
+		} else if res.Severity != want {
+			t.Errorf("ValidateGraph() result %v has severity %v; want %v", res, res.Severity, want)
+		}
+	}
+
+	g := r.Graph()
+	if got := len(g[ReportIRI][shResult]); got != len(wantResults) {
+		t.Errorf("Report.Graph() has %d sh:result; want %d", got, len(wantResults))
+	}
+	conforms, _ := rdf.NewTypedLiteral("false", rdf.XSDBoolean)
+	if got := g[ReportIRI][shConforms]; len(got) != 1 || !got[0].Eq(conforms) {
+		t.Errorf("Report.Graph() sh:conforms == %v; want %v", got, conforms)
+	}
+	if got := g["urn:x-malle:shacl:report:2"][shFocusNode]; len(got) != 1 || !got[0].Eq(rdf.IRI("http://ex.org/nameless")) {
+		t.Errorf("Report.Graph() sh:focusNode of second result == %v; want <http://ex.org/nameless>", got)
+	}
+
+	r = s.ValidateGraph(rdf.Load(bytes.NewBufferString(testData[:strings.Index(testData, "<http://ex.org/undset>")])))
+	if !r.Conforms || len(r.Results) != 0 {
+		t.Errorf("ValidateGraph() == %v; want conforming report", results(r))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	const prefix = "<http://ex.org/S> <http://www.w3.org/ns/shacl#targetNode> <http://ex.org/x> .\n"
+	tests := []string{
+		// recursive
+		`<http://ex.org/S> <http://www.w3.org/ns/shacl#property> <http://ex.org/P> .
+<http://ex.org/P> <http://www.w3.org/ns/shacl#path> <http://ex.org/p> .
+<http://ex.org/P> <http://www.w3.org/ns/shacl#property> <http://ex.org/S> .`,
+		// property shape without path
+		`<http://ex.org/S> <http://www.w3.org/ns/shacl#property> <http://ex.org/P> .
+<http://ex.org/P> <http://www.w3.org/ns/shacl#minCount> "1" .`,
+		// count not an integer
+		`<http://ex.org/S> <http://www.w3.org/ns/shacl#property> <http://ex.org/P> .
+<http://ex.org/P> <http://www.w3.org/ns/shacl#path> <http://ex.org/p> .
+<http://ex.org/P> <http://www.w3.org/ns/shacl#maxCount> "many" .`,
+		// unsupported flag
+		`<http://ex.org/S> <http://www.w3.org/ns/shacl#pattern> "^a" .
+<http://ex.org/S> <http://www.w3.org/ns/shacl#flags> "x" .`,
+		// malformed list
+		`<http://ex.org/S> <http://www.w3.org/ns/shacl#in> <http://ex.org/l> .
+<http://ex.org/l> <http://www.w3.org/1999/02/22-rdf-syntax-ns#first> <http://ex.org/a> .`,
+	}
+	for _, test := range tests {
+		if _, err := Parse(rdf.Load(bytes.NewBufferString(prefix + test))); err == nil {
+			t.Errorf("Parse(%q) == nil; want error", test)
+		}
+	}
+}
+
+func TestValidateStore(t *testing.T) {
+	file := "_shacl.db"
+	db, err := malle.Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer db.Close()
+
+	s := mustParse(t, testShapes)
+	if err := db.ImportGraph(rdf.Load(bytes.NewBufferString(testData))); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.ValidateStore(db)
+	if err != nil {
+		t.Fatalf("ValidateStore() == %v; want no error", err)
+	}
+	if got := results(r); strings.Join(got, "\n") != strings.Join(wantResults, "\n") {
+		t.Errorf("ValidateStore() ==\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(wantResults, "\n"))
+	}
+
+	r, err = s.ValidateStore(db, "http://ex.org/hamsun", "http://ex.org/nameless")
+	if err != nil || len(r.Results) != 1 || r.Results[0].FocusNode != rdf.IRI("http://ex.org/nameless") {
+		t.Errorf("ValidateStore(hamsun, nameless) == %v, %v; want a single result for nameless", results(r), err)
+	}
+
+	// Imports of resources which do not conform are rejected, while
+	// imports of resources which do are accepted.
+	db.SetValidator(s.Reject)
+	person := rdf.NewTriple("http://ex.org/ibsen", rdfType, rdf.IRI("http://ex.org/Author"))
+	err = db.ImportGraph(rdf.NewGraph().Add(person))
+	if r, ok := err.(*Report); !ok || len(r.Results) != 1 || r.Results[0].Component != shMinCountComponent {
+		t.Errorf("Store.ImportGraph(person without name) == %v; want a report with a sh:minCount result", err)
+	}
+	if ok, _ := db.HasTriple(person); ok {
+		t.Errorf("Store.ImportGraph(person without name) stored the triple")
+	}
+
+	name, _ := rdf.NewLiteral("Ibsen, Henrik")
+	g := rdf.NewGraph().Add(person).Add(rdf.NewTriple("http://ex.org/ibsen", "http://ex.org/name", name))
+	if err := db.BulkImportGraph(g); err != nil {
+		t.Errorf("Store.BulkImportGraph(person with name) == %v; want no error", err)
+	}
+
+	// The objects of sh:targetObjectsOf are validated too.
+	work := rdf.NewTriple("http://ex.org/peer", "http://ex.org/creator", rdf.IRI("http://ex.org/nobody"))
+	if err := db.ImportGraph(rdf.NewGraph().Add(work)); err == nil {
+		t.Errorf("Store.ImportGraph(work by non-agent) == nil; want error")
+	}
+	work = rdf.NewTriple("http://ex.org/peer", "http://ex.org/creator", rdf.IRI("http://ex.org/ibsen"))
+	if err := db.ImportGraph(rdf.NewGraph().Add(work)); err != nil {
+		t.Errorf("Store.ImportGraph(work by person) == %v; want no error", err)
+	}
+
+	db.SetValidator(nil)
+	if err := db.ImportGraph(rdf.NewGraph().Add(rdf.NewTriple("http://ex.org/x", rdfType, rdf.IRI("http://ex.org/Person")))); err != nil {
+		t.Errorf("Store.ImportGraph() without validator == %v; want no error", err)
+	}
+}
@@ -0,0 +1,404 @@
+package shacl
+
+import (
+	"io"
+	"sort"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// ValidateGraph validates the graph against the shapes.
+func (s *Shapes) ValidateGraph(g rdf.Graph) *Report {
+	r, _ := s.validate(graphData(g), nil, false) // a graph cannot fail to be read
+	return r
+}
+
+// ValidateStore validates the resources in the triple store with the given
+// IRIs against the shapes of which they are targets, or all targets in the
+// store if none are given.
+func (s *Shapes) ValidateStore(db *malle.Store, resources ...rdf.IRI) (r *Report, err error) {
+	err = db.View(func(tx *malle.Tx) error {
+		r, err = s.ValidateTx(tx, resources...)
+		return err
+	})
+	return r, err
+}
+
+// ValidateTx works like ValidateStore, but using the given transaction.
+func (s *Shapes) ValidateTx(tx *malle.Tx, resources ...rdf.IRI) (*Report, error) {
+	nodes := make(rdf.Terms, len(resources))
+	for i, iri := range resources {
+		nodes[i] = iri
+	}
+	return s.validate(txData{tx}, nodes, len(resources) > 0)
+}
+
+// Reject validates the subjects of a graph imported into the triple store,
+// or of the triples added and removed by a patch, along with the objects of
+// its triples if they are targets through sh:targetObjectsOf. It returns the
+// validation report as an error if they do not conform to the shapes, and nil
+// otherwise. It satisfies malle.Validator, so that
+//
+//	db.SetValidator(shapes.Reject)
+//
+// rejects imported graphs and patches which do not conform.
+func (s *Shapes) Reject(tx *malle.Tx, g rdf.Graph) error {
+	var nodes rdf.Terms
+	for subj, props := range g {
+		nodes = append(nodes, subj)
+		for pred, terms := range props {
+			if s.objectsOf[pred] {
+				nodes = append(nodes, terms...)
+			}
+		}
+	}
+	r, err := s.validate(txData{tx}, nodes, true)
+	if err != nil {
+		return err
+	}
+	if !r.Conforms {
+		return r
+	}
+	return nil
+}
+
+// data is a data graph to validate.
+type data interface {
+	// match returns the triples matching the given pattern, where an empty
+	// IRI or a nil object acts as a wildcard.
+	match(s, p rdf.IRI, o rdf.Term) ([]rdf.Triple, error)
+}
+
+// graphData is a graph to validate.
+type graphData rdf.Graph
+
+func (g graphData) match(s, p rdf.IRI, o rdf.Term) (res []rdf.Triple, err error) {
+	for subj, props := range g {
+		if s != "" && subj != s {
+			continue
+		}
+		for pred, terms := range props {
+			if p != "" && pred != p {
+				continue
+			}
+			for _, obj := range terms {
+				if o == nil || obj.Eq(o) {
+					res = append(res, rdf.NewTriple(subj, pred, obj))
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// txData is a triple store to validate, read within a transaction.
+type txData struct {
+	tx *malle.Tx
+}
+
+func (d txData) match(s, p rdf.IRI, o rdf.Term) (res []rdf.Triple, err error) {
+	it, err := d.tx.Match(s, p, o)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	for {
+		tr, err := it.Next()
+		if err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+		res = append(res, tr)
+	}
+}
+
+// validation holds the state of a validation.
+type validation struct {
+	data   data
+	report *Report
+	supers map[rdf.IRI]map[rdf.IRI]bool // the class and its superclasses
+}
+
+// validate validates the data against the shapes. If restricted is set,
+// only the given nodes are validated, against the shapes of which they are
+// targets; otherwise all targets are.
+func (s *Shapes) validate(d data, nodes rdf.Terms, restricted bool) (*Report, error) {
+	v := &validation{data: d, report: &Report{Conforms: true}, supers: make(map[rdf.IRI]map[rdf.IRI]bool)}
+	nodes = distinct(nodes)
+	for _, shp := range s.shapes {
+		var focus rdf.Terms
+		if restricted {
+			for _, node := range nodes {
+				ok, err := v.isTarget(shp, node)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					focus = append(focus, node)
+				}
+			}
+		} else {
+			var err error
+			if focus, err = v.targets(shp); err != nil {
+				return nil, err
+			}
+		}
+		for _, node := range focus {
+			if err := v.validateShape(shp, node); err != nil {
+				return nil, err
+			}
+		}
+	}
+	v.report.Conforms = len(v.report.Results) == 0
+	return v.report, nil
+}
+
+// targets returns the focus nodes of the shape, ordered.
+func (v *validation) targets(shp *shape) (rdf.Terms, error) {
+	nodes := append(rdf.Terms{}, shp.targetNodes...)
+	for _, c := range shp.targetClasses {
+		instances, err := v.instances(c)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, instances...)
+	}
+	for _, p := range shp.targetSubjectsOf {
+		trs, err := v.data.match("", p, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, tr := range trs {
+			nodes = append(nodes, tr.Subject())
+		}
+	}
+	for _, p := range shp.targetObjectsOf {
+		trs, err := v.data.match("", p, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, tr := range trs {
+			nodes = append(nodes, tr.Object())
+		}
+	}
+	return distinct(nodes), nil
+}
+
+// isTarget returns true if the node is a focus node of the shape.
+func (v *validation) isTarget(shp *shape, node rdf.Term) (bool, error) {
+	for _, t := range shp.targetNodes {
+		if t.Eq(node) {
+			return true, nil
+		}
+	}
+	for _, c := range shp.targetClasses {
+		ok, err := v.isInstance(node, c)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+	if iri, ok := node.(rdf.IRI); ok {
+		for _, p := range shp.targetSubjectsOf {
+			trs, err := v.data.match(iri, p, nil)
+			if len(trs) > 0 || err != nil {
+				return len(trs) > 0, err
+			}
+		}
+	}
+	for _, p := range shp.targetObjectsOf {
+		trs, err := v.data.match("", p, node)
+		if len(trs) > 0 || err != nil {
+			return len(trs) > 0, err
+		}
+	}
+	return false, nil
+}
+
+// validateShape validates the focus node against the shape.
+func (v *validation) validateShape(shp *shape, focus rdf.Term) error {
+	values := rdf.Terms{focus}
+	if shp.path != "" {
+		values = nil
+		if iri, ok := focus.(rdf.IRI); ok {
+			trs, err := v.data.match(iri, shp.path, nil)
+			if err != nil {
+				return err
+			}
+			for _, tr := range trs {
+				values = append(values, tr.Object())
+			}
+			sort.Sort(values)
+		}
+		if len(values) < shp.minCount {
+			v.result(shp, focus, nil, shMinCountComponent)
+		}
+		if shp.maxCount >= 0 && len(values) > shp.maxCount {
+			v.result(shp, focus, nil, shMaxCountComponent)
+		}
+	}
+
+	for _, value := range values {
+		for _, dt := range shp.datatypes {
+			if l, ok := value.(rdf.Literal); !ok || l.DataType() != dt {
+				v.result(shp, focus, value, shDatatypeComponent)
+			}
+		}
+		for _, c := range shp.classes {
+			ok, err := v.isInstance(value, c)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				v.result(shp, focus, value, shClassComponent)
+			}
+		}
+		for _, re := range shp.patterns {
+			if !re.MatchString(lexicalForm(value)) {
+				v.result(shp, focus, value, shPatternComponent)
+			}
+		}
+		if shp.in != nil && !contains(shp.in, value) {
+			v.result(shp, focus, value, shInComponent)
+		}
+		for _, ps := range shp.properties {
+			if err := v.validateShape(ps, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// result adds a validation result to the report.
+func (v *validation) result(shp *shape, focus, value rdf.Term, component rdf.IRI) {
+	v.report.Results = append(v.report.Results, Result{
+		FocusNode: focus,
+		Path:      shp.path,
+		Value:     value,
+		Shape:     shp.iri,
+		Component: component,
+		Severity:  shp.severity,
+		Messages:  shp.messages,
+	})
+}
+
+// isInstance returns true if the node has the class, or one of its
+// subclasses, as rdf:type.
+func (v *validation) isInstance(node rdf.Term, class rdf.IRI) (bool, error) {
+	iri, ok := node.(rdf.IRI)
+	if !ok {
+		return false, nil
+	}
+	types, err := v.data.match(iri, rdfType, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, tr := range types {
+		t, ok := tr.Object().(rdf.IRI)
+		if !ok {
+			continue
+		}
+		supers, err := v.superClasses(t)
+		if err != nil {
+			return false, err
+		}
+		if supers[class] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// superClasses returns the class and its superclasses, following
+// rdfs:subClassOf transitively.
+func (v *validation) superClasses(class rdf.IRI) (map[rdf.IRI]bool, error) {
+	if supers, ok := v.supers[class]; ok {
+		return supers, nil
+	}
+	supers := map[rdf.IRI]bool{class: true}
+	queue := []rdf.IRI{class}
+	for len(queue) > 0 {
+		trs, err := v.data.match(queue[0], rdfsSubClassOf, nil)
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, tr := range trs {
+			if c, ok := tr.Object().(rdf.IRI); ok && !supers[c] {
+				supers[c] = true
+				queue = append(queue, c)
+			}
+		}
+	}
+	v.supers[class] = supers
+	return supers, nil
+}
+
+// instances returns the resources with the class, or one of its subclasses,
+// as rdf:type.
+func (v *validation) instances(class rdf.IRI) (rdf.Terms, error) {
+	var res rdf.Terms
+	seen := map[rdf.IRI]bool{class: true}
+	queue := []rdf.IRI{class}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		trs, err := v.data.match("", rdfType, c)
+		if err != nil {
+			return nil, err
+		}
+		for _, tr := range trs {
+			res = append(res, tr.Subject())
+		}
+		if trs, err = v.data.match("", rdfsSubClassOf, c); err != nil {
+			return nil, err
+		}
+		for _, tr := range trs {
+			if sub := tr.Subject(); !seen[sub] {
+				seen[sub] = true
+				queue = append(queue, sub)
+			}
+		}
+	}
+	return res, nil
+}
+
+// lexicalForm returns the string value of an IRI or the lexical form of a
+// literal.
+func lexicalForm(t rdf.Term) string {
+	if iri, ok := t.(rdf.IRI); ok {
+		return string(iri)
+	}
+	l := t.(rdf.Literal)
+	if l.DataType() == rdf.XSDString || l.DataType() == rdf.RDFLangString {
+		return l.Value().(string)
+	}
+	// The lexical form of other literals is between the quotes of their
+	// N-Triples serialization.
+	s := l.String()
+	return s[1 : len(s)-len(l.DataType().String())-3]
+}
+
+// contains returns true if the term is one of the terms.
+func contains(terms rdf.Terms, t rdf.Term) bool {
+	for _, x := range terms {
+		if x.Eq(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// distinct returns the terms without duplicates, ordered.
+func distinct(terms rdf.Terms) rdf.Terms {
+	sort.Sort(terms)
+	res := terms[:0]
+	for _, t := range terms {
+		if len(res) == 0 || !t.Eq(res[len(res)-1]) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+package malle
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// Blank nodes
+//
+// Terms are identified by their encoding, so blank nodes, which are only
+// identified by their label within a document, cannot be stored as such.
+// Instead, the blank nodes of an N-Triples import are skolemized: replaced by
+// IRIs made of GenIDPrefix, a number unique to the import, and the label of
+// the blank node. A label thus stands for the same IRI throughout an import,
+// while the labels of different imports never clash. Export turns the IRIs
+// back into blank nodes.
+
+// GenIDPrefix is the prefix of the skolem IRIs replacing blank nodes. The
+// host is reserved, so the IRIs cannot clash with those of real resources.
+const GenIDPrefix = "http://malle.invalid/.well-known/genid/"
+
+// metaGenID is the key in the meta bucket holding the number of the last
+// import which skolemized blank nodes.
+var metaGenID = []byte("genid")
+
+// BlankNodeLabel returns the label of the blank node replaced by the given
+// skolem IRI, which is unique within the store, or false if the IRI is not a
+// skolem IRI.
+func BlankNodeLabel(iri rdf.IRI) (string, bool) {
+	if !strings.HasPrefix(string(iri), GenIDPrefix) || len(iri) == len(GenIDPrefix) {
+		return "", false
+	}
+	return string(iri[len(GenIDPrefix):]), true
+}
+
+// skolemizer replaces the blank nodes of an import with skolem IRIs.
+type skolemizer struct {
+	db   *Store
+	n    uint64             // number of the import; 0 until the first blank node
+	iris map[string]rdf.IRI // label -> skolem IRI
+	err  error              // error from numbering the import, if any
+}
+
+func (db *Store) newSkolemizer() *skolemizer {
+	return &skolemizer{db: db, iris: make(map[string]rdf.IRI)}
+}
+
+// iri returns the skolem IRI of the blank node with the given label. The
+// import is numbered when the first blank node is met, so that imports
+// without blank nodes do not use up numbers.
+func (sk *skolemizer) iri(label string) rdf.IRI {
+	if iri, ok := sk.iris[label]; ok {
+		return iri
+	}
+	if sk.n == 0 && sk.err == nil {
+		sk.err = sk.db.kv.Update(func(tx *bolt.Tx) error {
+			bkt := tx.Bucket(bMeta)
+			if v := bkt.Get(metaGenID); v != nil {
+				sk.n = btou64(v)
+			}
+			sk.n++
+			return bkt.Put(metaGenID, u64tob(sk.n))
+		})
+	}
+	iri := rdf.IRI(GenIDPrefix + strconv.FormatUint(sk.n, 10) + "_" + label)
+	sk.iris[label] = iri
+	return iri
+}
+
+// ntBlankNode returns the N-Triples serialization of the term, as a blank
+// node if it is a skolem IRI.
+func ntBlankNode(t rdf.Term) string {
+	if iri, ok := t.(rdf.IRI); ok {
+		if label, ok := BlankNodeLabel(iri); ok {
+			return "_:" + label
+		}
+	}
+	return t.String()
+}
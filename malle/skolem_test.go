@@ -0,0 +1,99 @@
+package malle
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestSkolemization(t *testing.T) {
+	const file = "_skolem.db"
+	db, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	defer func() { db.Close() }()
+
+	// Blank nodes with the same label are the same within an import,
+	// also across batches, but not across imports.
+	const doc = `<http://ex.org/rec> <http://ex.org/field> _:f1 .
+_:f1 <http://ex.org/subfield> "a" .
+_:f1 <http://ex.org/next> _:f2 .
+_:f2 <http://ex.org/subfield> "b" .
+`
+	for i := 0; i < 2; i++ {
+		if n, err := db.Import(bytes.NewBufferString(doc), 1, false); err != nil || n != 4 {
+			t.Fatalf("Store.Import() == %d, %v; want 4, <nil>", n, err)
+		}
+	}
+	it, err := db.Match("http://ex.org/rec", "http://ex.org/field", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []rdf.IRI
+	for tr, err := it.Next(); err == nil; tr, err = it.Next() {
+		fields = append(fields, tr.Object().(rdf.IRI))
+	}
+	it.Close()
+	if len(fields) != 2 || fields[0] == fields[1] {
+		t.Fatalf("Store.Match(rec, field, nil) == %v; want two different skolem IRIs", fields)
+	}
+	for _, f := range fields {
+		label, ok := BlankNodeLabel(f)
+		if !ok || !strings.HasPrefix(string(f), GenIDPrefix+"1_") && !strings.HasPrefix(string(f), GenIDPrefix+"2_") {
+			t.Errorf("skolem IRI %v has label %q, %v; want prefix %s followed by import number", f, label, ok, GenIDPrefix)
+		}
+		next := rdf.NewTriple(f, "http://ex.org/next", rdf.IRI(strings.Replace(string(f), "f1", "f2", 1)))
+		if ok, _ := db.HasTriple(next); !ok {
+			t.Errorf("Store.HasTriple(%v) == false; want true", next)
+		}
+	}
+	if _, ok := BlankNodeLabel("http://ex.org/rec"); ok {
+		t.Errorf("BlankNodeLabel(<http://ex.org/rec>) == true; want false")
+	}
+
+	// Export turns the skolem IRIs back into blank nodes, unless asked not to.
+	var b bytes.Buffer
+	if _, err := db.Export(&b, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<http://ex.org/rec> <http://ex.org/field> _:1_f1 .\n",
+		"_:2_f1 <http://ex.org/next> _:2_f2 .\n",
+		"_:1_f2 <http://ex.org/subfield> \"b\" .\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Store.Export() == %q; want it to contain %q", b.String(), want)
+		}
+	}
+	if strings.Contains(b.String(), GenIDPrefix) {
+		t.Errorf("Store.Export() == %q; want no skolem IRIs", b.String())
+	}
+	b.Reset()
+	if _, err := db.Export(&b, ExportOptions{SkolemIRIs: true}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "<" + GenIDPrefix + "1_f1> <http://ex.org/next> <" + GenIDPrefix + "1_f2> .\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("Store.Export(SkolemIRIs) == %q; want it to contain %q", b.String(), want)
+	}
+
+	// Imports without blank nodes do not use up import numbers, and the
+	// numbering survives reopening the store.
+	if _, err := db.Import(bytes.NewBufferString("<http://ex.org/a> <http://ex.org/b> <http://ex.org/c> .\n"), 10, false); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if db, err = Init(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Import(bytes.NewBufferString("_:x <http://ex.org/b> <http://ex.org/c> .\n"), 10, false); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := db.HasTriple(rdf.NewTriple(GenIDPrefix+"3_x", "http://ex.org/b", rdf.IRI("http://ex.org/c"))); !ok {
+		t.Errorf("Store.Import() after reopening did not skolemize _:x as import number 3")
+	}
+}
@@ -0,0 +1,238 @@
+package sparql
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// Exec executes the query against the triple store.
+func (q *Query) Exec(db *malle.Store) (*Result, error) {
+	sols, err := evalGroup(db, q.From, q.Where, malle.Binding{})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{Form: q.Form}
+	if q.Form == Ask {
+		res.Boolean = len(sols) > 0
+		return res, nil
+	}
+
+	if len(q.OrderBy) > 0 {
+		sort.SliceStable(sols, func(i, j int) bool {
+			for _, cond := range q.OrderBy {
+				a, _ := cond.Expr.Eval(sols[i])
+				b, _ := cond.Expr.Eval(sols[j])
+				c := orderCompare(a, b)
+				if c == 0 {
+					continue
+				}
+				if cond.Desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+
+	switch q.Form {
+	case Select:
+		res.Vars = q.Vars
+		if len(res.Vars) == 0 {
+			res.Vars = q.Where.vars(nil)
+		}
+		sols = project(sols, res.Vars)
+		if q.Distinct {
+			sols = distinct(sols, res.Vars)
+		}
+		res.Bindings = slice(sols, q.Offset, q.Limit)
+	case Construct:
+		res.Graph = rdf.NewGraph()
+		for _, b := range slice(sols, q.Offset, q.Limit) {
+			for _, pat := range q.Template {
+				if tr, ok := instantiate(pat, b); ok {
+					res.Graph.Add(tr)
+				}
+			}
+		}
+	case Describe:
+		res.Graph = rdf.NewGraph()
+		vars := q.Describe
+		if len(vars) == 0 {
+			for _, v := range q.Where.vars(nil) {
+				vars = append(vars, v)
+			}
+		}
+		described := make(map[rdf.IRI]bool)
+		for _, b := range slice(sols, q.Offset, q.Limit) {
+			for _, t := range vars {
+				if v, ok := t.(malle.Variable); ok {
+					t = b[v]
+				}
+				iri, ok := t.(rdf.IRI)
+				if !ok || described[iri] {
+					continue
+				}
+				described[iri] = true
+				g, _, err := db.Query(malle.NewQuery().CBD(iri, 0).From(q.From...))
+				if err != nil {
+					return nil, err
+				}
+				for _, tr := range g.Triples() {
+					res.Graph.Add(tr)
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// evalGroup evaluates the group graph pattern against the given graphs,
+// returning all solutions compatible with the given initial bindings.
+func evalGroup(db *malle.Store, graphs []rdf.IRI, g *Group, initial malle.Binding) ([]malle.Binding, error) {
+	q := malle.NewBGP().From(graphs...)
+	for _, pat := range g.Patterns {
+		q.Where(substitute(pat.Subject, initial), substitute(pat.Predicate, initial), substitute(pat.Object, initial))
+	}
+	res, err := db.Select(q)
+	if err != nil {
+		return nil, err
+	}
+	sols := make([]malle.Binding, len(res))
+	for i, b := range res {
+		for v, t := range initial {
+			b[v] = t
+		}
+		sols[i] = b
+	}
+
+	for _, opt := range g.Optionals {
+		var joined []malle.Binding
+		for _, b := range sols {
+			optSols, err := evalGroup(db, graphs, opt, b)
+			if err != nil {
+				return nil, err
+			}
+			if len(optSols) == 0 {
+				joined = append(joined, b)
+				continue
+			}
+			joined = append(joined, optSols...)
+		}
+		sols = joined
+	}
+
+	if len(g.Filters) == 0 {
+		return sols, nil
+	}
+	filtered := sols[:0]
+outer:
+	for _, b := range sols {
+		for _, f := range g.Filters {
+			if ok, err := ebv(f, b); err != nil || !ok {
+				continue outer
+			}
+		}
+		filtered = append(filtered, b)
+	}
+	return filtered, nil
+}
+
+// substitute replaces a bound variable with its value.
+func substitute(t rdf.Term, b malle.Binding) rdf.Term {
+	if v, ok := t.(malle.Variable); ok {
+		if bound, ok := b[v]; ok {
+			return bound
+		}
+	}
+	return t
+}
+
+// vars returns the variables of the group's triple patterns, including those
+// of nested groups, in order of appearance.
+func (g *Group) vars(seen map[malle.Variable]bool) (vars []malle.Variable) {
+	if seen == nil {
+		seen = make(map[malle.Variable]bool)
+	}
+	for _, pat := range g.Patterns {
+		for _, t := range []rdf.Term{pat.Subject, pat.Predicate, pat.Object} {
+			if v, ok := t.(malle.Variable); ok && !seen[v] {
+				seen[v] = true
+				vars = append(vars, v)
+			}
+		}
+	}
+	for _, opt := range g.Optionals {
+		vars = append(vars, opt.vars(seen)...)
+	}
+	return vars
+}
+
+// project restricts the solutions to the given variables.
+func project(sols []malle.Binding, vars []malle.Variable) []malle.Binding {
+	res := make([]malle.Binding, len(sols))
+	for i, b := range sols {
+		p := make(malle.Binding, len(vars))
+		for _, v := range vars {
+			if t, ok := b[v]; ok {
+				p[v] = t
+			}
+		}
+		res[i] = p
+	}
+	return res
+}
+
+// distinct removes duplicate solutions.
+func distinct(sols []malle.Binding, vars []malle.Variable) []malle.Binding {
+	seen := make(map[string]bool)
+	res := sols[:0]
+	for _, b := range sols {
+		key := make([]string, len(vars))
+		for i, v := range vars {
+			if t, ok := b[v]; ok {
+				key[i] = t.String()
+			}
+		}
+		k := strings.Join(key, "\x00")
+		if !seen[k] {
+			seen[k] = true
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// slice applies OFFSET and LIMIT to the solutions. A negative limit means no limit.
+func slice(sols []malle.Binding, offset, limit int) []malle.Binding {
+	if offset >= len(sols) {
+		return nil
+	}
+	sols = sols[offset:]
+	if limit >= 0 && limit < len(sols) {
+		sols = sols[:limit]
+	}
+	return sols
+}
+
+// instantiate creates a triple from the template pattern and bindings. It
+// returns false if a variable is unbound or the triple is not valid RDF.
+func instantiate(pat malle.Pattern, b malle.Binding) (rdf.Triple, bool) {
+	s, ok := substitute(pat.Subject, b).(rdf.IRI)
+	if !ok {
+		return rdf.Triple{}, false
+	}
+	p, ok := substitute(pat.Predicate, b).(rdf.IRI)
+	if !ok {
+		return rdf.Triple{}, false
+	}
+	o := substitute(pat.Object, b)
+	if _, ok := o.(malle.Variable); ok {
+		return rdf.Triple{}, false
+	}
+	return rdf.NewTriple(s, p, o), true
+}
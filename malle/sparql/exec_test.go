@@ -0,0 +1,201 @@
+package sparql
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+var testDB *malle.Store
+
+const testData = `<http://ex.org/hamsun> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://ex.org/Person> .
+<http://ex.org/hamsun> <http://ex.org/name> "Hamsun, Knut" .
+<http://ex.org/hamsun> <http://ex.org/born> "1859"^^<http://www.w3.org/2001/XMLSchema#integer> .
+<http://ex.org/undset> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://ex.org/Person> .
+<http://ex.org/undset> <http://ex.org/name> "Undset, Sigrid" .
+<http://ex.org/undset> <http://ex.org/born> "1882"^^<http://www.w3.org/2001/XMLSchema#integer> .
+<http://ex.org/ibsen> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://ex.org/Person> .
+<http://ex.org/ibsen> <http://ex.org/name> "Ibsen, Henrik"@no .
+<http://ex.org/sult> <http://ex.org/creator> <http://ex.org/hamsun> .
+<http://ex.org/sult> <http://ex.org/title> "Sult" .
+<http://ex.org/kristin> <http://ex.org/creator> <http://ex.org/undset> .
+`
+
+// testAuthority is stored in the named graph <http://ex.org/authority>.
+const testAuthority = `<http://ex.org/hamsun> <http://ex.org/viaf> "56615637" .
+<http://ex.org/hamsun> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://ex.org/Person> .
+`
+
+func TestMain(m *testing.M) {
+	var err error
+	testDB, err = malle.Init("_temp.db")
+	if err != nil {
+		panic(err)
+	}
+	if _, err = testDB.Import(bytes.NewBufferString(testData), 100, false); err != nil {
+		panic(err)
+	}
+	if err = testDB.ImportGraph(rdf.Load(bytes.NewBufferString(testAuthority)), "http://ex.org/authority"); err != nil {
+		panic(err)
+	}
+
+	retCode := m.Run()
+
+	testDB.Close()
+	if err = os.Remove("_temp.db"); err != nil {
+		panic(err)
+	}
+	os.Exit(retCode)
+}
+
+func TestExecSelect(t *testing.T) {
+	const prefix = "PREFIX ex: <http://ex.org/>\n"
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{
+			`SELECT ?p WHERE { ?p a ex:Person }`,
+			[]string{"?p=<http://ex.org/hamsun>", "?p=<http://ex.org/ibsen>", "?p=<http://ex.org/undset>"},
+		},
+		{
+			`SELECT ?name WHERE { ?p a ex:Person ; ex:born ?y ; ex:name ?name FILTER(?y < 1870) }`,
+			[]string{`?name="Hamsun, Knut"`},
+		},
+		{
+			`SELECT ?p ?w { ?p a ex:Person OPTIONAL { ?w ex:creator ?p } } ORDER BY ?p`,
+			[]string{
+				"?p=<http://ex.org/hamsun> ?w=<http://ex.org/sult>",
+				"?p=<http://ex.org/ibsen>",
+				"?p=<http://ex.org/undset> ?w=<http://ex.org/kristin>",
+			},
+		},
+		{
+			`SELECT ?p { ?p a ex:Person OPTIONAL { ?w ex:creator ?p } FILTER(!BOUND(?w)) }`,
+			[]string{"?p=<http://ex.org/ibsen>"},
+		},
+		{
+			`SELECT ?name { ?p ex:name ?name FILTER(lang(?name) = "no" || contains(?name, "Sigrid")) }`,
+			[]string{`?name="Ibsen, Henrik"@no`, `?name="Undset, Sigrid"`},
+		},
+		{
+			`SELECT ?name { ?p ex:name ?name FILTER(LANG(?name) = "") }`,
+			[]string{`?name="Hamsun, Knut"`, `?name="Undset, Sigrid"`},
+		},
+		{
+			`SELECT ?name { ?p ex:name ?name FILTER(LANG(?name) != "" && !STRSTARTS(?name, "")) }`,
+			nil,
+		},
+		{
+			`SELECT ?name { ?p ex:name ?name FILTER(STR(LANG(?name)) > "" && DATATYPE("") = <http://www.w3.org/2001/XMLSchema#string>) }`,
+			[]string{`?name="Ibsen, Henrik"@no`},
+		},
+		{
+			`SELECT ?name { ?p ex:name ?name FILTER regex(?name, "^h", "i") }`,
+			[]string{`?name="Hamsun, Knut"`},
+		},
+		{
+			`SELECT DISTINCT ?t { ?p a ?t }`,
+			[]string{"?t=<http://ex.org/Person>"},
+		},
+		{
+			`SELECT ?p { ?p ex:born ?y } ORDER BY DESC(?y) LIMIT 1`,
+			[]string{"?p=<http://ex.org/undset>"},
+		},
+		{
+			`SELECT ?p { ?p a ex:Person } ORDER BY ?p LIMIT 2 OFFSET 1`,
+			[]string{"?p=<http://ex.org/ibsen>", "?p=<http://ex.org/undset>"},
+		},
+		{
+			`SELECT ?p ?id FROM ex:authority { ?p a ex:Person OPTIONAL { ?p ex:viaf ?id } }`,
+			[]string{`?id="56615637" ?p=<http://ex.org/hamsun>`},
+		},
+		{
+			`SELECT ?id FROM <http://ex.org/nograph> { ?p ex:viaf ?id }`,
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		res, err := Exec(testDB, prefix+test.q)
+		if err != nil {
+			t.Errorf("Exec(%q) == %v; want no error", test.q, err)
+			continue
+		}
+		got := make([]string, len(res.Bindings))
+		for i, b := range res.Bindings {
+			got[i] = bindingString(b)
+		}
+		if len(res.Bindings) > 0 && !strings.Contains(test.q, "ORDER BY") {
+			sort.Strings(got)
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("Exec(%q) == %v; want %v", test.q, got, test.want)
+		}
+	}
+}
+
+func TestExecAsk(t *testing.T) {
+	tests := []struct {
+		q    string
+		want bool
+	}{
+		{`ASK { <http://ex.org/sult> ?p ?o }`, true},
+		{`ASK { <http://ex.org/sult> <http://ex.org/creator> <http://ex.org/undset> }`, false},
+		{`ASK { ?p <http://ex.org/born> ?y FILTER(?y > 1900) }`, false},
+	}
+	for _, test := range tests {
+		res, err := Exec(testDB, test.q)
+		if err != nil || res.Boolean != test.want {
+			t.Errorf("Exec(%q) == %v, %v; want %v, <nil>", test.q, res.Boolean, err, test.want)
+		}
+	}
+}
+
+func TestExecConstructAndDescribe(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{
+			`PREFIX ex: <http://ex.org/>
+CONSTRUCT { ?p ex:wrote ?w } WHERE { ?w ex:creator ?p }`,
+			`<http://ex.org/hamsun> <http://ex.org/wrote> <http://ex.org/sult> .
+<http://ex.org/undset> <http://ex.org/wrote> <http://ex.org/kristin> .`,
+		},
+		{
+			`DESCRIBE <http://ex.org/sult>`,
+			`<http://ex.org/sult> <http://ex.org/creator> <http://ex.org/hamsun> .
+<http://ex.org/sult> <http://ex.org/title> "Sult" .`,
+		},
+		{
+			`DESCRIBE ?w WHERE { ?w <http://ex.org/creator> <http://ex.org/undset> }`,
+			`<http://ex.org/kristin> <http://ex.org/creator> <http://ex.org/undset> .`,
+		},
+	}
+	for _, test := range tests {
+		res, err := Exec(testDB, test.q)
+		want := rdf.Load(bytes.NewBufferString(test.want))
+		if err != nil || !res.Graph.Eq(want) {
+			t.Errorf("Exec(%q) == %v, %v; want %v, <nil>", test.q, res.Graph, err, want)
+		}
+	}
+}
+
+func bindingString(b malle.Binding) string {
+	vars := make([]string, 0, len(b))
+	for v := range b {
+		vars = append(vars, string(v))
+	}
+	sort.Strings(vars)
+	s := make([]string, len(vars))
+	for i, v := range vars {
+		s[i] = malle.Variable(v).String() + "=" + b[malle.Variable(v)].String()
+	}
+	return strings.Join(s, " ")
+}
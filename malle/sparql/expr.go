@@ -0,0 +1,433 @@
+package sparql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// Expr is an expression, as used in FILTER and ORDER BY.
+type Expr interface {
+	// Eval evaluates the expression given a solution's bindings.
+	Eval(b malle.Binding) (rdf.Term, error)
+}
+
+var (
+	errUnbound   = errors.New("sparql: unbound variable")
+	errType      = errors.New("sparql: type error")
+	literalTrue  = mustNewTypedLiteral("true", xsdBoolean)
+	literalFalse = mustNewTypedLiteral("false", xsdBoolean)
+)
+
+func mustNewTypedLiteral(val string, dt rdf.IRI) rdf.Literal {
+	l, err := rdf.NewTypedLiteral(val, dt)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func boolean(b bool) rdf.Term {
+	if b {
+		return literalTrue
+	}
+	return literalFalse
+}
+
+type exprVar malle.Variable
+
+func (e exprVar) Eval(b malle.Binding) (rdf.Term, error) {
+	if t, ok := b[malle.Variable(e)]; ok {
+		return t, nil
+	}
+	return nil, errUnbound
+}
+
+type exprTerm struct {
+	t rdf.Term
+}
+
+func (e exprTerm) Eval(b malle.Binding) (rdf.Term, error) {
+	return e.t, nil
+}
+
+// emptyString is the empty string literal. Literals cannot be empty, since
+// they cannot be stored, but the empty string can be the value of an
+// expression, ex LANG of a literal without language tag.
+type emptyString struct{}
+
+func (emptyString) Bytes() []byte        { return nil }
+func (emptyString) String() string       { return `""` }
+func (emptyString) Value() interface{}   { return "" }
+func (e emptyString) Eq(o rdf.Term) bool { return o != nil && o.String() == e.String() }
+
+type exprNot struct {
+	e Expr
+}
+
+func (e exprNot) Eval(b malle.Binding) (rdf.Term, error) {
+	v, err := ebv(e.e, b)
+	if err != nil {
+		return nil, err
+	}
+	return boolean(!v), nil
+}
+
+type exprBinary struct {
+	op   string
+	l, r Expr
+}
+
+func (e exprBinary) Eval(b malle.Binding) (rdf.Term, error) {
+	switch e.op {
+	case "||":
+		// An error in one operand is ignored if the other is true.
+		l, lerr := ebv(e.l, b)
+		if lerr == nil && l {
+			return literalTrue, nil
+		}
+		r, rerr := ebv(e.r, b)
+		if rerr == nil && r {
+			return literalTrue, nil
+		}
+		if lerr != nil {
+			return nil, lerr
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+		return literalFalse, nil
+	case "&&":
+		// An error in one operand is ignored if the other is false.
+		l, lerr := ebv(e.l, b)
+		if lerr == nil && !l {
+			return literalFalse, nil
+		}
+		r, rerr := ebv(e.r, b)
+		if rerr == nil && !r {
+			return literalFalse, nil
+		}
+		if lerr != nil {
+			return nil, lerr
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+		return literalTrue, nil
+	}
+
+	l, err := e.l.Eval(b)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.r.Eval(b)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "=":
+		eq, err := equal(l, r)
+		return boolean(eq), err
+	case "!=":
+		eq, err := equal(l, r)
+		return boolean(!eq), err
+	}
+	c, err := compare(l, r)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "<":
+		return boolean(c < 0), nil
+	case ">":
+		return boolean(c > 0), nil
+	case "<=":
+		return boolean(c <= 0), nil
+	case ">=":
+		return boolean(c >= 0), nil
+	}
+	panic("exprBinary.Eval: unknown operator " + e.op)
+}
+
+// functions lists the supported functions with their minimum and maximum
+// number of arguments.
+var functions = map[string][2]int{
+	"BOUND":       {1, 1},
+	"STR":         {1, 1},
+	"LANG":        {1, 1},
+	"DATATYPE":    {1, 1},
+	"ISIRI":       {1, 1},
+	"ISURI":       {1, 1},
+	"ISLITERAL":   {1, 1},
+	"LCASE":       {1, 1},
+	"UCASE":       {1, 1},
+	"CONTAINS":    {2, 2},
+	"STRSTARTS":   {2, 2},
+	"STRENDS":     {2, 2},
+	"LANGMATCHES": {2, 2},
+	"REGEX":       {2, 3},
+}
+
+type exprCall struct {
+	name string
+	args []Expr
+}
+
+func (e exprCall) Eval(b malle.Binding) (rdf.Term, error) {
+	if e.name == "BOUND" {
+		_, ok := b[malle.Variable(e.args[0].(exprVar))]
+		return boolean(ok), nil
+	}
+
+	args := make([]rdf.Term, len(e.args))
+	for i, a := range e.args {
+		t, err := a.Eval(b)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = t
+	}
+
+	if _, ok := args[0].(emptyString); ok {
+		switch e.name {
+		case "STR", "LANG", "LCASE", "UCASE":
+			return emptyString{}, nil
+		case "DATATYPE":
+			return rdf.XSDString, nil
+		case "ISIRI", "ISURI":
+			return literalFalse, nil
+		case "ISLITERAL":
+			return literalTrue, nil
+		}
+	}
+
+	switch e.name {
+	case "STR":
+		return rdf.NewLiteral(lexical(args[0]))
+	case "LANG":
+		l, ok := args[0].(rdf.Literal)
+		if !ok {
+			return nil, errType
+		}
+		if l.Lang() == "" {
+			return emptyString{}, nil
+		}
+		return rdf.NewLiteral(l.Lang())
+	case "DATATYPE":
+		l, ok := args[0].(rdf.Literal)
+		if !ok {
+			return nil, errType
+		}
+		return l.DataType(), nil
+	case "ISIRI", "ISURI":
+		_, ok := args[0].(rdf.IRI)
+		return boolean(ok), nil
+	case "ISLITERAL":
+		_, ok := args[0].(rdf.Literal)
+		return boolean(ok), nil
+	case "LCASE", "UCASE":
+		l, ok := args[0].(rdf.Literal)
+		if !ok || !isString(l) {
+			return nil, errType
+		}
+		f := strings.ToLower
+		if e.name == "UCASE" {
+			f = strings.ToUpper
+		}
+		if l.Lang() != "" {
+			return rdf.NewLangLiteral(f(lexical(l)), l.Lang())
+		}
+		return rdf.NewLiteral(f(lexical(l)))
+	}
+
+	// The remaining functions take string arguments.
+	strs := make([]string, len(args))
+	for i, a := range args {
+		str, ok := stringValue(a)
+		if !ok {
+			return nil, errType
+		}
+		strs[i] = str
+	}
+	switch e.name {
+	case "CONTAINS":
+		return boolean(strings.Contains(strs[0], strs[1])), nil
+	case "STRSTARTS":
+		return boolean(strings.HasPrefix(strs[0], strs[1])), nil
+	case "STRENDS":
+		return boolean(strings.HasSuffix(strs[0], strs[1])), nil
+	case "LANGMATCHES":
+		tag, rng := strings.ToLower(strs[0]), strings.ToLower(strs[1])
+		if rng == "*" {
+			return boolean(tag != ""), nil
+		}
+		return boolean(tag == rng || strings.HasPrefix(tag, rng+"-")), nil
+	case "REGEX":
+		pattern := strs[1]
+		if len(strs) == 3 {
+			for _, f := range strs[2] {
+				switch f {
+				case 'i', 's', 'm':
+					pattern = "(?" + string(f) + ")" + pattern
+				default:
+					return nil, fmt.Errorf("sparql: unsupported regex flag: %q", f)
+				}
+			}
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return boolean(re.MatchString(strs[0])), nil
+	}
+	panic("exprCall.Eval: unknown function " + e.name)
+}
+
+// ebv evaluates an expression and returns its effective boolean value.
+func ebv(e Expr, b malle.Binding) (bool, error) {
+	t, err := e.Eval(b)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := t.(emptyString); ok {
+		return false, nil
+	}
+	l, ok := t.(rdf.Literal)
+	if !ok {
+		return false, errType
+	}
+	switch {
+	case l.DataType() == xsdBoolean:
+		return lexical(l) == "true" || lexical(l) == "1", nil
+	case isString(l):
+		return lexical(l) != "", nil
+	case isNumeric(l):
+		f, err := strconv.ParseFloat(lexical(l), 64)
+		if err != nil {
+			return false, nil
+		}
+		return f != 0, nil
+	}
+	return false, errType
+}
+
+// lexical returns the lexical form of a term.
+func lexical(t rdf.Term) string {
+	switch t := t.(type) {
+	case rdf.IRI:
+		return string(t)
+	case rdf.Literal:
+		return fmt.Sprint(t.Value())
+	}
+	return t.String()
+}
+
+// stringValue returns the lexical form of a simple or language tagged
+// string literal, or of the empty string.
+func stringValue(t rdf.Term) (string, bool) {
+	switch t := t.(type) {
+	case emptyString:
+		return "", true
+	case rdf.Literal:
+		if isString(t) {
+			return lexical(t), true
+		}
+	}
+	return "", false
+}
+
+func isString(l rdf.Literal) bool {
+	return l.DataType() == rdf.XSDString || l.DataType() == rdf.RDFLangString
+}
+
+func isNumeric(l rdf.Literal) bool {
+	dt := string(l.DataType())
+	if !strings.HasPrefix(dt, "http://www.w3.org/2001/XMLSchema#") {
+		return false
+	}
+	switch strings.TrimPrefix(dt, "http://www.w3.org/2001/XMLSchema#") {
+	case "integer", "decimal", "double", "float", "long", "int", "short", "byte",
+		"unsignedLong", "unsignedInt", "unsignedShort", "unsignedByte",
+		"positiveInteger", "nonNegativeInteger", "negativeInteger", "nonPositiveInteger":
+		return true
+	}
+	return false
+}
+
+// equal tests two terms for equality. Numeric literals are compared by
+// value, other terms by identity.
+func equal(a, b rdf.Term) (bool, error) {
+	la, ok1 := a.(rdf.Literal)
+	lb, ok2 := b.(rdf.Literal)
+	if ok1 && ok2 && isNumeric(la) && isNumeric(lb) {
+		c, err := compare(a, b)
+		return c == 0, err
+	}
+	return a.Eq(b), nil
+}
+
+// compare compares two literals of compatible types, returning -1, 0 or 1.
+// Numeric literals are compared by value, strings and other literals of the
+// same datatype (ex dates) by their lexical form.
+func compare(a, b rdf.Term) (int, error) {
+	if sa, ok := stringValue(a); ok {
+		if sb, ok := stringValue(b); ok {
+			return strings.Compare(sa, sb), nil
+		}
+	}
+	la, ok1 := a.(rdf.Literal)
+	lb, ok2 := b.(rdf.Literal)
+	if !ok1 || !ok2 {
+		return 0, errType
+	}
+	switch {
+	case isNumeric(la) && isNumeric(lb):
+		fa, err := strconv.ParseFloat(lexical(la), 64)
+		if err != nil {
+			return 0, errType
+		}
+		fb, err := strconv.ParseFloat(lexical(lb), 64)
+		if err != nil {
+			return 0, errType
+		}
+		switch {
+		case fa < fb:
+			return -1, nil
+		case fa > fb:
+			return 1, nil
+		}
+		return 0, nil
+	case la.DataType() == lb.DataType():
+		return strings.Compare(lexical(la), lexical(lb)), nil
+	}
+	return 0, errType
+}
+
+// orderCompare compares two possibly unbound terms for ORDER BY; unbound
+// sorts before IRIs, which sort before literals.
+func orderCompare(a, b rdf.Term) int {
+	rank := func(t rdf.Term) int {
+		switch t.(type) {
+		case nil:
+			return 0
+		case rdf.IRI:
+			return 1
+		}
+		return 2
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+	if a == nil {
+		return 0
+	}
+	if c, err := compare(a, b); err == nil {
+		return c
+	}
+	return strings.Compare(a.String(), b.String())
+}
@@ -0,0 +1,262 @@
+package sparql
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// Media types of the supported result formats.
+const (
+	MediaTypeJSON     = "application/sparql-results+json"
+	MediaTypeXML      = "application/sparql-results+xml"
+	MediaTypeCSV      = "text/csv"
+	MediaTypeTSV      = "text/tab-separated-values"
+	MediaTypeNTriples = "application/n-triples"
+)
+
+// ErrFormat is returned when a result cannot be encoded in the requested format.
+var ErrFormat = errors.New("sparql: result form cannot be encoded in the requested format")
+
+// MediaTypes returns the media types which the result can be encoded in,
+// the preferred one first.
+func (r *Result) MediaTypes() []string {
+	switch r.Form {
+	case Select:
+		return []string{MediaTypeJSON, MediaTypeXML, MediaTypeCSV, MediaTypeTSV}
+	case Ask:
+		return []string{MediaTypeJSON, MediaTypeXML}
+	default:
+		return []string{MediaTypeNTriples}
+	}
+}
+
+// Encode writes the result to the stream in the format of the given media type.
+func (r *Result) Encode(w io.Writer, mediaType string) error {
+	switch mediaType {
+	case MediaTypeJSON:
+		return r.EncodeJSON(w)
+	case MediaTypeXML:
+		return r.EncodeXML(w)
+	case MediaTypeCSV:
+		return r.EncodeCSV(w)
+	case MediaTypeTSV:
+		return r.EncodeTSV(w)
+	case MediaTypeNTriples:
+		return r.EncodeNTriples(w)
+	}
+	return ErrFormat
+}
+
+type jsonTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Lang     string `json:"xml:lang,omitempty"`
+	Datatype string `json:"datatype,omitempty"`
+}
+
+type jsonResults struct {
+	Head struct {
+		Vars []string `json:"vars,omitempty"`
+	} `json:"head"`
+	Results *struct {
+		Bindings []map[string]jsonTerm `json:"bindings"`
+	} `json:"results,omitempty"`
+	Boolean *bool `json:"boolean,omitempty"`
+}
+
+// EncodeJSON writes a SELECT or ASK result in the SPARQL 1.1 Query Results
+// JSON Format.
+func (r *Result) EncodeJSON(w io.Writer) error {
+	var res jsonResults
+	switch r.Form {
+	case Ask:
+		res.Boolean = &r.Boolean
+	case Select:
+		res.Head.Vars = varNames(r.Vars)
+		res.Results = &struct {
+			Bindings []map[string]jsonTerm `json:"bindings"`
+		}{Bindings: make([]map[string]jsonTerm, len(r.Bindings))}
+		for i, b := range r.Bindings {
+			m := make(map[string]jsonTerm, len(b))
+			for v, t := range b {
+				m[string(v)] = toJSONTerm(t)
+			}
+			res.Results.Bindings[i] = m
+		}
+	default:
+		return ErrFormat
+	}
+	return json.NewEncoder(w).Encode(res)
+}
+
+func toJSONTerm(t rdf.Term) jsonTerm {
+	switch t := t.(type) {
+	case rdf.IRI:
+		return jsonTerm{Type: "uri", Value: string(t)}
+	case rdf.Literal:
+		jt := jsonTerm{Type: "literal", Value: lexical(t), Lang: t.Lang()}
+		if t.Lang() == "" && t.DataType() != rdf.XSDString {
+			jt.Datatype = string(t.DataType())
+		}
+		return jt
+	}
+	panic("toJSONTerm: unreachable")
+}
+
+type xmlResults struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/sparql-results# sparql"`
+	Head    struct {
+		Vars []xmlVar `xml:"variable"`
+	} `xml:"head"`
+	Results *struct {
+		Results []xmlResult `xml:"result"`
+	} `xml:"results"`
+	Boolean *bool `xml:"boolean"`
+}
+
+type xmlVar struct {
+	Name string `xml:"name,attr"`
+}
+
+type xmlResult struct {
+	Bindings []xmlBinding `xml:"binding"`
+}
+
+type xmlBinding struct {
+	Name    string      `xml:"name,attr"`
+	URI     string      `xml:"uri,omitempty"`
+	Literal *xmlLiteral `xml:"literal,omitempty"`
+}
+
+type xmlLiteral struct {
+	Lang     string `xml:"xml:lang,attr,omitempty"`
+	Datatype string `xml:"datatype,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+// EncodeXML writes a SELECT or ASK result in the SPARQL Query Results XML Format.
+func (r *Result) EncodeXML(w io.Writer) error {
+	var res xmlResults
+	switch r.Form {
+	case Ask:
+		res.Boolean = &r.Boolean
+	case Select:
+		for _, v := range varNames(r.Vars) {
+			res.Head.Vars = append(res.Head.Vars, xmlVar{v})
+		}
+		res.Results = &struct {
+			Results []xmlResult `xml:"result"`
+		}{Results: make([]xmlResult, len(r.Bindings))}
+		results := res.Results.Results
+		for i, b := range r.Bindings {
+			for _, v := range r.Vars {
+				t, ok := b[v]
+				if !ok {
+					continue
+				}
+				xb := xmlBinding{Name: string(v)}
+				switch t := t.(type) {
+				case rdf.IRI:
+					xb.URI = string(t)
+				case rdf.Literal:
+					xb.Literal = &xmlLiteral{Value: lexical(t), Lang: t.Lang()}
+					if t.Lang() == "" && t.DataType() != rdf.XSDString {
+						xb.Literal.Datatype = string(t.DataType())
+					}
+				}
+				results[i].Bindings = append(results[i].Bindings, xb)
+			}
+		}
+	default:
+		return ErrFormat
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(res)
+}
+
+// EncodeCSV writes a SELECT result in the SPARQL 1.1 Query Results CSV Format.
+// Only the lexical form of the terms are written.
+func (r *Result) EncodeCSV(w io.Writer) error {
+	if r.Form != Select {
+		return ErrFormat
+	}
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	if err := cw.Write(varNames(r.Vars)); err != nil {
+		return err
+	}
+	row := make([]string, len(r.Vars))
+	for _, b := range r.Bindings {
+		for i, v := range r.Vars {
+			row[i] = ""
+			if t, ok := b[v]; ok {
+				row[i] = lexical(t)
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// tsvEscaper escapes the characters not allowed in a TSV value.
+var tsvEscaper = strings.NewReplacer("\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// EncodeTSV writes a SELECT result in the SPARQL 1.1 Query Results TSV Format.
+// The terms are written in N-Triples syntax.
+func (r *Result) EncodeTSV(w io.Writer) error {
+	if r.Form != Select {
+		return ErrFormat
+	}
+	row := make([]string, len(r.Vars))
+	for i, v := range r.Vars {
+		row[i] = v.String()
+	}
+	if _, err := io.WriteString(w, strings.Join(row, "\t")+"\n"); err != nil {
+		return err
+	}
+	for _, b := range r.Bindings {
+		for i, v := range r.Vars {
+			row[i] = ""
+			if t, ok := b[v]; ok {
+				row[i] = tsvEscaper.Replace(t.String())
+			}
+		}
+		if _, err := io.WriteString(w, strings.Join(row, "\t")+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeNTriples writes a CONSTRUCT or DESCRIBE result as N-Triples.
+func (r *Result) EncodeNTriples(w io.Writer) error {
+	if r.Form != Construct && r.Form != Describe {
+		return ErrFormat
+	}
+	for _, tr := range r.Graph.Triples() {
+		if _, err := io.WriteString(w, tr.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func varNames(vars []malle.Variable) []string {
+	names := make([]string, len(vars))
+	for i, v := range vars {
+		names[i] = string(v)
+	}
+	return names
+}
@@ -0,0 +1,58 @@
+package sparql
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	res, err := Exec(testDB, `SELECT ?s ?name ?born { ?s <http://ex.org/name> ?name OPTIONAL { ?s <http://ex.org/born> ?born } } ORDER BY ?s LIMIT 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ask, err := Exec(testDB, `ASK { ?s ?p ?o }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	construct, err := Exec(testDB, `CONSTRUCT { ?s <http://ex.org/named> ?name } { ?s <http://ex.org/name> ?name FILTER(?s = <http://ex.org/ibsen>)}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		res       *Result
+		mediaType string
+		want      string
+	}{
+		{res, MediaTypeJSON, `{"head":{"vars":["s","name","born"]},"results":{"bindings":[` +
+			`{"born":{"type":"literal","value":"1859","datatype":"http://www.w3.org/2001/XMLSchema#integer"},"name":{"type":"literal","value":"Hamsun, Knut"},"s":{"type":"uri","value":"http://ex.org/hamsun"}},` +
+			`{"name":{"type":"literal","value":"Ibsen, Henrik","xml:lang":"no"},"s":{"type":"uri","value":"http://ex.org/ibsen"}}]}}` + "\n"},
+		{ask, MediaTypeJSON, `{"head":{},"boolean":true}` + "\n"},
+		{res, MediaTypeXML, `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+			`<sparql xmlns="http://www.w3.org/2005/sparql-results#"><head><variable name="s"></variable><variable name="name"></variable><variable name="born"></variable></head><results>` +
+			`<result><binding name="s"><uri>http://ex.org/hamsun</uri></binding><binding name="name"><literal>Hamsun, Knut</literal></binding><binding name="born"><literal datatype="http://www.w3.org/2001/XMLSchema#integer">1859</literal></binding></result>` +
+			`<result><binding name="s"><uri>http://ex.org/ibsen</uri></binding><binding name="name"><literal xml:lang="no">Ibsen, Henrik</literal></binding></result>` +
+			`</results></sparql>`},
+		{ask, MediaTypeXML, `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+			`<sparql xmlns="http://www.w3.org/2005/sparql-results#"><head></head><boolean>true</boolean></sparql>`},
+		{res, MediaTypeCSV, "s,name,born\r\nhttp://ex.org/hamsun,\"Hamsun, Knut\",1859\r\nhttp://ex.org/ibsen,\"Ibsen, Henrik\",\r\n"},
+		{res, MediaTypeTSV, "?s\t?name\t?born\n" +
+			"<http://ex.org/hamsun>\t\"Hamsun, Knut\"\t\"1859\"^^<http://www.w3.org/2001/XMLSchema#integer>\n" +
+			"<http://ex.org/ibsen>\t\"Ibsen, Henrik\"@no\t\n"},
+		{construct, MediaTypeNTriples, "<http://ex.org/ibsen> <http://ex.org/named> \"Ibsen, Henrik\"@no .\n"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := test.res.Encode(&b, test.mediaType); err != nil {
+			t.Errorf("Result.Encode(%s) == %v; want no error", test.mediaType, err)
+			continue
+		}
+		if b.String() != test.want {
+			t.Errorf("Result.Encode(%s) ==\n%s\nwant:\n%s", test.mediaType, b.String(), test.want)
+		}
+	}
+
+	if err := ask.Encode(&bytes.Buffer{}, MediaTypeCSV); err != ErrFormat {
+		t.Errorf("Result.Encode(%s) of ASK result == %v; want ErrFormat", MediaTypeCSV, err)
+	}
+}
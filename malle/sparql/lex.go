@@ -0,0 +1,333 @@
+package sparql
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type token struct {
+	Typ   tokenType
+	value string
+}
+
+type tokenType int
+
+const eof = -1
+
+const (
+	tokenEOF tokenType = iota
+	tokenError
+	tokenIRI
+	tokenPName
+	tokenVar
+	tokenLiteral
+	tokenLang
+	tokenDTMarker
+	tokenInteger
+	tokenDecimal
+	tokenDouble
+	tokenKeyword
+	tokenPunct
+	tokenOp
+)
+
+func (t tokenType) String() string {
+	switch t {
+	case tokenEOF:
+		return "EOF"
+	case tokenError:
+		return "error"
+	case tokenIRI:
+		return "IRI"
+	case tokenPName:
+		return "prefixed name"
+	case tokenVar:
+		return "variable"
+	case tokenLiteral:
+		return "literal"
+	case tokenLang:
+		return "language tag"
+	case tokenDTMarker:
+		return "datatype marker (^^)"
+	case tokenInteger:
+		return "integer"
+	case tokenDecimal:
+		return "decimal"
+	case tokenDouble:
+		return "double"
+	case tokenKeyword:
+		return "keyword"
+	case tokenPunct:
+		return "punctuation"
+	case tokenOp:
+		return "operator"
+	default:
+		return fmt.Sprintf("tokenType(%d)", int(t))
+	}
+}
+
+// lexer tokenizes a SPARQL query string.
+type lexer struct {
+	input string
+	line  int // current line number
+	pos   int // position in input (in bytes, not runes)
+	start int // start of current token
+	width int // width of last rune read
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{input: input, line: 1}
+}
+
+func (l *lexer) readRune() rune {
+	if l.pos >= len(l.input) {
+		l.width = 0
+		return eof
+	}
+	r, w := utf8.DecodeRuneInString(l.input[l.pos:])
+	l.width = w
+	l.pos += w
+	if r == '\n' {
+		l.line++
+	}
+	return r
+}
+
+func (l *lexer) peekRune() rune {
+	if l.pos >= len(l.input) {
+		return eof
+	}
+	r, _ := utf8.DecodeRuneInString(l.input[l.pos:])
+	return r
+}
+
+// backup steps back one rune. Can only be called once per call of readRune.
+func (l *lexer) backup() {
+	l.pos -= l.width
+	if l.width == 1 && l.input[l.pos] == '\n' {
+		l.line--
+	}
+}
+
+func (l *lexer) emit(typ tokenType) token {
+	return l.emitValue(typ, l.input[l.start:l.pos])
+}
+
+func (l *lexer) emitValue(typ tokenType, val string) token {
+	l.start = l.pos
+	return token{Typ: typ, value: val}
+}
+
+func (l *lexer) error(msg string) token {
+	errMsg := fmt.Sprintf("%d: %s: %q", l.line, msg, l.input[l.start:l.pos])
+	l.start = l.pos
+	return token{Typ: tokenError, value: errMsg}
+}
+
+func (l *lexer) ignore() {
+	l.start = l.pos
+}
+
+// acceptRun consumes a run of runes satisfying the given function.
+func (l *lexer) acceptRun(valid func(rune) bool) {
+	for r := l.readRune(); r != eof && valid(r); r = l.readRune() {
+	}
+	l.backup()
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || r == '-' || r == '.' || r == ':' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isVarRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// isIRIRef checks if the input at the current position (just after '<') is
+// an IRI reference, as opposed to a less-than operator.
+func (l *lexer) isIRIRef() bool {
+	for _, r := range l.input[l.pos:] {
+		switch {
+		case r == '>':
+			return true
+		case r <= ' ', strings.ContainsRune("<\"{}|^`\\", r):
+			return false
+		}
+	}
+	return false
+}
+
+func (l *lexer) next() token {
+	for {
+		r := l.readRune()
+		switch {
+		case r == eof:
+			return l.emit(tokenEOF)
+		case unicode.IsSpace(r):
+			l.ignore()
+		case r == '#':
+			// comments are ignored and not emitted
+			for r = l.readRune(); r != '\n' && r != eof; r = l.readRune() {
+			}
+			l.ignore()
+		case r == '<':
+			if !l.isIRIRef() {
+				if l.peekRune() == '=' {
+					l.readRune()
+				}
+				return l.emit(tokenOp)
+			}
+			l.ignore() // ignore <
+			for r = l.readRune(); r != '>'; r = l.readRune() {
+			}
+			return l.emitValue(tokenIRI, l.input[l.start:l.pos-1])
+		case r == '>':
+			if l.peekRune() == '=' {
+				l.readRune()
+			}
+			return l.emit(tokenOp)
+		case r == '=':
+			return l.emit(tokenOp)
+		case r == '!':
+			if l.peekRune() == '=' {
+				l.readRune()
+			}
+			return l.emit(tokenOp)
+		case r == '&' || r == '|':
+			if l.readRune() != r {
+				return l.error("unexpected token")
+			}
+			return l.emit(tokenOp)
+		case r == '^':
+			if l.readRune() != '^' {
+				return l.error("unexpected token")
+			}
+			return l.emit(tokenDTMarker)
+		case r == '?' || r == '$':
+			l.ignore() // ignore ? or $
+			l.acceptRun(isVarRune)
+			if l.pos == l.start {
+				return l.error("empty variable name")
+			}
+			return l.emit(tokenVar)
+		case r == '"' || r == '\'':
+			return l.lexString(r)
+		case r == '@':
+			l.ignore() // ignore @
+			l.acceptRun(func(r rune) bool { return r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r) })
+			if l.pos == l.start {
+				return l.error("empty language tag")
+			}
+			return l.emit(tokenLang)
+		case isDigit(r) || ((r == '+' || r == '-') && isDigit(l.peekRune())):
+			return l.lexNumber()
+		case strings.ContainsRune("{}().;,*", r):
+			if r == '.' && isDigit(l.peekRune()) {
+				return l.lexNumber()
+			}
+			return l.emit(tokenPunct)
+		case r == '+' || r == '-' || r == '/':
+			return l.emit(tokenOp)
+		case r == ':' || unicode.IsLetter(r):
+			l.acceptRun(isNameRune)
+			// a name cannot end with a dot; it terminates the triple pattern
+			for l.input[l.pos-1] == '.' {
+				l.pos--
+			}
+			if strings.ContainsRune(l.input[l.start:l.pos], ':') {
+				return l.emit(tokenPName)
+			}
+			return l.emit(tokenKeyword)
+		default:
+			return l.error("unexpected token")
+		}
+	}
+}
+
+func (l *lexer) lexNumber() token {
+	typ := tokenInteger
+	l.acceptRun(isDigit)
+	if l.peekRune() == '.' {
+		l.readRune()
+		if isDigit(l.peekRune()) {
+			typ = tokenDecimal
+			l.acceptRun(isDigit)
+		} else {
+			// the dot terminates the triple pattern
+			l.backup()
+		}
+	}
+	if r := l.peekRune(); r == 'e' || r == 'E' {
+		typ = tokenDouble
+		l.readRune()
+		if r = l.peekRune(); r == '+' || r == '-' {
+			l.readRune()
+		}
+		p := l.pos
+		l.acceptRun(isDigit)
+		if l.pos == p {
+			return l.error("malformed double")
+		}
+	}
+	return l.emit(typ)
+}
+
+func (l *lexer) lexString(quote rune) token {
+	var buf bytes.Buffer
+	for {
+		r := l.readRune()
+		switch r {
+		case eof, '\n':
+			return l.error("unclosed literal")
+		case quote:
+			return l.emitValue(tokenLiteral, buf.String())
+		case '\\':
+			r = l.readRune()
+			switch r {
+			case 't':
+				buf.WriteByte('\t')
+			case 'b':
+				buf.WriteByte('\b')
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 'f':
+				buf.WriteByte('\f')
+			case '"', '\'', '\\':
+				buf.WriteRune(r)
+			case 'u', 'U':
+				digits := 4
+				if r == 'U' {
+					digits = 8
+				}
+				var d rune
+				for i := 0; i < digits; i++ {
+					x := l.readRune()
+					switch {
+					case x >= '0' && x <= '9':
+						d = d*16 + x - '0'
+					case x >= 'a' && x <= 'f':
+						d = d*16 + x - 'a' + 10
+					case x >= 'A' && x <= 'F':
+						d = d*16 + x - 'A' + 10
+					default:
+						return l.error("illegal escape sequence")
+					}
+				}
+				buf.WriteRune(d)
+			default:
+				return l.error("illegal escape sequence")
+			}
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package sparql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// parser is a recursive descent parser of SPARQL queries.
+type parser struct {
+	tokens   []token
+	pos      int
+	prefixes rdf.Prefixes
+}
+
+// Parse parses a SPARQL query.
+func Parse(query string) (*Query, error) {
+	l := newLexer(query)
+	p := &parser{prefixes: make(rdf.Prefixes)}
+	for {
+		tok := l.next()
+		if tok.Typ == tokenError {
+			return nil, errors.New(tok.value)
+		}
+		p.tokens = append(p.tokens, tok)
+		if tok.Typ == tokenEOF {
+			break
+		}
+	}
+	return p.parseQuery()
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		// the last token is always EOF
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// isKeyword checks if the token is the given (case-insensitive) keyword.
+func isKeyword(tok token, kw string) bool {
+	return tok.Typ == tokenKeyword && strings.EqualFold(tok.value, kw)
+}
+
+func isPunct(tok token, punct string) bool {
+	return tok.Typ == tokenPunct && tok.value == punct
+}
+
+// acceptKeyword consumes the next token if it is the given keyword.
+func (p *parser) acceptKeyword(kw string) bool {
+	if isKeyword(p.peek(), kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// acceptPunct consumes the next token if it is the given punctuation.
+func (p *parser) acceptPunct(punct string) bool {
+	if isPunct(p.peek(), punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.unexpected(kw)
+	}
+	return nil
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if !p.acceptPunct(punct) {
+		return p.unexpected(fmt.Sprintf("%q", punct))
+	}
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	tok := p.peek()
+	if tok.Typ == tokenEOF {
+		return fmt.Errorf("sparql: expected %s, got EOF", want)
+	}
+	return fmt.Errorf("sparql: expected %s, got %s %q", want, tok.Typ, tok.value)
+}
+
+func (p *parser) parseQuery() (*Query, error) {
+	// Prologue
+	for p.acceptKeyword("PREFIX") {
+		tok := p.next()
+		if tok.Typ != tokenPName || !strings.HasSuffix(tok.value, ":") {
+			p.pos--
+			return nil, p.unexpected("prefix name")
+		}
+		ns := p.next()
+		if ns.Typ != tokenIRI {
+			p.pos--
+			return nil, p.unexpected("IRI")
+		}
+		p.prefixes[strings.TrimSuffix(tok.value, ":")] = rdf.IRI(ns.value)
+	}
+
+	q := &Query{Limit: -1}
+	var err error
+	tok := p.next()
+	switch {
+	case isKeyword(tok, "SELECT"):
+		q.Form = Select
+		q.Distinct = p.acceptKeyword("DISTINCT") || p.acceptKeyword("REDUCED")
+		if !p.acceptPunct("*") {
+			for p.peek().Typ == tokenVar {
+				q.Vars = append(q.Vars, malle.Variable(p.next().value))
+			}
+			if len(q.Vars) == 0 {
+				return nil, p.unexpected("variable or \"*\"")
+			}
+		}
+		err = p.parseWhere(q, false)
+	case isKeyword(tok, "CONSTRUCT"):
+		q.Form = Construct
+		if err = p.expectPunct("{"); err != nil {
+			return nil, err
+		}
+		for !p.acceptPunct("}") {
+			if p.acceptPunct(".") {
+				continue
+			}
+			if err = p.parseTriples(&q.Template); err != nil {
+				return nil, err
+			}
+		}
+		err = p.parseWhere(q, false)
+	case isKeyword(tok, "ASK"):
+		q.Form = Ask
+		err = p.parseWhere(q, false)
+	case isKeyword(tok, "DESCRIBE"):
+		q.Form = Describe
+		if !p.acceptPunct("*") {
+			for {
+				tok := p.peek()
+				if tok.Typ != tokenVar && tok.Typ != tokenIRI && tok.Typ != tokenPName {
+					break
+				}
+				t, err := p.parseTerm()
+				if err != nil {
+					return nil, err
+				}
+				q.Describe = append(q.Describe, t)
+			}
+			if len(q.Describe) == 0 {
+				return nil, p.unexpected("IRI, variable or \"*\"")
+			}
+		}
+		err = p.parseWhere(q, true)
+	default:
+		p.pos--
+		return nil, p.unexpected("SELECT, CONSTRUCT, ASK or DESCRIBE")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.parseModifiers(q); err != nil {
+		return nil, err
+	}
+	if p.peek().Typ != tokenEOF {
+		return nil, p.unexpected("EOF")
+	}
+	return q, nil
+}
+
+// parseWhere parses any FROM clauses followed by the WHERE clause. The WHERE
+// keyword is optional, and so is the whole clause if optional is true.
+func (p *parser) parseWhere(q *Query, optional bool) (err error) {
+	for p.acceptKeyword("FROM") {
+		if isKeyword(p.peek(), "NAMED") {
+			return errors.New("sparql: FROM NAMED is not supported")
+		}
+		t, err := p.parseTerm()
+		if err != nil {
+			return err
+		}
+		iri, ok := t.(rdf.IRI)
+		if !ok {
+			return errors.New("sparql: expected IRI in FROM clause")
+		}
+		q.From = append(q.From, iri)
+	}
+	if !p.acceptKeyword("WHERE") && optional && !isPunct(p.peek(), "{") {
+		q.Where = &Group{}
+		return nil
+	}
+	q.Where, err = p.parseGroup()
+	return err
+}
+
+func (p *parser) parseGroup() (*Group, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	g := &Group{}
+	for !p.acceptPunct("}") {
+		switch tok := p.peek(); {
+		case tok.Typ == tokenEOF:
+			return nil, p.unexpected("\"}\"")
+		case isPunct(tok, "."):
+			p.next()
+		case isKeyword(tok, "FILTER"):
+			p.next()
+			e, err := p.parseConstraint()
+			if err != nil {
+				return nil, err
+			}
+			g.Filters = append(g.Filters, e)
+		case isKeyword(tok, "OPTIONAL"):
+			p.next()
+			opt, err := p.parseGroup()
+			if err != nil {
+				return nil, err
+			}
+			g.Optionals = append(g.Optionals, opt)
+		default:
+			if err := p.parseTriples(&g.Patterns); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return g, nil
+}
+
+// parseTriples parses a subject with its predicate-object lists.
+func (p *parser) parseTriples(patterns *[]malle.Pattern) error {
+	subj, err := p.parseTerm()
+	if err != nil {
+		return err
+	}
+	if _, ok := subj.(rdf.Literal); ok {
+		return errors.New("sparql: a literal cannot be subject")
+	}
+	for {
+		var pred rdf.Term
+		if p.acceptKeyword("a") {
+			pred = rdfType
+		} else {
+			pred, err = p.parseTerm()
+			if err != nil {
+				return err
+			}
+			if _, ok := pred.(rdf.Literal); ok {
+				return errors.New("sparql: a literal cannot be predicate")
+			}
+		}
+		for {
+			obj, err := p.parseTerm()
+			if err != nil {
+				return err
+			}
+			*patterns = append(*patterns, malle.Pattern{Subject: subj, Predicate: pred, Object: obj})
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		if !p.acceptPunct(";") {
+			return nil
+		}
+		// allow trailing semicolon
+		if tok := p.peek(); isPunct(tok, ".") || isPunct(tok, "}") {
+			return nil
+		}
+	}
+}
+
+// parseTerm parses a variable, IRI or literal.
+func (p *parser) parseTerm() (rdf.Term, error) {
+	tok := p.next()
+	switch tok.Typ {
+	case tokenVar:
+		return malle.Variable(tok.value), nil
+	case tokenIRI:
+		return rdf.NewIRI(tok.value)
+	case tokenPName:
+		return p.expandPName(tok.value)
+	case tokenLiteral:
+		if tok.value == "" {
+			return nil, errors.New("sparql: empty literals are not supported")
+		}
+		switch next := p.peek(); next.Typ {
+		case tokenLang:
+			p.next()
+			return rdf.NewLangLiteral(tok.value, next.value)
+		case tokenDTMarker:
+			p.next()
+			dt, err := p.parseTerm()
+			if err != nil {
+				return nil, err
+			}
+			iri, ok := dt.(rdf.IRI)
+			if !ok {
+				return nil, errors.New("sparql: expected IRI as literal datatype")
+			}
+			if iri == rdf.XSDString {
+				return rdf.NewLiteral(tok.value)
+			}
+			return rdf.NewTypedLiteral(tok.value, iri)
+		}
+		return rdf.NewLiteral(tok.value)
+	case tokenInteger:
+		return rdf.NewTypedLiteral(strings.TrimPrefix(tok.value, "+"), xsdInteger)
+	case tokenDecimal:
+		return rdf.NewTypedLiteral(strings.TrimPrefix(tok.value, "+"), xsdDecimal)
+	case tokenDouble:
+		return rdf.NewTypedLiteral(strings.TrimPrefix(tok.value, "+"), xsdDouble)
+	case tokenKeyword:
+		if strings.EqualFold(tok.value, "true") || strings.EqualFold(tok.value, "false") {
+			return rdf.NewTypedLiteral(strings.ToLower(tok.value), xsdBoolean)
+		}
+	}
+	p.pos--
+	return nil, p.unexpected("variable, IRI or literal")
+}
+
+func (p *parser) expandPName(pname string) (rdf.IRI, error) {
+	iri, ok := p.prefixes.Expand(pname)
+	if !ok {
+		return "", fmt.Errorf("sparql: undeclared prefix: %q", pname[:strings.Index(pname, ":")])
+	}
+	return rdf.NewIRI(string(iri))
+}
+
+func (p *parser) parseModifiers(q *Query) error {
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return err
+		}
+		for {
+			tok := p.peek()
+			var cond OrderCond
+			switch {
+			case isKeyword(tok, "ASC"), isKeyword(tok, "DESC"):
+				p.next()
+				cond.Desc = isKeyword(tok, "DESC")
+				e, err := p.parseBrackettedExpr()
+				if err != nil {
+					return err
+				}
+				cond.Expr = e
+			case tok.Typ == tokenVar:
+				p.next()
+				cond.Expr = exprVar(tok.value)
+			case isPunct(tok, "("):
+				e, err := p.parseBrackettedExpr()
+				if err != nil {
+					return err
+				}
+				cond.Expr = e
+			default:
+				if len(q.OrderBy) == 0 {
+					return p.unexpected("order condition")
+				}
+				goto limitOffset
+			}
+			q.OrderBy = append(q.OrderBy, cond)
+		}
+	}
+limitOffset:
+	for {
+		switch {
+		case p.acceptKeyword("LIMIT"):
+			n, err := p.parseInt()
+			if err != nil {
+				return err
+			}
+			q.Limit = n
+		case p.acceptKeyword("OFFSET"):
+			n, err := p.parseInt()
+			if err != nil {
+				return err
+			}
+			q.Offset = n
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) parseInt() (int, error) {
+	tok := p.next()
+	if tok.Typ != tokenInteger {
+		p.pos--
+		return 0, p.unexpected("integer")
+	}
+	n, err := strconv.Atoi(tok.value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("sparql: invalid integer: %q", tok.value)
+	}
+	return n, nil
+}
+
+// parseConstraint parses the constraint of a FILTER: either a bracketted
+// expression or a function call.
+func (p *parser) parseConstraint() (Expr, error) {
+	if isPunct(p.peek(), "(") {
+		return p.parseBrackettedExpr()
+	}
+	if p.peek().Typ == tokenKeyword {
+		return p.parsePrimary()
+	}
+	return nil, p.unexpected("\"(\" or function call")
+}
+
+func (p *parser) parseBrackettedExpr() (Expr, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// parseExpr parses an expression, with operators in increasing order of
+// precedence: ||, &&, comparison, unary !.
+func (p *parser) parseExpr() (Expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	l, err := p.parseRelational()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("&&") {
+		r, err := p.parseRelational()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseRelational() (Expr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.Typ != tokenOp {
+		return l, nil
+	}
+	switch tok.value {
+	case "=", "!=", "<", ">", "<=", ">=":
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprBinary{op: tok.value, l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.acceptOp("!") {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNot{e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if tok := p.peek(); tok.Typ == tokenOp && tok.value == op {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	switch {
+	case isPunct(tok, "("):
+		return p.parseBrackettedExpr()
+	case tok.Typ == tokenKeyword && !isKeyword(tok, "true") && !isKeyword(tok, "false"):
+		p.next()
+		name := strings.ToUpper(tok.value)
+		arity, ok := functions[name]
+		if !ok {
+			return nil, fmt.Errorf("sparql: unsupported function: %s", tok.value)
+		}
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		var args []Expr
+		for !p.acceptPunct(")") {
+			if len(args) > 0 {
+				if err := p.expectPunct(","); err != nil {
+					return nil, err
+				}
+			}
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, e)
+		}
+		if len(args) < arity[0] || len(args) > arity[1] {
+			return nil, fmt.Errorf("sparql: wrong number of arguments to %s: %d", name, len(args))
+		}
+		if name == "BOUND" {
+			if _, ok := args[0].(exprVar); !ok {
+				return nil, errors.New("sparql: BOUND takes a variable as argument")
+			}
+		}
+		return exprCall{name: name, args: args}, nil
+	case tok.Typ == tokenLiteral && tok.value == "":
+		p.next()
+		if next := p.peek(); next.Typ != tokenLang && next.Typ != tokenDTMarker {
+			return exprTerm{emptyString{}}, nil
+		}
+		p.pos-- // rejected by parseTerm
+	}
+	t, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := t.(malle.Variable); ok {
+		return exprVar(v), nil
+	}
+	return exprTerm{t}, nil
+}
@@ -0,0 +1,147 @@
+package sparql
+
+import (
+	"testing"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+func collect(l *lexer) []token {
+	tokens := []token{}
+	for {
+		tk := l.next()
+		if tk.Typ == tokenEOF {
+			break
+		}
+		tokens = append(tokens, tk)
+		if tk.Typ == tokenError {
+			break
+		}
+	}
+	return tokens
+}
+
+func TestLexer(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []token
+	}{
+		{"", []token{}},
+		{" # comment\n", []token{}},
+		{"SELECT ?x", []token{{tokenKeyword, "SELECT"}, {tokenVar, "x"}}},
+		{"?s a foaf:Person.", []token{
+			{tokenVar, "s"}, {tokenKeyword, "a"}, {tokenPName, "foaf:Person"}, {tokenPunct, "."}}},
+		{"<a> ?p \"x\\\"y\"@en .", []token{
+			{tokenIRI, "a"}, {tokenVar, "p"}, {tokenLiteral, "x\"y"}, {tokenLang, "en"}, {tokenPunct, "."}}},
+		{"'1'^^xsd:int", []token{{tokenLiteral, "1"}, {tokenDTMarker, "^^"}, {tokenPName, "xsd:int"}}},
+		{"1 2.5 3e2 -4 5.", []token{
+			{tokenInteger, "1"}, {tokenDecimal, "2.5"}, {tokenDouble, "3e2"}, {tokenInteger, "-4"},
+			{tokenInteger, "5"}, {tokenPunct, "."}}},
+		{"(?a<?b && ?c >= 2 || !?d)", []token{
+			{tokenPunct, "("}, {tokenVar, "a"}, {tokenOp, "<"}, {tokenVar, "b"}, {tokenOp, "&&"},
+			{tokenVar, "c"}, {tokenOp, ">="}, {tokenInteger, "2"}, {tokenOp, "||"}, {tokenOp, "!"},
+			{tokenVar, "d"}, {tokenPunct, ")"}}},
+		{"\"abc", []token{{tokenError, `1: unclosed literal: "\"abc"`}}},
+		{"?", []token{{tokenError, `1: empty variable name: ""`}}},
+	}
+
+	for _, test := range tests {
+		got := collect(newLexer(test.in))
+		if len(got) != len(test.want) {
+			t.Errorf("lex(%q) == %v; want %v", test.in, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("lex(%q) == %v; want %v", test.in, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	q, err := Parse(`PREFIX foaf: <http://xmlns.com/foaf/0.1/>
+SELECT DISTINCT ?p ?name
+WHERE {
+	?p a foaf:Person ;
+	   foaf:name ?name, "Knut"@no .
+	OPTIONAL { ?p foaf:age ?age FILTER(?age > 18) }
+	FILTER regex(?name, "^K", "i")
+}
+ORDER BY DESC(?name) ?p
+LIMIT 10 OFFSET 5`)
+	if err != nil {
+		t.Fatalf("Parse() == %v; want no error", err)
+	}
+	if q.Form != Select || !q.Distinct || q.Limit != 10 || q.Offset != 5 {
+		t.Errorf("Parse() == %+v; want DISTINCT SELECT with LIMIT 10 and OFFSET 5", q)
+	}
+	if len(q.Vars) != 2 || q.Vars[0] != malle.Variable("p") || q.Vars[1] != malle.Variable("name") {
+		t.Errorf("Parse() projected variables == %v; want [?p ?name]", q.Vars)
+	}
+	foafName := rdf.IRI("http://xmlns.com/foaf/0.1/name")
+	wantPatterns := []malle.Pattern{
+		{Subject: malle.Variable("p"), Predicate: rdfType, Object: rdf.IRI("http://xmlns.com/foaf/0.1/Person")},
+		{Subject: malle.Variable("p"), Predicate: foafName, Object: malle.Variable("name")},
+		{Subject: malle.Variable("p"), Predicate: foafName, Object: mustNewLangLiteral("Knut", "no")},
+	}
+	if len(q.Where.Patterns) != len(wantPatterns) {
+		t.Fatalf("Parse() patterns == %v; want %v", q.Where.Patterns, wantPatterns)
+	}
+	for i, pat := range q.Where.Patterns {
+		want := wantPatterns[i]
+		if !pat.Subject.Eq(want.Subject) || !pat.Predicate.Eq(want.Predicate) || !pat.Object.Eq(want.Object) {
+			t.Errorf("Parse() pattern %d == %v; want %v", i, pat, want)
+		}
+	}
+	if len(q.Where.Filters) != 1 || len(q.Where.Optionals) != 1 || len(q.Where.Optionals[0].Filters) != 1 {
+		t.Errorf("Parse() == %+v; want one FILTER and one OPTIONAL with one FILTER", q.Where)
+	}
+	if len(q.OrderBy) != 2 || !q.OrderBy[0].Desc || q.OrderBy[1].Desc {
+		t.Errorf("Parse() ORDER BY == %v; want DESC(?name) ?p", q.OrderBy)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"", "sparql: expected SELECT, CONSTRUCT, ASK or DESCRIBE, got EOF"},
+		{"SELECT WHERE {}", `sparql: expected variable or "*", got keyword "WHERE"`},
+		{"SELECT * { ?s ex:p ?o }", `sparql: undeclared prefix: "ex"`},
+		{"SELECT * { ?s <p> ?o ", `sparql: expected "}", got EOF`},
+		{"ASK { \"a\" <p> ?o }", "sparql: a literal cannot be subject"},
+		{"ASK { FILTER(nofunc(?x)) }", "sparql: unsupported function: nofunc"},
+		{"SELECT * {} LIMIT x", `sparql: expected integer, got keyword "x"`},
+		{"ASK {} ?x", `sparql: expected EOF, got variable "x"`},
+		{"ASK FROM NAMED <g> {}", "sparql: FROM NAMED is not supported"},
+		{"ASK { ?s <p> \"\" }", "sparql: empty literals are not supported"},
+		{"ASK { FILTER(?o = \"\"@en) }", "sparql: empty literals are not supported"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.q)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("Parse(%q) == %v; want %v", test.q, err, test.want)
+		}
+	}
+}
+
+func TestStringUnknown(t *testing.T) {
+	if got := tokenType(99).String(); got != "tokenType(99)" {
+		t.Errorf("tokenType(99).String() == %q; want \"tokenType(99)\"", got)
+	}
+	if got := Form(9).String(); got != "Form(9)" {
+		t.Errorf("Form(9).String() == %q; want \"Form(9)\"", got)
+	}
+}
+
+func mustNewLangLiteral(val, lang string) rdf.Literal {
+	l, err := rdf.NewLangLiteral(val, lang)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
@@ -0,0 +1,108 @@
+// Package sparql implements a subset of the SPARQL 1.1 query language,
+// executed against a malle triple store.
+//
+// Supported are the SELECT, CONSTRUCT, ASK and DESCRIBE query forms, with
+// PREFIX declarations, FROM clauses, basic graph patterns, FILTER, OPTIONAL
+// and the solution modifiers DISTINCT, ORDER BY, LIMIT and OFFSET.
+//
+// Notes and (possible) deviations from W3 specification:
+//   - Blank nodes, property paths, subqueries, UNION, MINUS, BIND, VALUES,
+//     aggregates, FROM NAMED and GRAPH are not supported.
+//   - FROM clauses restrict the query to the named graphs of the store; without
+//     them the query is evaluated against the union of all graphs.
+//   - The triple patterns of a group are evaluated together before any OPTIONAL
+//     in the group, regardless of their order in the query.
+//   - Empty literals are not supported in triple patterns, since they cannot
+//     be stored, but the empty string can be compared with in expressions,
+//     ex FILTER(LANG(?o) = "").
+//   - DESCRIBE returns the concise bounded description of each resource, of
+//     at most malle.MaxResults triples.
+package sparql
+
+import (
+	"fmt"
+
+	"github.com/boutros/x/malle"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// Form is the form of a SPARQL query.
+type Form int
+
+// Supported query forms
+const (
+	Select Form = iota
+	Construct
+	Ask
+	Describe
+)
+
+func (f Form) String() string {
+	switch f {
+	case Select:
+		return "SELECT"
+	case Construct:
+		return "CONSTRUCT"
+	case Ask:
+		return "ASK"
+	case Describe:
+		return "DESCRIBE"
+	default:
+		return fmt.Sprintf("Form(%d)", int(f))
+	}
+}
+
+// Query is a parsed SPARQL query, ready to be executed.
+type Query struct {
+	Form     Form
+	Distinct bool
+	Vars     []malle.Variable // projected variables; all variables if empty (SELECT *)
+	Template []malle.Pattern  // CONSTRUCT template
+	Describe []rdf.Term       // IRIs or variables to DESCRIBE; all variables if empty
+	From     []rdf.IRI        // graphs to query; all if empty
+	Where    *Group
+	OrderBy  []OrderCond
+	Limit    int // -1 means no limit
+	Offset   int
+}
+
+// Group is a group graph pattern.
+type Group struct {
+	Patterns  []malle.Pattern
+	Filters   []Expr
+	Optionals []*Group
+}
+
+// OrderCond is an ordering condition of the ORDER BY clause.
+type OrderCond struct {
+	Expr Expr
+	Desc bool
+}
+
+// Result is the result of a executed query. Depending on the query form,
+// either Bindings, Graph or Boolean is set.
+type Result struct {
+	Form     Form
+	Vars     []malle.Variable
+	Bindings []malle.Binding // SELECT
+	Graph    rdf.Graph       // CONSTRUCT and DESCRIBE
+	Boolean  bool            // ASK
+}
+
+// Exec parses and executes the query against the triple store.
+func Exec(db *malle.Store, query string) (*Result, error) {
+	q, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.Exec(db)
+}
+
+// Common IRIs
+var (
+	rdfType    = rdf.IRI("http://www.w3.org/1999/02/22-rdf-syntax-ns#type")
+	xsdBoolean = rdf.XSDBoolean
+	xsdInteger = rdf.XSDInteger
+	xsdDecimal = rdf.XSDDecimal
+	xsdDouble  = rdf.IRI("http://www.w3.org/2001/XMLSchema#double")
+)
@@ -0,0 +1,260 @@
+package malle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// rdfType is the predicate linking resources to their classes.
+var rdfType = rdf.IRI("http://www.w3.org/1999/02/22-rdf-syntax-ns#type")
+
+// PredicateStats holds statistics about the triples with a predicate.
+type PredicateStats struct {
+	Predicate rdf.IRI
+	Triples   int
+	Subjects  int // number of distinct subjects
+	Objects   int // number of distinct objects
+}
+
+// ClassStats holds the number of instances of a class, that is the number
+// of resources with the class as rdf:type.
+type ClassStats struct {
+	Class     rdf.IRI
+	Instances int
+}
+
+// PredicateStats returns statistics for all predicates in use, ordered by
+// descending number of triples. The statistics are maintained as triples
+// are added and removed, so this is cheap.
+func (db *Store) PredicateStats() (stats []PredicateStats, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bStats).ForEach(func(k, v []byte) error {
+			if len(k) != 4 {
+				// The number of instances of a class
+				return nil
+			}
+			t, err := db.getTerm(tx, btou32(k))
+			if err != nil {
+				return err
+			}
+			c := decodeStats(v)
+			stats = append(stats, PredicateStats{
+				Predicate: t.(rdf.IRI),
+				Triples:   int(c[0]),
+				Subjects:  int(c[1]),
+				Objects:   int(c[2]),
+			})
+			return nil
+		})
+	})
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Triples > stats[j].Triples })
+	return stats, err
+}
+
+// ClassStats returns the number of instances of all classes in use, ordered
+// by descending number of instances. Like the predicate statistics, the
+// counts are maintained as triples are added and removed.
+func (db *Store) ClassStats() (stats []ClassStats, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		typeID, err := db.getID(tx, rdfType)
+		if err == ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		prefix := u32tob(typeID)
+		cur := tx.Bucket(bStats).Cursor()
+		for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			if len(k) != 8 {
+				// The statistics of rdf:type as a predicate
+				continue
+			}
+			t, err := db.getTerm(tx, btou32(k[4:]))
+			if err != nil {
+				return err
+			}
+			class, ok := t.(rdf.IRI)
+			if !ok {
+				// A literal class is not a class
+				continue
+			}
+			stats = append(stats, ClassStats{Class: class, Instances: int(btou64(v))})
+		}
+		return nil
+	})
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Instances > stats[j].Instances })
+	return stats, err
+}
+
+// predicateStats returns the number of triples, distinct subjects and
+// distinct objects of the predicate with the given ID.
+func (db *Store) predicateStats(tx *bolt.Tx, p uint32) [3]uint64 {
+	return decodeStats(tx.Bucket(bStats).Get(u32tob(p)))
+}
+
+// updateStats adds the given deltas to the number of triples, distinct
+// subjects and distinct objects of the predicate with the given ID.
+func (db *Store) updateStats(tx *bolt.Tx, p uint32, triples, subjects, objects int) error {
+	bkt := tx.Bucket(bStats)
+	key := u32tob(p)
+	c := decodeStats(bkt.Get(key))
+	for i, d := range []int{triples, subjects, objects} {
+		c[i] = uint64(int64(c[i]) + int64(d))
+	}
+	if c[0] == 0 {
+		return bkt.Delete(key)
+	}
+	return bkt.Put(key, encodeStats(c))
+}
+
+// updateClassStats adds delta to the number of instances of the class of
+// each of the given triples with rdf:type as predicate.
+func (db *Store) updateClassStats(tx *bolt.Tx, triples [][3]uint32, delta int) error {
+	var typeID uint32
+	isType := make(map[uint32]bool)
+	deltas := make(map[uint32]int)
+	for _, tr := range triples {
+		is, ok := isType[tr[1]]
+		if !ok {
+			is = db.isType(tx, tr[1])
+			isType[tr[1]] = is
+		}
+		if is {
+			typeID = tr[1]
+			deltas[tr[2]] += delta
+		}
+	}
+	bkt := tx.Bucket(bStats)
+	for class, d := range deltas {
+		key := classKey(typeID, class)
+		var n uint64
+		if v := bkt.Get(key); len(v) == 8 {
+			n = btou64(v)
+		}
+		n = uint64(int64(n) + int64(d))
+		if n == 0 {
+			if err := bkt.Delete(key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := bkt.Put(key, u64tob(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isType returns true if the term with the given ID is rdf:type. Unlike
+// getID, it never stores the namespace of rdf:type.
+func (db *Store) isType(tx *bolt.Tx, id uint32) bool {
+	t, err := db.getTerm(tx, id)
+	return err == nil && t == rdfType
+}
+
+// classKey returns the key of the number of instances of a class in the
+// stats bucket, which is the key of its instances in the POS index.
+func classKey(typeID, class uint32) []byte {
+	return append(u32tob(typeID), u32tob(class)...)
+}
+
+// countAllStats counts the statistics of all predicates and classes from
+// the indices, replacing any stored statistics.
+func (db *Store) countAllStats(tx *bolt.Tx) error {
+	counts, classes, typeID, err := db.countStats(tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.DeleteBucket(bStats); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	bkt, err := tx.CreateBucket(bStats)
+	if err != nil {
+		return err
+	}
+	for p, c := range counts {
+		if err := bkt.Put(u32tob(p), encodeStats(c)); err != nil {
+			return err
+		}
+	}
+	for class, n := range classes {
+		if err := bkt.Put(classKey(typeID, class), u64tob(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countStats counts the number of triples and distinct subjects of each
+// predicate from the SPO index, and the number of distinct objects from
+// the POS index, along with the number of instances of each class. It also
+// returns the ID of rdf:type, or 0 if it is not in use as a predicate.
+func (db *Store) countStats(tx *bolt.Tx) (counts map[uint32][3]uint64, classes map[uint32]uint64, typeID uint32, err error) {
+	counts = make(map[uint32][3]uint64)
+	classes = make(map[uint32]uint64)
+	err = tx.Bucket(bSPO).ForEach(func(k, v []byte) error {
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+			return err
+		}
+		p := btou32(k[4:])
+		c := counts[p]
+		c[0] += bitmap.GetCardinality()
+		c[1]++
+		counts[p] = c
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	for p := range counts {
+		if db.isType(tx, p) {
+			typeID = p
+		}
+	}
+	err = tx.Bucket(bPOS).ForEach(func(k, v []byte) error {
+		p := btou32(k)
+		c := counts[p]
+		c[2]++
+		counts[p] = c
+		if p == typeID && typeID != 0 {
+			bitmap := roaring.NewRoaringBitmap()
+			if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+				return err
+			}
+			classes[btou32(k[4:])] = bitmap.GetCardinality()
+		}
+		return nil
+	})
+	return counts, classes, typeID, err
+}
+
+func encodeStats(c [3]uint64) []byte {
+	b := make([]byte, 24)
+	for i, n := range c {
+		binary.BigEndian.PutUint64(b[i*8:], n)
+	}
+	return b
+}
+
+func decodeStats(b []byte) (c [3]uint64) {
+	if len(b) != 24 {
+		return c
+	}
+	for i := range c {
+		c[i] = binary.BigEndian.Uint64(b[i*8:])
+	}
+	return c
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,132 @@
+package malle
+
+import (
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestStatistics(t *testing.T) {
+	var (
+		knows  = mustNewIRI("http://ex.org/statsKnows")
+		name   = mustNewIRI("http://ex.org/statsName")
+		person = mustNewIRI("http://ex.org/StatsPerson")
+		robot  = mustNewIRI("http://ex.org/StatsRobot")
+		a      = mustNewIRI("http://ex.org/statsA")
+		b      = mustNewIRI("http://ex.org/statsB")
+		c      = mustNewIRI("http://ex.org/statsC")
+	)
+	g := rdf.NewGraph()
+	g.Add(rdf.NewTriple(a, knows, b))
+	g.Add(rdf.NewTriple(a, knows, c))
+	g.Add(rdf.NewTriple(b, knows, c))
+	g.Add(rdf.NewTriple(a, rdfType, person))
+	g.Add(rdf.NewTriple(b, rdfType, person))
+	g.Add(rdf.NewTriple(c, rdfType, robot))
+	if err := testDB.ImportGraph(g); err != nil {
+		t.Fatal(err)
+	}
+	names := rdf.NewGraph()
+	names.Add(rdf.NewTriple(a, name, mustNewLiteral("A")))
+	names.Add(rdf.NewTriple(b, name, mustNewLiteral("B")))
+	names.Add(rdf.NewTriple(c, name, mustNewLiteral("A")))
+	if err := testDB.BulkImportGraph(names); err != nil {
+		t.Fatal(err)
+	}
+
+	predStats := func() map[rdf.IRI]PredicateStats {
+		stats, err := testDB.PredicateStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(stats); i++ {
+			if stats[i].Triples > stats[i-1].Triples {
+				t.Errorf("Store.PredicateStats() not ordered by number of triples")
+			}
+		}
+		m := make(map[rdf.IRI]PredicateStats)
+		for _, s := range stats {
+			m[s.Predicate] = s
+		}
+		return m
+	}
+	classStats := func() map[rdf.IRI]int {
+		stats, err := testDB.ClassStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[rdf.IRI]int)
+		for _, s := range stats {
+			m[s.Class] = s.Instances
+		}
+		return m
+	}
+
+	ps := predStats()
+	for _, want := range []PredicateStats{
+		{knows, 3, 2, 2},
+		{name, 3, 3, 2},
+	} {
+		if got := ps[want.Predicate]; got != want {
+			t.Errorf("Store.PredicateStats()[%v] == %+v; want %+v", want.Predicate, got, want)
+		}
+	}
+	cs := classStats()
+	if cs[person] != 2 || cs[robot] != 1 {
+		t.Errorf("Store.ClassStats() == %v; want 2 instances of %v and 1 of %v", cs, person, robot)
+	}
+
+	// Removing triples updates the counts.
+	if err := testDB.RemoveTriple(rdf.NewTriple(b, knows, c)); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.RemoveTriple(rdf.NewTriple(c, name, mustNewLiteral("A"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.RemoveTriple(rdf.NewTriple(c, rdfType, robot)); err != nil {
+		t.Fatal(err)
+	}
+	ps = predStats()
+	for _, want := range []PredicateStats{
+		{knows, 2, 1, 2},
+		{name, 2, 2, 2},
+	} {
+		if got := ps[want.Predicate]; got != want {
+			t.Errorf("Store.PredicateStats()[%v] after removal == %+v; want %+v", want.Predicate, got, want)
+		}
+	}
+	if cs := classStats(); cs[person] != 2 || cs[robot] != 0 {
+		t.Errorf("Store.ClassStats() after removal == %v; want 2 instances of %v and none of %v", cs, person, robot)
+	}
+
+	// Re-adding triples counts them again, whether added one by one or in
+	// bulk.
+	if err := testDB.AddTriple(rdf.NewTriple(c, rdfType, robot)); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.BulkImportGraph(rdf.NewGraph().Add(rdf.NewTriple(b, rdfType, robot)).Add(rdf.NewTriple(a, rdfType, person))); err != nil {
+		t.Fatal(err)
+	}
+	if cs := classStats(); cs[person] != 2 || cs[robot] != 2 {
+		t.Errorf("Store.ClassStats() after re-adding == %v; want 2 instances of %v and 2 of %v", cs, person, robot)
+	}
+	if err := testDB.RemoveTriple(rdf.NewTriple(b, rdfType, robot)); err != nil {
+		t.Fatal(err)
+	}
+	if cs := classStats(); cs[person] != 2 || cs[robot] != 1 {
+		t.Errorf("Store.ClassStats() after removal == %v; want 2 instances of %v and 1 of %v", cs, person, robot)
+	}
+
+	if problems, err := testDB.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() == %v, %v; want no problems", problems, err)
+	}
+
+	for _, tr := range append(g.Triples(), names.Triples()...) {
+		if err := testDB.RemoveTriple(tr); err != nil && err != ErrNotFound {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := predStats()[knows]; ok {
+		t.Errorf("Store.PredicateStats() has %v after all its triples are removed", knows)
+	}
+}
@@ -0,0 +1,376 @@
+package malle
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// SearchOptions restricts the resources matched by Search.
+type SearchOptions struct {
+	// Predicates, if given, only matches the query against literals
+	// of these predicates.
+	Predicates []rdf.IRI
+
+	// Langs, if given, only matches the query against literals with
+	// these language tags, or subtags of them; "en" matches "en-GB".
+	Langs []string
+
+	// Limit is the maximum number of resources returned. If 0, at most
+	// MaxResults are returned.
+	Limit int
+}
+
+// Search finds the resources with string or language tagged literals holding
+// all the words of the query. Words are matched regardless of case and
+// diacritics, so "hamsun knut" finds a resource with the name "Knut Hamsun",
+// or with the first name "Knut" and the last name "Hamsun".
+func (db *Store) Search(query string, opts SearchOptions) (res []rdf.IRI, err error) {
+	tokens := tokenize(query)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = MaxResults
+	}
+
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bIdxText)
+		acc := roaring.NewRoaringBitmap()
+		for i, token := range tokens {
+			bo := bkt.Get([]byte(token))
+			if bo == nil {
+				return nil
+			}
+			bitmap := roaring.NewRoaringBitmap()
+			if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+				return err
+			}
+			if i == 0 {
+				acc = bitmap
+			} else {
+				acc.And(bitmap)
+			}
+		}
+
+		filter, err := db.newTextFilter(tx, opts)
+		if err != nil {
+			return err
+		}
+		for it := acc.Iterator(); it.HasNext() && len(res) < limit; {
+			id := it.Next()
+			if filter != nil {
+				ok, err := filter.matches(id, tokens)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+			}
+			t, err := db.getTerm(tx, id)
+			if err != nil {
+				return err
+			}
+			res = append(res, t.(rdf.IRI))
+		}
+		return nil
+	})
+	return res, err
+}
+
+// maxTokenLen is the maximum length in bytes of a token. Longer words, ex
+// base64 data stored as a string, are truncated, since the tokens are keys
+// of the text index, and the keys of bolt are limited in size.
+const maxTokenLen = 256
+
+// tokenize splits the text into words of letters and digits, folded to lower
+// case without diacritics, and truncated to maxTokenLen bytes. Each word is
+// returned once.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	})
+	seen := make(map[string]bool, len(words))
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		t := truncate(fold(w), maxTokenLen)
+		if t != "" && !seen[t] {
+			seen[t] = true
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// truncate returns the first n bytes of s, less any partial rune at the end.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// foldings maps letters with diacritics to their base letters.
+var foldings = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ľ': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ŕ': "r", 'ř': "r", 'ß': "ss", 'ś': "s", 'š': "s", 'ş': "s", 'ť': "t", 'ţ': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// fold returns the word in lower case, without diacritics.
+func fold(word string) string {
+	var b bytes.Buffer
+	for _, r := range strings.ToLower(word) {
+		if f, ok := foldings[r]; ok {
+			b.WriteString(f)
+		} else if !unicode.Is(unicode.Mn, r) {
+			// Combining marks are dropped.
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// textOf returns the text of the term, if it is a string or language tagged
+// literal, along with its language tag.
+func textOf(t rdf.Term) (text, lang string, ok bool) {
+	l, ok := t.(rdf.Literal)
+	if !ok || (l.DataType() != rdf.XSDString && l.DataType() != rdf.RDFLangString) {
+		return "", "", false
+	}
+	return l.Value().(string), l.Lang(), true
+}
+
+// literalTokens returns the tokens of the literal with the given ID, or nil
+// if it is not a string or language tagged literal. The tokens are cached.
+func (db *Store) literalTokens(tx *bolt.Tx, id uint32, cache map[uint32][]string) ([]string, error) {
+	if tokens, ok := cache[id]; ok {
+		return tokens, nil
+	}
+	b := tx.Bucket(bTerms).Get(u32tob(id))
+	if b == nil {
+		return nil, ErrNotFound
+	}
+	var tokens []string
+	if b[0] != 0x00 {
+		if text, _, ok := textOf(db.decode(b)); ok {
+			tokens = tokenize(text)
+		}
+	}
+	cache[id] = tokens
+	return tokens, nil
+}
+
+// indexText adds the subjects of the triples to the text index, under the
+// tokens of their literal objects.
+func (db *Store) indexText(tx *bolt.Tx, triples [][3]uint32) error {
+	subjects := make(map[string][]uint32)
+	cache := make(map[uint32][]string)
+	for _, tr := range triples {
+		tokens, err := db.literalTokens(tx, tr[2], cache)
+		if err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			subjects[t] = append(subjects[t], tr[0])
+		}
+	}
+
+	bkt := tx.Bucket(bIdxText)
+	for t, ids := range subjects {
+		bitmap := roaring.NewRoaringBitmap()
+		if bo := bkt.Get([]byte(t)); bo != nil {
+			if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+				return err
+			}
+		}
+		changed := false
+		for _, id := range ids {
+			if bitmap.CheckedAdd(id) {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		var b bytes.Buffer
+		if _, err := bitmap.WriteTo(&b); err != nil {
+			return err
+		}
+		if err := bkt.Put([]byte(t), b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexText removes the subject from the text index under the tokens of
+// the object, unless the subject has other literals with the same tokens.
+// It must be called after the triple is removed from the indices, but before
+// its terms are.
+func (db *Store) unindexText(tx *bolt.Tx, s, o uint32) error {
+	cache := make(map[uint32][]string)
+	tokens, err := db.literalTokens(tx, o, cache)
+	if err != nil || len(tokens) == 0 {
+		return err
+	}
+
+	remaining := make(map[string]bool)
+	err = db.subjectTriples(tx, s, func(p, o uint32) error {
+		tokens, err := db.literalTokens(tx, o, cache)
+		for _, t := range tokens {
+			remaining[t] = true
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	bkt := tx.Bucket(bIdxText)
+	for _, t := range tokens {
+		if remaining[t] {
+			continue
+		}
+		if err := setInIndex(bkt, []byte(t), s, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subjectTriples calls fn with the predicate and object IDs of every triple
+// with the given subject.
+func (db *Store) subjectTriples(tx *bolt.Tx, s uint32, fn func(p, o uint32) error) error {
+	prefix := u32tob(s)
+	cur := tx.Bucket(bSPO).Cursor()
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+			return err
+		}
+		p := btou32(k[4:])
+		for it := bitmap.Iterator(); it.HasNext(); {
+			if err := fn(p, it.Next()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexAllText builds the text index from all stored triples.
+func (db *Store) indexAllText(tx *bolt.Tx) error {
+	var triples [][3]uint32
+	err := tx.Bucket(bSPO).ForEach(func(k, v []byte) error {
+		bitmap := roaring.NewRoaringBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+			return err
+		}
+		for it := bitmap.Iterator(); it.HasNext(); {
+			triples = append(triples, [3]uint32{btou32(k), btou32(k[4:]), it.Next()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.indexText(tx, triples)
+}
+
+// textFilter verifies that the words of a search are found in the literals
+// of the predicates and languages asked for.
+type textFilter struct {
+	db    *Store
+	tx    *bolt.Tx
+	preds map[uint32]bool // nil if any predicate
+	langs []string
+	cache map[uint32][]string
+}
+
+// newTextFilter returns a filter for the search options, or nil if there is
+// nothing to filter on.
+func (db *Store) newTextFilter(tx *bolt.Tx, opts SearchOptions) (*textFilter, error) {
+	if len(opts.Predicates) == 0 && len(opts.Langs) == 0 {
+		return nil, nil
+	}
+	f := &textFilter{db: db, tx: tx, cache: make(map[uint32][]string)}
+	for _, l := range opts.Langs {
+		f.langs = append(f.langs, strings.ToLower(l))
+	}
+	if len(opts.Predicates) > 0 {
+		f.preds = make(map[uint32]bool)
+		for _, p := range opts.Predicates {
+			id, err := db.getID(tx, p)
+			if err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			f.preds[id] = true
+		}
+	}
+	return f, nil
+}
+
+// matches checks if the subject has all the tokens in its literals of the
+// predicates and languages of the filter.
+func (f *textFilter) matches(s uint32, tokens []string) (bool, error) {
+	found := make(map[string]bool)
+	err := f.db.subjectTriples(f.tx, s, func(p, o uint32) error {
+		if f.preds != nil && !f.preds[p] {
+			return nil
+		}
+		if len(f.langs) > 0 {
+			b := f.tx.Bucket(bTerms).Get(u32tob(o))
+			if b == nil || b[0] == 0x00 {
+				return nil
+			}
+			if _, lang, ok := textOf(f.db.decode(b)); !ok || !f.langMatches(lang) {
+				return nil
+			}
+		}
+		tokens, err := f.db.literalTokens(f.tx, o, f.cache)
+		for _, t := range tokens {
+			found[t] = true
+		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, t := range tokens {
+		if !found[t] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// langMatches checks if the language tag is one of the filter's languages,
+// or a subtag of one.
+func (f *textFilter) langMatches(lang string) bool {
+	lang = strings.ToLower(lang)
+	for _, l := range f.langs {
+		if lang == l || strings.HasPrefix(lang, l+"-") {
+			return true
+		}
+	}
+	return false
+}
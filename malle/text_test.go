@@ -0,0 +1,132 @@
+package malle
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"Knut Hamsun", []string{"knut", "hamsun"}},
+		{"  Sult (1890), roman; SULT!", []string{"sult", "1890", "roman"}},
+		{"Ærlige Åse Ødegård", []string{"aerlige", "ase", "odegard"}},
+		{"Crème brûlée", []string{"creme", "brulee"}},
+		{"Cre\u0300me", []string{"creme"}}, // combining grave accent
+		{strings.Repeat("a", 2*maxTokenLen), []string{strings.Repeat("a", maxTokenLen)}},
+		{"a" + strings.Repeat("ж", maxTokenLen), []string{"a" + strings.Repeat("ж", maxTokenLen/2-1)}}, // no partial runes
+	}
+	for _, tt := range tests {
+		got := tokenize(tt.in)
+		if len(got) != len(tt.want) {
+			t.Errorf("tokenize(%q) == %q; want %q", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("tokenize(%q) == %q; want %q", tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	var (
+		name      = mustNewIRI("http://ex.org/searchName")
+		firstName = mustNewIRI("http://ex.org/searchFirstName")
+		lastName  = mustNewIRI("http://ex.org/searchLastName")
+		title     = mustNewIRI("http://ex.org/searchTitle")
+		hamsun    = mustNewIRI("http://ex.org/searchHamsun")
+		undset    = mustNewIRI("http://ex.org/searchUndset")
+		sult      = mustNewIRI("http://ex.org/searchSult")
+	)
+	g := rdf.NewGraph()
+	g.Add(rdf.NewTriple(hamsun, firstName, mustNewLiteral("Knuth")))
+	g.Add(rdf.NewTriple(hamsun, lastName, mustNewLiteral("Hamsund")))
+	g.Add(rdf.NewTriple(undset, name, mustNewLiteral("Sigrit Undsett")))
+	g.Add(rdf.NewTriple(sult, title, mustNewLangLiteral("Sulten", "no")))
+	g.Add(rdf.NewTriple(sult, title, mustNewLangLiteral("Hungry", "en-GB")))
+	g.Add(rdf.NewTriple(sult, name, mustNewLiteral("Hamsund, Knuth: Sulten")))
+	if err := testDB.ImportGraph(g); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		opts  SearchOptions
+		want  []rdf.IRI
+	}{
+		{"hamsund knuth", SearchOptions{}, []rdf.IRI{hamsun, sult}},
+		{"HAMSUND", SearchOptions{Predicates: []rdf.IRI{lastName}}, []rdf.IRI{hamsun}},
+		{"knuth hamsund", SearchOptions{Predicates: []rdf.IRI{lastName}}, nil},
+		{"sulten", SearchOptions{Langs: []string{"no"}}, []rdf.IRI{sult}},
+		{"hungry", SearchOptions{Langs: []string{"en"}}, []rdf.IRI{sult}},
+		{"hungry", SearchOptions{Langs: []string{"no"}}, nil},
+		{"sigrit undsett", SearchOptions{}, []rdf.IRI{undset}},
+		{"sigrit hamsund", SearchOptions{}, nil},
+		{"", SearchOptions{}, nil},
+	}
+	check := func() {
+		for _, tt := range tests {
+			got, err := testDB.Search(tt.query, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if len(got) != len(tt.want) {
+				t.Errorf("Store.Search(%q, %+v) == %v; want %v", tt.query, tt.opts, got, tt.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Store.Search(%q, %+v) == %v; want %v", tt.query, tt.opts, got, tt.want)
+					break
+				}
+			}
+		}
+	}
+	check()
+	if res, _ := testDB.Search("hamsund", SearchOptions{Limit: 1}); len(res) != 1 {
+		t.Errorf("Store.Search(%q) with limit 1 == %v; want 1 result", "hamsund", res)
+	}
+
+	// A subject stays indexed under a word as long as one of its literals
+	// holds it.
+	if err := testDB.RemoveTriple(rdf.NewTriple(sult, name, mustNewLiteral("Hamsund, Knuth: Sulten"))); err != nil {
+		t.Fatal(err)
+	}
+	tests = tests[:3]
+	tests[0].want = []rdf.IRI{hamsun}
+	tests = append(tests, struct {
+		query string
+		opts  SearchOptions
+		want  []rdf.IRI
+	}{"sulten", SearchOptions{}, []rdf.IRI{sult}})
+	check()
+
+	if err := testDB.DeleteGraph(g); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := testDB.Search("sulten", SearchOptions{}); len(res) != 0 {
+		t.Errorf("Store.Search(%q) after removing all triples == %v; want none", "sulten", res)
+	}
+
+	// Long words, ex base64 data, are truncated in the index.
+	long := rdf.NewTriple(sult, name, mustNewLiteral("data:"+strings.Repeat("A", 30000)))
+	if err := testDB.ImportGraph(rdf.NewGraph().Add(long)); err != nil {
+		t.Fatalf("Store.ImportGraph() with a long word == %v; want <nil>", err)
+	}
+	if res, _ := testDB.Search(strings.Repeat("a", 50000), SearchOptions{}); len(res) != 1 || res[0] != sult {
+		t.Errorf("Store.Search() of a long word == %v; want [%v]", res, sult)
+	}
+	if err := testDB.RemoveTriple(long); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,65 @@
+package malle
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+// Tx is a transaction on the triple store. It is only valid inside the
+// function given to Store.Update or Store.View.
+type Tx struct {
+	db *Store
+	tx *bolt.Tx
+}
+
+// Update executes the function within a read-write transaction. If the
+// function returns an error, the transaction is rolled back and none of its
+// changes are stored; otherwise it is committed.
+func (db *Store) Update(fn func(*Tx) error) error {
+	return db.update(func(tx *bolt.Tx) error {
+		return fn(&Tx{db: db, tx: tx})
+	})
+}
+
+// View executes the function within a read-only transaction, giving it
+// a consistent view of the store.
+func (db *Store) View(fn func(*Tx) error) error {
+	return db.kv.View(func(tx *bolt.Tx) error {
+		return fn(&Tx{db: db, tx: tx})
+	})
+}
+
+// Add stores the given Triple in each of the given named graphs, or in the
+// default graph if none are given.
+func (tx *Tx) Add(tr rdf.Triple, graphs ...rdf.IRI) error {
+	if !tx.tx.Writable() {
+		return bolt.ErrTxNotWritable
+	}
+	return tx.db.addTriple(tx.tx, tr, graphs)
+}
+
+// Remove removes the given Triple from each of the given named graphs, or
+// from the default graph if none are given. It also removes any Term unique
+// to that Triple from the store.
+// It returns ErrNotFound if the Triple does not exist in the graphs.
+func (tx *Tx) Remove(tr rdf.Triple, graphs ...rdf.IRI) error {
+	if !tx.tx.Writable() {
+		return bolt.ErrTxNotWritable
+	}
+	return tx.db.removeTripleFrom(tx.tx, tr, graphs)
+}
+
+// Has checks if the given Triple is stored in any of the given graphs, or in
+// any graph at all if none are given. Changes made earlier in the transaction
+// are visible.
+func (tx *Tx) Has(tr rdf.Triple, graphs ...rdf.IRI) (bool, error) {
+	return tx.db.hasTriple(tx.tx, tr, graphs)
+}
+
+// Match returns an iterator over the triples matching the given pattern, as
+// described for Store.Match. The iterator must not be used after the
+// transaction has ended, and must not be used while the store is modified
+// within the same transaction.
+func (tx *Tx) Match(s, p rdf.IRI, o rdf.Term) (*TripleIterator, error) {
+	return tx.db.match(tx.tx, s, p, o)
+}
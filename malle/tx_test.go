@@ -0,0 +1,101 @@
+package malle
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestTx(t *testing.T) {
+	s := mustNewIRI("tx1")
+	old := rdf.NewTriple(s, mustNewIRI("txp1"), mustNewLiteral("old"))
+	added := []rdf.Triple{
+		rdf.NewTriple(s, mustNewIRI("txp1"), mustNewLiteral("new")),
+		rdf.NewTriple(s, mustNewIRI("txp2"), mustNewIRI("tx2")),
+	}
+	if err := testDB.AddTriple(old); err != nil {
+		t.Fatalf("Store.AddTriple(%v) == %v; want no error", old, err)
+	}
+	startStats := testDB.Stats()
+
+	// A failing transaction leaves the store untouched.
+	errAbort := errors.New("abort")
+	err := testDB.Update(func(tx *Tx) error {
+		if err := tx.Remove(old); err != nil {
+			return err
+		}
+		for _, tr := range added {
+			if err := tx.Add(tr); err != nil {
+				return err
+			}
+		}
+		if ok, err := tx.Has(added[0]); err != nil || !ok {
+			t.Errorf("Tx.Has(%v) == %v, %v; want true, <nil>", added[0], ok, err)
+		}
+		if err := tx.Add(rdf.NewTriple(mustNewIRI("http://tx.org/ns/s"), s, s)); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("Store.Update() == %v; want %v", err, errAbort)
+	}
+	if ok, _ := testDB.HasTriple(old); !ok {
+		t.Errorf("rolled back Store.Update() removed %v", old)
+	}
+	if ok, _ := testDB.HasTriple(added[0]); ok {
+		t.Errorf("rolled back Store.Update() stored %v", added[0])
+	}
+	if stats := testDB.Stats(); stats.NumTriples != startStats.NumTriples {
+		t.Errorf("Store.Stats().NumTriples == %d after rollback; want %d", stats.NumTriples, startStats.NumTriples)
+	}
+	if _, ok := testDB.ns.FindByStr("http://tx.org/ns/"); ok {
+		t.Errorf("rolled back Store.Update() left namespace in dictionary")
+	}
+
+	err = testDB.Update(func(tx *Tx) error {
+		if err := tx.Remove(old); err != nil {
+			return err
+		}
+		for _, tr := range added {
+			if err := tx.Add(tr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Store.Update() == %v; want no error", err)
+	}
+	if stats := testDB.Stats(); stats.NumTriples != startStats.NumTriples+1 {
+		t.Errorf("Store.Stats().NumTriples == %d; want %d", stats.NumTriples, startStats.NumTriples+1)
+	}
+
+	err = testDB.View(func(tx *Tx) error {
+		if err := tx.Add(old); err == nil {
+			t.Errorf("Tx.Add() in read-only transaction succeeded; want error")
+		}
+		it, err := tx.Match(s, "", nil)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		got := rdf.NewGraph()
+		for tr, err := it.Next(); err != io.EOF; tr, err = it.Next() {
+			if err != nil {
+				return err
+			}
+			got.Add(tr)
+		}
+		want := rdf.NewGraph().Add(added[0]).Add(added[1])
+		if !got.Eq(want) {
+			t.Errorf("Tx.Match(%v, \"\", nil) == %v; want %v", s, got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Store.View() == %v; want no error", err)
+	}
+}
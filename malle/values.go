@@ -0,0 +1,226 @@
+package malle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+	"github.com/tgruben/roaring"
+)
+
+// ErrUnordered is returned when a range is given by literals which are not of
+// an ordered datatype, or of datatypes which cannot be compared.
+var ErrUnordered = errors.New("literals not of a comparable ordered datatype")
+
+// Kinds of values in the value index. Values of different kinds are not
+// comparable.
+const (
+	valueNumeric  byte = 'n' // float64
+	valueTemporal byte = 't' // seconds and nanoseconds since Unix epoch
+)
+
+// valueKinds maps the indexed datatypes to the kind of their values.
+var valueKinds = map[string]byte{
+	"decimal":            valueNumeric,
+	"integer":            valueNumeric,
+	"double":             valueNumeric,
+	"float":              valueNumeric,
+	"byte":               valueNumeric,
+	"short":              valueNumeric,
+	"int":                valueNumeric,
+	"long":               valueNumeric,
+	"unsignedByte":       valueNumeric,
+	"unsignedShort":      valueNumeric,
+	"unsignedInt":        valueNumeric,
+	"unsignedLong":       valueNumeric,
+	"positiveInteger":    valueNumeric,
+	"nonNegativeInteger": valueNumeric,
+	"negativeInteger":    valueNumeric,
+	"nonPositiveInteger": valueNumeric,
+	"date":               valueTemporal,
+	"dateTime":           valueTemporal,
+	"dateTimeStamp":      valueTemporal,
+	"gYear":              valueTemporal,
+}
+
+// encodeValue returns the value index key of a literal, without the term ID,
+// or false if the literal is not of an indexed datatype or is not valid.
+//
+// The keys sort in the order of the values: numbers are encoded as float64,
+// and dates, dateTimes and years as the instant they start at, in UTC if no
+// timezone is given. Very large integers thus lose precision.
+func encodeValue(l rdf.Literal) ([]byte, bool) {
+	dt := string(l.DataType())
+	if !strings.HasPrefix(dt, "http://www.w3.org/2001/XMLSchema#") {
+		return nil, false
+	}
+	kind, ok := valueKinds[strings.TrimPrefix(dt, "http://www.w3.org/2001/XMLSchema#")]
+	if !ok {
+		return nil, false
+	}
+	lexical := strings.TrimSpace(fmt.Sprint(l.Value()))
+
+	switch kind {
+	case valueNumeric:
+		f, err := strconv.ParseFloat(lexical, 64)
+		if err != nil || math.IsNaN(f) {
+			return nil, false
+		}
+		b := make([]byte, 9)
+		b[0] = kind
+		binary.BigEndian.PutUint64(b[1:], sortableFloat(f))
+		return b, true
+	default:
+		t, ok := parseTemporal(strings.TrimPrefix(dt, "http://www.w3.org/2001/XMLSchema#"), lexical)
+		if !ok {
+			return nil, false
+		}
+		b := make([]byte, 13)
+		b[0] = kind
+		binary.BigEndian.PutUint64(b[1:], uint64(t.Unix())^(1<<63))
+		binary.BigEndian.PutUint32(b[9:], uint32(t.Nanosecond()))
+		return b, true
+	}
+}
+
+// sortableFloat maps a float64 to an uint64 with the same order.
+func sortableFloat(f float64) uint64 {
+	u := math.Float64bits(f)
+	if u&(1<<63) != 0 {
+		return ^u
+	}
+	return u | (1 << 63)
+}
+
+// parseTemporal parses the lexical form of a date, dateTime or gYear.
+func parseTemporal(typ, s string) (time.Time, bool) {
+	var layouts []string
+	switch typ {
+	case "date":
+		layouts = []string{"2006-01-02", "2006-01-02Z07:00"}
+	case "gYear":
+		layouts = []string{"2006", "2006Z07:00"}
+	default:
+		layouts = []string{"2006-01-02T15:04:05.999999999", time.RFC3339Nano}
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// indexValue adds the term to the value index, if it is a literal of an
+// indexed datatype.
+func (db *Store) indexValue(tx *bolt.Tx, id uint32, term rdf.Term) error {
+	l, ok := term.(rdf.Literal)
+	if !ok {
+		return nil
+	}
+	k, ok := encodeValue(l)
+	if !ok {
+		return nil
+	}
+	return tx.Bucket(bIdxValues).Put(append(k, u32tob(id)...), []byte{})
+}
+
+// unindexValue removes the term of the given stored encoding from the value
+// index.
+func (db *Store) unindexValue(tx *bolt.Tx, id uint32, b []byte) error {
+	if len(b) == 0 || b[0] == 0x00 {
+		// IRI
+		return nil
+	}
+	l, ok := db.decode(b).(rdf.Literal)
+	if !ok {
+		return nil
+	}
+	k, ok := encodeValue(l)
+	if !ok {
+		return nil
+	}
+	return tx.Bucket(bIdxValues).Delete(append(k, u32tob(id)...))
+}
+
+// indexAllValues adds all stored literals of indexed datatypes to the value
+// index.
+func (db *Store) indexAllValues(tx *bolt.Tx) error {
+	return tx.Bucket(bTerms).ForEach(func(k, v []byte) error {
+		if len(v) == 0 || v[0] == 0x00 {
+			// IRI
+			return nil
+		}
+		return db.indexValue(tx, btou32(k), db.decode(v))
+	})
+}
+
+// valueRange returns the IDs of the stored literals with values between min
+// and max, inclusive. Either can be nil, for an open-ended range, but not
+// both. The IDs are returned as a serialized bitmap.
+func (db *Store) valueRange(tx *bolt.Tx, min, max rdf.Term) ([]byte, error) {
+	var lo, hi []byte
+	for _, t := range []struct {
+		term rdf.Term
+		key  *[]byte
+	}{{min, &lo}, {max, &hi}} {
+		if t.term == nil {
+			continue
+		}
+		l, ok := t.term.(rdf.Literal)
+		if !ok {
+			return nil, ErrUnordered
+		}
+		if *t.key, ok = encodeValue(l); !ok {
+			return nil, ErrUnordered
+		}
+	}
+	switch {
+	case lo == nil && hi == nil:
+		return nil, ErrUnordered
+	case lo == nil:
+		lo = []byte{hi[0]}
+	case hi == nil:
+		hi = []byte{lo[0] + 1}
+	case lo[0] != hi[0]:
+		return nil, ErrUnordered
+	}
+
+	bitmap := roaring.NewRoaringBitmap()
+	cur := tx.Bucket(bIdxValues).Cursor()
+	for k, _ := cur.Seek(lo); k != nil; k, _ = cur.Next() {
+		if bytes.Compare(k[:len(k)-4], hi) > 0 {
+			break
+		}
+		bitmap.Add(btou32(k[len(k)-4:]))
+	}
+	var b bytes.Buffer
+	if _, err := bitmap.WriteTo(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// idSet is a set of term IDs, kept both as a serialized bitmap, for
+// intersecting with index bitmaps, and as a lookup function.
+type idSet struct {
+	bitmap      []byte
+	contains    func(uint32) bool
+	cardinality uint64
+}
+
+// newIDSet returns the set of IDs in the serialized bitmap.
+func newIDSet(b []byte) (idSet, error) {
+	bitmap := roaring.NewRoaringBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(b)); err != nil {
+		return idSet{}, err
+	}
+	return idSet{bitmap: b, contains: bitmap.Contains, cardinality: bitmap.GetCardinality()}, nil
+}
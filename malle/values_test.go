@@ -0,0 +1,160 @@
+package malle
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/x/malle/rdf"
+)
+
+func TestEncodeValueOrder(t *testing.T) {
+	xsd := func(s string) rdf.IRI { return rdf.IRI("http://www.w3.org/2001/XMLSchema#" + s) }
+	tests := [][]rdf.Literal{
+		{
+			mustNewTypedLiteral("-1e10", xsd("double")),
+			mustNewLiteral(-12),
+			mustNewTypedLiteral("-0.5", xsd("decimal")),
+			mustNewTypedLiteral("0", xsd("integer")),
+			mustNewTypedLiteral("0.25", xsd("float")),
+			mustNewLiteral(uint(7)),
+			mustNewTypedLiteral("1850", xsd("int")),
+		},
+		{
+			mustNewTypedLiteral("1850", xsd("gYear")),
+			mustNewTypedLiteral("1850-03-01", xsd("date")),
+			mustNewTypedLiteral("1850-03-01T12:00:00", xsd("dateTime")),
+			mustNewTypedLiteral("1850-03-01T12:00:00-01:00", xsd("dateTime")),
+			mustNewTypedLiteral("1970-01-01", xsd("date")),
+			mustNewTypedLiteral("2016-12-01T00:00:00.5Z", xsd("dateTime")),
+		},
+	}
+	for _, lits := range tests {
+		var keys []string
+		for _, l := range lits {
+			k, ok := encodeValue(l)
+			if !ok {
+				t.Fatalf("encodeValue(%v) not ok", l)
+			}
+			keys = append(keys, string(k))
+		}
+		if !sort.StringsAreSorted(keys) {
+			t.Errorf("encodeValue of %v not in order", lits)
+		}
+	}
+
+	for _, l := range []rdf.Literal{
+		mustNewLiteral("1850"),
+		mustNewTypedLiteral("abc", xsd("integer")),
+		mustNewTypedLiteral("1850-13-01", xsd("date")),
+		mustNewTypedLiteral("true", xsd("boolean")),
+	} {
+		if _, ok := encodeValue(l); ok {
+			t.Errorf("encodeValue(%v) ok; want not indexed", l)
+		}
+	}
+}
+
+func TestBGPRange(t *testing.T) {
+	xsd := func(s string) rdf.IRI { return rdf.IRI("http://www.w3.org/2001/XMLSchema#" + s) }
+	born := mustNewIRI("http://ex.org/rangeBorn")
+	g := rdf.NewGraph()
+	for s, year := range map[string]string{
+		"http://ex.org/range1": "1840",
+		"http://ex.org/range2": "1850",
+		"http://ex.org/range3": "1875",
+		"http://ex.org/range4": "1900",
+		"http://ex.org/range5": "1901",
+	} {
+		g.Add(rdf.NewTriple(mustNewIRI(s), born, mustNewTypedLiteral(year, xsd("gYear"))))
+	}
+	// Not a year, so never in range:
+	g.Add(rdf.NewTriple(mustNewIRI("http://ex.org/range6"), born, mustNewLiteral("1860")))
+	if err := testDB.ImportGraph(g); err != nil {
+		t.Fatal(err)
+	}
+	defer testDB.DeleteGraph(g)
+
+	tests := []struct {
+		min, max rdf.Term
+		want     []string
+	}{
+		{mustNewTypedLiteral("1850", xsd("gYear")), mustNewTypedLiteral("1900", xsd("gYear")),
+			[]string{"http://ex.org/range2", "http://ex.org/range3", "http://ex.org/range4"}},
+		{mustNewTypedLiteral("1900-06-01", xsd("date")), nil,
+			[]string{"http://ex.org/range5"}},
+		{nil, mustNewTypedLiteral("1849-12-31T23:59:59", xsd("dateTime")),
+			[]string{"http://ex.org/range1"}},
+		{mustNewTypedLiteral("2000", xsd("gYear")), nil, nil},
+	}
+	for _, tt := range tests {
+		q := NewBGP().Where(Variable("s"), born, Variable("y")).Range("y", tt.min, tt.max)
+		res, err := testDB.Select(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, b := range res {
+			got = append(got, string(b["s"].(rdf.IRI)))
+		}
+		sort.Strings(got)
+		if len(got) != len(tt.want) {
+			t.Errorf("Select with range %v..%v == %v; want %v", tt.min, tt.max, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Select with range %v..%v == %v; want %v", tt.min, tt.max, got, tt.want)
+				break
+			}
+		}
+	}
+
+	// Range on a variable bound by scanning another pattern.
+	q := NewBGP().
+		Where(Variable("s"), born, Variable("y")).
+		Where(Variable("s"), born, Variable("z")).
+		Range("z", mustNewTypedLiteral("1899", xsd("gYear")), nil)
+	if res, err := testDB.Select(q); err != nil || len(res) != 2 {
+		t.Errorf("Select with range on joined variable == %v, %v; want 2 solutions", res, err)
+	}
+
+	_, err := testDB.Select(NewBGP().Where(Variable("s"), born, Variable("y")).
+		Range("y", mustNewLiteral(1), mustNewTypedLiteral("1900", xsd("gYear"))))
+	if err != ErrUnordered {
+		t.Errorf("Select with range of incomparable literals == %v; want ErrUnordered", err)
+	}
+
+	problems, err := testDB.Check()
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Store.Check() == %v, %v; want no problems", problems, err)
+	}
+}
+
+func TestValueIndexBuiltOnInit(t *testing.T) {
+	const file = "_values.db"
+	db, err := Init(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	tr := rdf.NewTriple("http://ex.org/v1", "http://ex.org/vp", mustNewLiteral(42))
+	if err := db.AddTriple(tr); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a database from before the value index.
+	if err := db.kv.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket(bIdxValues) }); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if db, err = Init(file); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	q := NewBGP().Where(Variable("s"), mustNewIRI("http://ex.org/vp"), Variable("o")).Range("o", mustNewLiteral(40), nil)
+	if res, err := db.Select(q); err != nil || len(res) != 1 {
+		t.Errorf("Select with range after Init == %v, %v; want 1 solution", res, err)
+	}
+}